@@ -0,0 +1,315 @@
+package haproxy
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dimension extracts value requests are grouped by
+type Dimension struct {
+	Name  string
+	Value func(r *HTTPRequest) string
+}
+
+// predefined dimensions
+var (
+	DimensionFrontend = Dimension{Name: "frontend", Value: func(r *HTTPRequest) string { return r.FrontendName }}
+	DimensionBackend  = Dimension{Name: "backend", Value: func(r *HTTPRequest) string { return r.BackendName }}
+	DimensionServer   = Dimension{Name: "server", Value: func(r *HTTPRequest) string { return r.ServerName }}
+	DimensionMethod   = Dimension{Name: "method", Value: func(r *HTTPRequest) string { return r.RequestMethod }}
+)
+
+// AggregatorConfig configures Aggregator
+type AggregatorConfig struct {
+	// what requests are grouped by, all requests go into single group if empty
+	Dimensions []Dimension
+	// length of sliding window, 1 minute by default
+	Window time.Duration
+	// window moves in steps of Resolution, 10 seconds by default
+	Resolution time.Duration
+}
+
+// AggregateStats are statistics of a group of requests. Can be merged with stats of the same group from other instances
+type AggregateStats struct {
+	Count uint64 `json:"count"`
+	// by status class, Status[2] counts 2xx. Status[0] counts everything outside 100-599 (like <BADREQ>)
+	Status    [6]uint64 `json:"status"`
+	BytesRead uint64    `json:"bytes_read"`
+	// Tt (Ta in 1.7+ layout)
+	TotalDuration *Histogram `json:"total_duration_ms"`
+	// Tr
+	ResponseHeaderDuration *Histogram                  `json:"response_header_duration_ms"`
+	Terminations           map[TerminationState]uint64 `json:"terminations"`
+}
+
+func newAggregateStats() AggregateStats {
+	return AggregateStats{
+		TotalDuration:          &Histogram{},
+		ResponseHeaderDuration: &Histogram{},
+		Terminations:           make(map[TerminationState]uint64),
+	}
+}
+
+// Add records single request
+func (s *AggregateStats) Add(r *HTTPRequest) {
+	if s.Terminations == nil {
+		*s = newAggregateStats()
+	}
+	s.Count++
+	class := int(r.StatusCode) / 100
+	if class < 1 || class > 5 {
+		class = 0
+	}
+	s.Status[class]++
+	s.BytesRead += r.BytesRead
+	s.TotalDuration.Record(int64(r.TotalTimeMs()))
+	s.ResponseHeaderDuration.Record(int64(r.ResponseHeaderDurationMs))
+	s.Terminations[r.TerminationState()]++
+}
+
+// Merge adds other stats into s
+func (s *AggregateStats) Merge(o AggregateStats) {
+	if s.Terminations == nil {
+		*s = newAggregateStats()
+	}
+	s.Count += o.Count
+	for i := range s.Status {
+		s.Status[i] += o.Status[i]
+	}
+	s.BytesRead += o.BytesRead
+	s.TotalDuration.Merge(o.TotalDuration)
+	s.ResponseHeaderDuration.Merge(o.ResponseHeaderDuration)
+	for k, v := range o.Terminations {
+		s.Terminations[k] += v
+	}
+}
+
+// StatusRatio returns fraction (0-1) of requests with given status class (2 for 2xx)
+func (s AggregateStats) StatusRatio(class int) float64 {
+	if s.Count == 0 || class < 0 || class >= len(s.Status) {
+		return 0
+	}
+	return float64(s.Status[class]) / float64(s.Count)
+}
+
+func (s AggregateStats) clone() AggregateStats {
+	c := s
+	c.TotalDuration = s.TotalDuration.Clone()
+	c.ResponseHeaderDuration = s.ResponseHeaderDuration.Clone()
+	c.Terminations = make(map[TerminationState]uint64, len(s.Terminations))
+	for k, v := range s.Terminations {
+		c.Terminations[k] = v
+	}
+	return c
+}
+
+// AggregateGroup is stats of requests with the same dimension values
+type AggregateGroup struct {
+	// dimension name => value
+	Dimensions map[string]string `json:"dimensions"`
+	Stats      AggregateStats    `json:"stats"`
+}
+
+// AggregateSnapshot is state of all groups in the window
+type AggregateSnapshot struct {
+	// start of the window, or of the first request seen if window isn't full yet
+	Start  time.Time        `json:"start"`
+	End    time.Time        `json:"end"`
+	Groups []AggregateGroup `json:"groups"`
+}
+
+// PerSecond converts count from the snapshot into per-second rate
+func (s AggregateSnapshot) PerSecond(count uint64) float64 {
+	d := s.End.Sub(s.Start).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(count) / d
+}
+
+// Merge merges snapshot from other instance, groups with same dimensions are merged together and window is extended to cover both
+func (s *AggregateSnapshot) Merge(o AggregateSnapshot) {
+	if s.Start.IsZero() || (!o.Start.IsZero() && o.Start.Before(s.Start)) {
+		s.Start = o.Start
+	}
+	if o.End.After(s.End) {
+		s.End = o.End
+	}
+	idx := make(map[string]int, len(s.Groups))
+	for i, g := range s.Groups {
+		idx[groupMapKey(g.Dimensions)] = i
+	}
+	for _, g := range o.Groups {
+		if i, ok := idx[groupMapKey(g.Dimensions)]; ok {
+			s.Groups[i].Stats.Merge(g.Stats)
+			continue
+		}
+		stats := newAggregateStats()
+		stats.Merge(g.Stats)
+		s.Groups = append(s.Groups, AggregateGroup{Dimensions: g.Dimensions, Stats: stats})
+		idx[groupMapKey(g.Dimensions)] = len(s.Groups) - 1
+	}
+}
+
+func groupMapKey(dims map[string]string) string {
+	keys := make([]string, 0, len(dims))
+	for k, v := range dims {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\x00")
+}
+
+type aggregateGroup struct {
+	values []string
+	stats  AggregateStats
+}
+
+// Aggregator groups requests by dimensions over sliding window of time.
+// Time is taken from requests so replaying old logs works the same as live ones; window ends at the newest request seen.
+// For live data use Advance or SnapshotAt with current time, so groups that stopped getting requests expire.
+// It is safe for concurrent use
+type Aggregator struct {
+	lock    sync.Mutex
+	cfg     AggregatorConfig
+	buckets map[int64]map[string]*aggregateGroup
+	// newest and first bucket index, valid once started
+	newest  int64
+	first   int64
+	started bool
+	late    uint64
+}
+
+// NewAggregator creates Aggregator
+func NewAggregator(cfg AggregatorConfig) *Aggregator {
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.Resolution <= 0 {
+		cfg.Resolution = 10 * time.Second
+	}
+	if cfg.Resolution > cfg.Window {
+		cfg.Resolution = cfg.Window
+	}
+	return &Aggregator{cfg: cfg, buckets: make(map[int64]map[string]*aggregateGroup)}
+}
+
+func (a *Aggregator) bucketCount() int64 {
+	return int64((a.cfg.Window + a.cfg.Resolution - 1) / a.cfg.Resolution)
+}
+
+// Add records request
+func (a *Aggregator) Add(r *HTTPRequest) {
+	idx := (r.TS * int64(time.Microsecond)) / int64(a.cfg.Resolution)
+	values := make([]string, len(a.cfg.Dimensions))
+	for i, d := range a.cfg.Dimensions {
+		values[i] = d.Value(r)
+	}
+	key := strings.Join(values, "\x00")
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.started && idx <= a.newest-a.bucketCount() {
+		a.late++
+		return
+	}
+	a.advance(idx)
+	if idx < a.first {
+		a.first = idx
+	}
+	bucket, ok := a.buckets[idx]
+	if !ok {
+		bucket = make(map[string]*aggregateGroup)
+		a.buckets[idx] = bucket
+	}
+	g, ok := bucket[key]
+	if !ok {
+		g = &aggregateGroup{values: values, stats: newAggregateStats()}
+		bucket[key] = g
+	}
+	g.stats.Add(r)
+}
+
+// Advance moves end of the window to now if it is newer than the last request, dropping buckets that fell out of it
+func (a *Aggregator) Advance(now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.advance(now.UnixNano() / int64(a.cfg.Resolution))
+}
+
+func (a *Aggregator) advance(idx int64) {
+	if a.started && idx <= a.newest {
+		return
+	}
+	if !a.started {
+		a.first = idx
+		a.started = true
+	}
+	a.newest = idx
+	for i := range a.buckets {
+		if i <= a.newest-a.bucketCount() {
+			delete(a.buckets, i)
+		}
+	}
+}
+
+// Late returns number of requests dropped because they were older than the window
+func (a *Aggregator) Late() uint64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.late
+}
+
+// SnapshotAt is Advance and Snapshot in one step
+func (a *Aggregator) SnapshotAt(now time.Time) AggregateSnapshot {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.advance(now.UnixNano() / int64(a.cfg.Resolution))
+	return a.snapshot()
+}
+
+// Snapshot returns stats of the current window, groups are sorted by dimension values
+func (a *Aggregator) Snapshot() AggregateSnapshot {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.snapshot()
+}
+
+func (a *Aggregator) snapshot() AggregateSnapshot {
+	var s AggregateSnapshot
+	if !a.started {
+		return s
+	}
+	start := a.newest - a.bucketCount() + 1
+	if a.first > start {
+		start = a.first
+	}
+	s.Start = time.Unix(0, start*int64(a.cfg.Resolution))
+	s.End = time.Unix(0, (a.newest+1)*int64(a.cfg.Resolution))
+	merged := make(map[string]*aggregateGroup)
+	var keys []string
+	for _, bucket := range a.buckets {
+		for key, g := range bucket {
+			m, ok := merged[key]
+			if !ok {
+				m = &aggregateGroup{values: g.values, stats: g.stats.clone()}
+				merged[key] = m
+				keys = append(keys, key)
+				continue
+			}
+			m.stats.Merge(g.stats)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		g := merged[key]
+		dims := make(map[string]string, len(g.values))
+		for i, d := range a.cfg.Dimensions {
+			dims[d.Name] = g.values[i]
+		}
+		s.Groups = append(s.Groups, AggregateGroup{Dimensions: dims, Stats: g.stats})
+	}
+	return s
+}
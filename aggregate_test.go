@@ -0,0 +1,119 @@
+package haproxy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator(AggregatorConfig{Dimensions: []Dimension{DimensionBackend, DimensionServer}, Window: time.Minute, Resolution: 10 * time.Second})
+	assert.Empty(t, a.Snapshot().Groups)
+	start := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	add := func(offset time.Duration, backend string, status int16, total int, state string) {
+		a.Add(&HTTPRequest{
+			TS:                       start.Add(offset).UnixMicro(),
+			BackendName:              backend,
+			ServerName:               "srv1",
+			StatusCode:               status,
+			BytesRead:                100,
+			TotalDurationMs:          total,
+			ResponseHeaderDurationMs: total / 2,
+			TerminationReason:        rune(state[0]),
+			SessionCloseState:        rune(state[1]),
+			ClientPersistenceState:   rune(state[2]),
+			PersistenceCookieState:   rune(state[3]),
+		})
+	}
+	for i := 0; i < 100; i++ {
+		add(time.Duration(i)*100*time.Millisecond, "app", 200, i, "----")
+	}
+	add(5*time.Second, "app", 503, 5000, "sD--")
+	add(5*time.Second, "static", 404, 1, "----")
+	add(15*time.Second, "app", -1, -1, "CR--")
+
+	s := a.Snapshot()
+	require.Len(t, s.Groups, 2)
+	app := s.Groups[0]
+	assert.Equal(t, map[string]string{"backend": "app", "server": "srv1"}, app.Dimensions)
+	assert.EqualValues(t, 102, app.Stats.Count)
+	assert.EqualValues(t, 100, app.Stats.Status[2])
+	assert.EqualValues(t, 1, app.Stats.Status[5])
+	assert.EqualValues(t, 1, app.Stats.Status[0])
+	assert.EqualValues(t, 10200, app.Stats.BytesRead)
+	assert.InDelta(t, 50, app.Stats.TotalDuration.Quantile(0.5), 2)
+	assert.EqualValues(t, 5000, app.Stats.TotalDuration.Quantile(1))
+	assert.EqualValues(t, 1, app.Stats.TotalDuration.Negative)
+	assert.EqualValues(t, 1, app.Stats.Terminations["sD--"])
+	assert.EqualValues(t, 100, app.Stats.Terminations["----"])
+	assert.InDelta(t, 100.0/102, app.Stats.StatusRatio(2), 0.0001)
+	assert.Equal(t, "static", s.Groups[1].Dimensions["backend"])
+	// window isn't full yet, it starts at the first request
+	assert.Equal(t, start, s.Start.UTC())
+	assert.Equal(t, start.Add(20*time.Second), s.End.UTC())
+	assert.InDelta(t, 5.1, s.PerSecond(app.Stats.Count), 0.001)
+
+	// window slides with request time, first 10s drop out
+	add(65*time.Second, "app", 200, 1, "----")
+	s = a.Snapshot()
+	assert.EqualValues(t, 2, s.Groups[0].Stats.Count)
+	assert.Len(t, s.Groups, 1)
+	assert.Equal(t, start.Add(10*time.Second), s.Start.UTC())
+	// too old
+	add(time.Second, "app", 200, 1, "----")
+	assert.EqualValues(t, 1, a.Late())
+	// snapshot is a copy
+	s.Groups[0].Stats.TotalDuration.Record(1)
+	assert.EqualValues(t, 1, a.Snapshot().Groups[0].Stats.TotalDuration.Total)
+
+	// no more requests, wall clock moves the window
+	a.Advance(start.Add(10 * time.Second))
+	assert.Len(t, a.Snapshot().Groups, 1)
+	s = a.SnapshotAt(start.Add(75 * time.Second))
+	assert.EqualValues(t, 1, s.Groups[0].Stats.Count)
+	s = a.SnapshotAt(start.Add(130 * time.Second))
+	assert.Empty(t, s.Groups)
+	assert.Equal(t, start.Add(140*time.Second), s.End.UTC())
+	add(75*time.Second, "app", 200, 1, "----")
+	assert.EqualValues(t, 2, a.Late())
+}
+
+func TestAggregatorAdvance(t *testing.T) {
+	a := NewAggregator(AggregatorConfig{Window: time.Minute, Resolution: 10 * time.Second})
+	start := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	// started by the clock, before first request
+	s := a.SnapshotAt(start)
+	assert.Empty(t, s.Groups)
+	assert.Equal(t, start, s.Start.UTC())
+	assert.Equal(t, start.Add(10*time.Second), s.End.UTC())
+	a.Add(&HTTPRequest{TS: start.Add(25 * time.Second).UnixMicro(), StatusCode: 200})
+	s = a.SnapshotAt(start.Add(29 * time.Second))
+	require.Len(t, s.Groups, 1)
+	assert.InDelta(t, 1.0/30, s.PerSecond(s.Groups[0].Stats.Count), 0.0001)
+}
+
+func TestAggregateSnapshotMerge(t *testing.T) {
+	start := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	a := NewAggregator(AggregatorConfig{Dimensions: []Dimension{DimensionFrontend}})
+	b := NewAggregator(AggregatorConfig{Dimensions: []Dimension{DimensionFrontend}})
+	a.Add(&HTTPRequest{TS: start.UnixMicro(), FrontendName: "web", StatusCode: 200, TotalDurationMs: 10})
+	b.Add(&HTTPRequest{TS: start.UnixMicro(), FrontendName: "web", StatusCode: 500, TotalDurationMs: 30})
+	b.Add(&HTTPRequest{TS: start.UnixMicro(), FrontendName: "api", StatusCode: 200, TotalDurationMs: 20})
+
+	// snapshots survive serialization
+	data, err := json.Marshal(b.Snapshot())
+	require.NoError(t, err)
+	var bs AggregateSnapshot
+	require.NoError(t, json.Unmarshal(data, &bs))
+
+	s := a.Snapshot()
+	s.Merge(bs)
+	require.Len(t, s.Groups, 2)
+	assert.EqualValues(t, 2, s.Groups[0].Stats.Count)
+	assert.EqualValues(t, 30, s.Groups[0].Stats.TotalDuration.Max)
+	assert.EqualValues(t, 10, s.Groups[0].Stats.TotalDuration.Min)
+	assert.Equal(t, "api", s.Groups[1].Dimensions["frontend"])
+}
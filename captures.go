@@ -0,0 +1,184 @@
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CaptureLayout describes what is captured in the config, in the same order as `capture request header`
+// and `capture response header` (or `declare capture`) lines, e.g.
+//
+//	CaptureLayout{Request: []string{"Host", "User-Agent"}, Response: []string{"Location"}}
+//
+// for `{example.com|curl/7.0} {/login}`
+type CaptureLayout struct {
+	Request  []string
+	Response []string
+}
+
+// Empty returns true if nothing is captured
+func (l CaptureLayout) Empty() bool {
+	return len(l.Request) == 0 && len(l.Response) == 0
+}
+
+// Decode splits captured blocks into request and response headers. Empty captures are skipped.
+// Escaped (\| \}) and #XX-encoded characters are decoded
+func (l CaptureLayout) Decode(captured string) (request map[string]string, response map[string]string, err error) {
+	blocks, err := splitCaptureBlocks(captured)
+	if err != nil {
+		return nil, nil, err
+	}
+	expected := 0
+	if len(l.Request) > 0 {
+		expected++
+	}
+	if len(l.Response) > 0 {
+		expected++
+	}
+	if len(blocks) != expected {
+		return nil, nil, errors.New(fmt.Sprintf("expected %d capture blocks, got %d in [%s]", expected, len(blocks), captured))
+	}
+	if len(l.Request) > 0 {
+		request, err = captureFields(l.Request, blocks[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		blocks = blocks[1:]
+	}
+	if len(l.Response) > 0 {
+		response, err = captureFields(l.Response, blocks[0])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return request, response, nil
+}
+
+// DecodeCaptures fills RequestHeaders and ResponseHeaders from CapturedSamples using given layout
+func (h *HTTPRequest) DecodeCaptures(l CaptureLayout) (err error) {
+	h.RequestHeaders, h.ResponseHeaders, err = l.Decode(h.CapturedSamples)
+	return err
+}
+
+// applyCaptureLayout decodes captures if layout is set, mismatch is kept in CaptureError instead of failing the whole line
+func (h *HTTPRequest) applyCaptureLayout(l CaptureLayout) {
+	if l.Empty() {
+		return
+	}
+	if err := h.DecodeCaptures(l); err != nil {
+		h.CaptureError = err.Error()
+	}
+}
+
+// RequestCookie returns name and value of captured request cookie (`capture cookie`), empty if there was none
+func (h HTTPRequest) RequestCookie() (name, value string) {
+	return splitCookie(h.CapturedRequestCookie)
+}
+
+// ResponseCookie returns name and value of captured Set-Cookie, without attributes
+func (h HTTPRequest) ResponseCookie() (name, value string) {
+	return splitCookie(h.CapturedResponseCookie)
+}
+
+func splitCookie(s string) (string, string) {
+	if s == "-" || s == "" {
+		return "", ""
+	}
+	s = decodeCaptured(s)
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		s = s[:i]
+	}
+	name, value := s, ""
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		name, value = s[:i], s[i+1:]
+	}
+	return strings.TrimSpace(name), strings.TrimSpace(value)
+}
+
+// splitCaptureBlocks splits ` {a|b} {c}` into raw contents of blocks
+func splitCaptureBlocks(s string) ([]string, error) {
+	var blocks []string
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return blocks, nil
+		}
+		if s[0] != '{' {
+			return nil, errors.New(fmt.Sprintf("capture block should start with { in [%s]", s))
+		}
+		end := -1
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '}' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return nil, errors.New(fmt.Sprintf("unterminated capture block [%s]", s))
+		}
+		blocks = append(blocks, s[1:end])
+		s = s[end+1:]
+	}
+}
+
+func captureFields(names []string, block string) (map[string]string, error) {
+	var fields []string
+	start := 0
+	for i := 0; i < len(block); i++ {
+		if block[i] == '\\' {
+			i++
+		} else if block[i] == '|' {
+			fields = append(fields, block[start:i])
+			start = i + 1
+		}
+	}
+	fields = append(fields, block[start:])
+	if len(fields) != len(names) {
+		return nil, errors.New(fmt.Sprintf("expected %d captures, got %d in {%s}", len(names), len(fields), block))
+	}
+	out := make(map[string]string, len(names))
+	for i, f := range fields {
+		if v := decodeCaptured(f); v != "" {
+			out[names[i]] = v
+		}
+	}
+	return out, nil
+}
+
+// decodeCaptured decodes backslash escapes and #XX encoding haproxy uses for special characters
+func decodeCaptured(s string) string {
+	if !strings.ContainsAny(s, `\#`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case s[i] == '#' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,102 @@
+package haproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureLayout(t *testing.T) {
+	both := CaptureLayout{Request: []string{"Host", "User-Agent"}, Response: []string{"Location"}}
+	req, resp, err := both.Decode(` {example.com|curl/7.0} {/login}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Host": "example.com", "User-Agent": "curl/7.0"}, req)
+	assert.Equal(t, map[string]string{"Location": "/login"}, resp)
+
+	// empty captures are skipped
+	req, resp, err = both.Decode(` {3001.localhost|} {}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Host": "3001.localhost"}, req)
+	assert.Empty(t, resp)
+
+	// escaped and encoded separators
+	req, _, err = both.Decode(` {a\|b|x#7Cy \} z#22} {#0D}`)
+	require.NoError(t, err)
+	assert.Equal(t, "a|b", req["Host"])
+	assert.Equal(t, `x|y } z"`, req["User-Agent"])
+
+	req, resp, err = CaptureLayout{Request: []string{"Host"}}.Decode(`{example.com}`)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", req["Host"])
+	assert.Nil(t, resp)
+	req, resp, err = CaptureLayout{Response: []string{"Location", "Server"}}.Decode(` {/|nginx}`)
+	require.NoError(t, err)
+	assert.Nil(t, req)
+	assert.Equal(t, map[string]string{"Location": "/", "Server": "nginx"}, resp)
+
+	for _, bad := range []string{` {a|b}`, ` {a|b} {c} {d}`, ` {a} {c}`, ` {a|b|c} {d}`, ` {a|b} {c`, ` a|b {c}`} {
+		_, _, err = both.Decode(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestCaptureCookies(t *testing.T) {
+	h := HTTPRequest{CapturedRequestCookie: "SESSID=abc123", CapturedResponseCookie: "SESSID=def; path=/; HttpOnly"}
+	name, value := h.RequestCookie()
+	assert.Equal(t, "SESSID", name)
+	assert.Equal(t, "abc123", value)
+	name, value = h.ResponseCookie()
+	assert.Equal(t, "SESSID", name)
+	assert.Equal(t, "def", value)
+	h.CapturedRequestCookie = "-"
+	name, value = h.RequestCookie()
+	assert.Empty(t, name)
+	assert.Empty(t, value)
+}
+
+func TestCaptureLayoutDecoders(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	dec := HTTPLogDecoder{Captures: CaptureLayout{Request: []string{"Host"}, Response: []string{"Location", "Server"}}}
+	line := `<158>Sep  1 17:50:37 haproxy[1866]: 127.0.0.1:52320 [01/Sep/2022:17:50:37.898] default local-3001/<NOSRV> 0/-1/-1/-1/0 503 253 - - SC-- 1/1/0/0/0 0/0 {3001.localhost} {|} "GET /slow/default HTTP/1.1"`
+	expected, err := dec.Decode(line)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Host": "3001.localhost"}, expected.RequestHeaders)
+	assert.Equal(t, map[string]string{}, expected.ResponseHeaders)
+	assert.Empty(t, expected.CaptureError)
+	var out HTTPRequest
+	require.NoError(t, dec.DecodeInto(line, &out))
+	assert.Equal(t, expected, out)
+	f := MustCompileLogFormat(HTTPLogFormat15)
+	f.Captures = dec.Captures
+	out, err = f.Decode(line)
+	require.NoError(t, err)
+	assert.Equal(t, expected, out)
+	ev, err := dec.DecodeLog(line)
+	require.NoError(t, err)
+	assert.Equal(t, expected, ev)
+
+	// default decoders leave captures alone
+	out, err = DecodeHTTPLog(line)
+	require.NoError(t, err)
+	assert.Nil(t, out.RequestHeaders)
+	assert.Empty(t, out.CaptureError)
+
+	// mismatched layout doesn't fail the line, it is recorded on the request
+	dec.Captures = CaptureLayout{Request: []string{"Host"}}
+	out, err = dec.Decode(line)
+	require.NoError(t, err)
+	assert.Nil(t, out.RequestHeaders)
+	assert.Equal(t, "expected 1 capture blocks, got 2 in [ {3001.localhost} {|}]", out.CaptureError)
+	var scanned HTTPRequest
+	require.NoError(t, dec.DecodeInto(line, &scanned))
+	assert.Equal(t, out, scanned)
+
+	// so is line without captures at all
+	out, err = dec.Decode(scanTestLines[0])
+	require.NoError(t, err)
+	assert.Equal(t, "expected 1 capture blocks, got 0 in []", out.CaptureError)
+	require.NoError(t, dec.DecodeInto(scanTestLines[0], &scanned))
+	assert.Equal(t, out, scanned)
+}
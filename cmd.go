@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ACL struct {
@@ -27,6 +28,9 @@ type ACL struct {
 // HAProxy socket interface
 type Conn struct {
 	socketPath string
+	// per-session settings, sent before every command as each command uses new connection
+	severityOutput string
+	cliTimeout     time.Duration
 }
 
 // pattern for ACLs originating from config files
@@ -149,16 +153,46 @@ func (c *Conn) ClearACL(acl string) error {
 
 // Run arbitrary haproxy command and return output
 func (c *Conn) RunCmd(cmd string) ([]string, error) {
+	out, _, err := c.RunCmdSeverity(cmd)
+	return out, err
+}
+
+// RunCmdSeverity is RunCmd that also returns severity (syslog level, 3 is error, 6 info) haproxy prefixed the response with.
+// It is -1 if SetSeverityOutput is off or the response had none, haproxy only adds it to messages and not to dumps like `show stat`.
+// The prefix is stripped from the output
+func (c *Conn) RunCmdSeverity(cmd string) ([]string, int, error) {
+	severity := -1
 	conn, err := net.Dial("unix", c.socketPath)
 	var out []string
 	if err != nil {
-		return out, err
+		return out, severity, err
 	}
 	defer conn.Close()
-	fmt.Fprintf(conn, "%s\n", cmd)
+	fmt.Fprintf(conn, "%s%s\n", c.sessionPrefix(), cmd)
 	scanner := bufio.NewScanner(conn)
+	if err := c.skipSessionPrefix(scanner); err != nil {
+		return out, severity, err
+	}
 	for scanner.Scan() {
-		out = append(out, scanner.Text())
+		line := scanner.Text()
+		if len(out) == 0 && c.severityOutput != "" {
+			severity, line = cutSeverity(line)
+		}
+		out = append(out, line)
+	}
+	return out, severity, scanner.Err()
+}
+
+// Run command that prints nothing (or "Done.") on success, anything else is returned as error
+func (c *Conn) runCmdNoOutput(cmd string) error {
+	out, err := c.RunCmd(cmd)
+	if err != nil {
+		return err
+	}
+	for _, line := range out {
+		if line != "" && line != "Done." {
+			return errors.New(fmt.Sprintf("error: %s", strings.Join(out, "\n")))
+		}
 	}
-	return out, scanner.Err()
+	return nil
 }
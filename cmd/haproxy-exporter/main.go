@@ -0,0 +1,74 @@
+// haproxy-exporter receives haproxy logs over syslog, polls the stats socket and serves prometheus metrics from both
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/efigence/go-haproxy/exporter"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	logListen := flag.String("log.listen", "udp://127.0.0.1:50514", "comma separated syslog addresses to receive logs on (udp://, tcp://, unixgram://), empty disables log metrics")
+	socket := flag.String("stats.socket", "", "path to haproxy stats socket, empty disables stats polling")
+	interval := flag.Duration("stats.interval", 15*time.Second, "stats polling interval")
+	webListen := flag.String("web.listen", ":9101", "address to serve metrics on")
+	metricsPath := flag.String("web.path", "/metrics", "path to serve metrics on")
+	namespace := flag.String("namespace", "haproxy", "metric name prefix")
+	flag.Parse()
+
+	exp := exporter.New(exporter.Config{Namespace: *namespace})
+	reg := exp.Registry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var srv *haproxy.LogServer
+	if *logListen != "" {
+		var err error
+		srv, err = haproxy.NewLogServer(haproxy.LogServerConfig{
+			Listen:  strings.Split(*logListen, ","),
+			Handler: exp,
+		})
+		if err != nil {
+			log.Fatalf("error starting log server: %s", err)
+		}
+		for _, a := range srv.Addrs() {
+			log.Printf("receiving logs on %s://%s", a.Network(), a)
+		}
+	}
+	if *socket != "" {
+		conn := haproxy.New(*socket)
+		go exp.RunPoller(ctx, &conn, *interval, func(err error) {
+			log.Printf("error polling stats from %s: %s", *socket, err)
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(*metricsPath, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	web := &http.Server{Addr: *webListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if srv != nil {
+			srv.Shutdown(shutdownCtx)
+		}
+		web.Shutdown(shutdownCtx)
+	}()
+	log.Printf("serving metrics on %s%s", *webListen, *metricsPath)
+	if err := web.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("error serving metrics: %s", err)
+	}
+}
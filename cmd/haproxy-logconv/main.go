@@ -0,0 +1,80 @@
+// haproxy-logconv converts haproxy HTTP logs into CSV, newline-delimited JSON or Parquet
+//
+//	haproxy-logconv -format parquet -o out.parquet /var/log/haproxy.log*
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/efigence/go-haproxy/logwriter"
+)
+
+func main() {
+	format := flag.String("format", logwriter.FormatNDJSON, "output format: csv, ndjson or parquet")
+	output := flag.String("o", "-", "output file, - for stdout")
+	logVersion := flag.String("log-version", string(haproxy.HTTPLogV15), "httplog layout: 1.5, or 1.7 for haproxy 1.7+ timers")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [file or glob...]\nreads stdin if there are no files, compressed files are detected\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	version, err := haproxy.ParseHTTPLogVersion(*logVersion)
+	if err != nil {
+		log.Fatal(err)
+	}
+	readerCfg := haproxy.LogReaderConfig{HTTPLogVersion: version}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	buf := bufio.NewWriterSize(out, 1<<20)
+	w, err := logwriter.New(*format, buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var r *haproxy.LogReader
+	if flag.NArg() == 0 {
+		r, err = haproxy.NewLogReader(os.Stdin, readerCfg)
+	} else {
+		r, err = haproxy.OpenLogs(readerCfg, flag.Args()...)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	n := 0
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := w.Write(&rec.Request); err != nil {
+			log.Fatalf("error writing %s:%d: %s", rec.Source, rec.Line, err)
+		}
+		n++
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := buf.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("converted %d requests, skipped %d lines, %d failed to decode", n, r.Skipped(), r.DecodeErrors())
+}
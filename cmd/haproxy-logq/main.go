@@ -0,0 +1,269 @@
+// haproxy-logq filters haproxy HTTP logs with filter expressions, prints selected fields or counts top groups
+//
+//	haproxy-logq 'backend == "app" && status >= 500' /var/log/haproxy.log*
+//	haproxy-logq -fields ts,client_ip,uri,tt 'tt > 2000' /var/log/haproxy.log
+//	haproxy-logq -top 10 -by backend,termination 'termination_class != "ok"' /var/log/haproxy.log
+//	haproxy-logq -listen udp://127.0.0.1:50514 -top 5 -by server 'status >= 500'
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/efigence/go-haproxy/filter"
+	"github.com/efigence/go-haproxy/logwriter"
+)
+
+type group struct {
+	values []string
+	count  uint64
+}
+
+type query struct {
+	filter *filter.Filter
+	fields []filter.Field
+	by     []filter.Field
+	top    int
+	// flush after every line, for live input
+	stream bool
+
+	lock    sync.Mutex
+	out     *bufio.Writer
+	json    *logwriter.NDJSONWriter
+	groups  map[string]*group
+	matched uint64
+	errors  uint64
+}
+
+func main() {
+	fieldList := flag.String("fields", "", "comma separated fields to print, tab separated; whole request as JSON if empty")
+	byList := flag.String("by", "", "comma separated fields to group by, prints counts instead of requests")
+	top := flag.Int("top", 0, "number of groups to print, 10 if -by is set")
+	listen := flag.String("listen", "", "receive live logs on syslog address (udp://, tcp://, unixgram://) instead of reading files")
+	interval := flag.Duration("interval", 10*time.Second, "how often to print groups when receiving live logs")
+	logVersion := flag.String("log-version", string(haproxy.HTTPLogV15), "httplog layout: 1.5, or 1.7 for haproxy 1.7+ timers")
+	listFields := flag.Bool("list-fields", false, "print available fields and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] 'expression' [file or glob...]\nreads stdin if there are no files, compressed files are detected\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *listFields {
+		fmt.Println(strings.Join(filter.FieldNames(), "\n"))
+		fmt.Println("req.<captured request header>\nres.<captured response header>\nextra.<log-format variable>")
+		return
+	}
+	version, err := haproxy.ParseHTTPLogVersion(*logVersion)
+	if err != nil {
+		log.Fatal(err)
+	}
+	readerCfg := haproxy.LogReaderConfig{HTTPLogVersion: version}
+
+	q := &query{top: *top, stream: *listen != "", out: bufio.NewWriterSize(os.Stdout, 1<<16), groups: make(map[string]*group)}
+	q.filter, err = filter.Compile(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("invalid expression: %s", err)
+	}
+	if q.fields, err = lookupFields(*fieldList); err != nil {
+		log.Fatal(err)
+	}
+	if q.by, err = lookupFields(*byList); err != nil {
+		log.Fatal(err)
+	}
+	if len(q.by) > 0 && q.top <= 0 {
+		q.top = 10
+	}
+	if len(q.fields) == 0 {
+		q.json = logwriter.NewNDJSON(q.out)
+	}
+
+	if *listen != "" {
+		q.live(strings.Split(*listen, ","), *interval, readerCfg.HTTPLogVersion)
+		return
+	}
+	var r *haproxy.LogReader
+	if flag.NArg() < 2 {
+		r, err = haproxy.NewLogReader(os.Stdin, readerCfg)
+	} else {
+		r, err = haproxy.OpenLogs(readerCfg, flag.Args()[1:]...)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := q.add(&rec.Request); err != nil {
+			log.Fatal(err)
+		}
+	}
+	q.finish()
+}
+
+func lookupFields(list string) ([]filter.Field, error) {
+	var out []filter.Field
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		f, err := filter.LookupField(name)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func (q *query) add(r *haproxy.HTTPRequest) error {
+	if !q.filter.Match(r) {
+		return nil
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.matched++
+	if len(q.by) > 0 {
+		values := make([]string, len(q.by))
+		for i, f := range q.by {
+			values[i] = f.String(r)
+		}
+		key := strings.Join(values, "\x00")
+		g, ok := q.groups[key]
+		if !ok {
+			g = &group{values: values}
+			q.groups[key] = g
+		}
+		g.count++
+		return nil
+	}
+	var err error
+	if q.json != nil {
+		err = q.json.Write(r)
+		if err == nil && q.stream {
+			err = q.json.Flush()
+		}
+		return err
+	}
+	for i, f := range q.fields {
+		if i > 0 {
+			q.out.WriteByte('\t')
+		}
+		q.out.WriteString(f.String(r))
+	}
+	q.out.WriteByte('\n')
+	if q.stream {
+		err = q.out.Flush()
+	}
+	return err
+}
+
+// printTop prints top groups, most common first
+func (q *query) printTop() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	groups := make([]*group, 0, len(q.groups))
+	for _, g := range q.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].count != groups[j].count {
+			return groups[i].count > groups[j].count
+		}
+		return strings.Join(groups[i].values, "\x00") < strings.Join(groups[j].values, "\x00")
+	})
+	if len(groups) > q.top {
+		groups = groups[:q.top]
+	}
+	q.out.WriteString("count")
+	for _, f := range q.by {
+		q.out.WriteString("\t" + f.Name)
+	}
+	q.out.WriteByte('\n')
+	for _, g := range groups {
+		q.out.WriteString(strconv.FormatUint(g.count, 10) + "\t" + strings.Join(g.values, "\t") + "\n")
+	}
+	q.out.Flush()
+}
+
+func (q *query) finish() {
+	if len(q.by) > 0 {
+		q.printTop()
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.json != nil {
+		q.json.Close()
+	}
+	q.out.Flush()
+	log.Printf("matched %d requests", q.matched)
+}
+
+// HandleLog gets live logs from LogServer
+func (q *query) HandleLog(l interface{}) {
+	r, ok := l.(haproxy.HTTPRequest)
+	if !ok {
+		return
+	}
+	if err := q.add(&r); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (q *query) HandleError(err error) {
+	q.lock.Lock()
+	q.errors++
+	q.lock.Unlock()
+}
+
+func (q *query) live(listen []string, interval time.Duration, version haproxy.HTTPLogVersion) {
+	srv, err := haproxy.NewLogServer(haproxy.LogServerConfig{Listen: listen, Handler: q, HTTPLogVersion: version})
+	if err != nil {
+		log.Fatalf("error starting log server: %s", err)
+	}
+	for _, a := range srv.Addrs() {
+		log.Printf("receiving logs on %s://%s", a.Network(), a)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-t.C:
+			if len(q.by) > 0 {
+				q.printTop()
+				fmt.Fprintln(q.out)
+			}
+		}
+	}
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	srv.Shutdown(shutdownCtx)
+	q.finish()
+	if q.errors > 0 {
+		log.Printf("%d messages failed to decode", q.errors)
+	}
+}
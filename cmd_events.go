@@ -0,0 +1,200 @@
+//go:build !test
+// +build !test
+
+package haproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RingEvent is a single line read from haproxy ring buffer (`show events`)
+type RingEvent struct {
+	Line string `json:"line"`
+	// Decoded request, set only if line was a HTTP log line
+	Request *HTTPRequest `json:"request,omitempty"`
+	// Set on last event if stream ended with error. Channel is closed right after
+	Err error `json:"-"`
+}
+
+// RingOptions control how ring is read
+type RingOptions struct {
+	// Wait for new events after existing ones are dumped (-w)
+	Wait bool
+	// Skip events already in the buffer, only show new ones (-n). Only makes sense together with Wait
+	SkipExisting bool
+	// layout HTTP log lines are decoded with, defaults to HTTPLogV15
+	HTTPLogVersion HTTPLogVersion
+	// captures from the config, used to fill RequestHeaders and ResponseHeaders of decoded requests
+	CaptureLayout CaptureLayout
+}
+
+// StartupLog is single message from `show startup-logs`
+type StartupLog struct {
+	// NOTICE, WARNING, ALERT or DIAG
+	Level string `json:"level"`
+	PID   int    `json:"pid"`
+	// date, only printed by haproxy < 2.4 (format "123/104532")
+	Date    string `json:"date,omitempty"`
+	Message string `json:"message"`
+}
+
+const (
+	StartupLogNotice  = "NOTICE"
+	StartupLogWarning = "WARNING"
+	StartupLogAlert   = "ALERT"
+	StartupLogDiag    = "DIAG"
+)
+
+// 2.4+:  [WARNING]  (12345) : config : parsing [/etc/haproxy/haproxy.cfg:23] : ...
+// older: [WARNING] 123/104532 (12345) : ...
+var startupLogRegex = regexp.MustCompile(`^\[(\w+)\]\s+(?:(\S+)\s+)?\((\d+)\)\s*:\s?(.*)$`)
+
+// ShowEvents reads ring buffer (event sink) and sends every line to returned channel.
+// HTTP log lines are decoded in opts.HTTPLogVersion layout, with opts.CaptureLayout captures. Channel is closed when haproxy closes connection
+// (which never happens with Wait set) or when ctx is cancelled
+func (c *Conn) ShowEvents(ctx context.Context, ring string, opts RingOptions) (<-chan RingEvent, error) {
+	if ring == "" || strings.ContainsAny(ring, " \t\n;") {
+		return nil, errors.New("ring name should not be empty or contain whitespaces")
+	}
+	if err := opts.HTTPLogVersion.check(); err != nil {
+		return nil, err
+	}
+	cmd := "show events " + ring
+	if opts.Wait {
+		cmd += " -w"
+	}
+	if opts.SkipExisting {
+		cmd += " -n"
+	}
+	return c.followCmd(ctx, cmd, HTTPLogDecoder{Version: opts.HTTPLogVersion, Captures: opts.CaptureLayout})
+}
+
+// ShowStartupLogs returns warnings and alerts emitted while haproxy was starting
+func (c *Conn) ShowStartupLogs() ([]StartupLog, error) {
+	out, err := c.RunCmd("show startup-logs")
+	if err != nil {
+		return nil, err
+	}
+	return parseStartupLogs(out)
+}
+
+// IsAlert returns true for messages that would prevent haproxy from starting
+func (s StartupLog) IsAlert() bool {
+	return s.Level == StartupLogAlert
+}
+
+// IsWarning returns true for warnings
+func (s StartupLog) IsWarning() bool {
+	return s.Level == StartupLogWarning
+}
+
+// followCmd runs command that streams its output and converts each line to RingEvent
+func (c *Conn) followCmd(ctx context.Context, cmd string, dec HTTPLogDecoder) (<-chan RingEvent, error) {
+	conn, err := net.Dial("unix", c.socketPath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintf(conn, "%s%s\n", c.sessionPrefix(), cmd)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ch := make(chan RingEvent)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+	go func() {
+		defer close(ch)
+		defer close(done)
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		if err := c.skipSessionPrefix(scanner); err != nil {
+			sendEvent(ctx, ch, RingEvent{Err: err})
+			return
+		}
+		first := true
+		for scanner.Scan() {
+			line := scanner.Text()
+			ev := RingEvent{Line: line}
+			if first {
+				first = false
+				// errors are messages, so they have severity prefix. Events never do
+				if c.severityOutput != "" {
+					_, line = cutSeverity(line)
+				}
+				if err := ringError(line); err != nil {
+					ev.Err = err
+					sendEvent(ctx, ch, ev)
+					return
+				}
+			}
+			if req, err := dec.Decode(line); err == nil {
+				ev.Request = &req
+			}
+			if !sendEvent(ctx, ch, ev) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			sendEvent(ctx, ch, RingEvent{Err: err})
+		}
+	}()
+	return ch, nil
+}
+
+func sendEvent(ctx context.Context, ch chan RingEvent, ev RingEvent) bool {
+	select {
+	case ch <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func ringError(line string) error {
+	if strings.HasPrefix(line, "No such event sink") ||
+		strings.HasPrefix(line, "Unknown command") ||
+		strings.HasPrefix(line, "Permission denied") {
+		return errors.New(fmt.Sprintf("error: %s", line))
+	}
+	return nil
+}
+
+func parseStartupLogs(lines []string) ([]StartupLog, error) {
+	if err := cmdError(lines); err != nil {
+		return nil, err
+	}
+	var logs []StartupLog
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		m := startupLogRegex.FindStringSubmatch(line)
+		if len(m) < 5 {
+			// multi-line message
+			if len(logs) > 0 {
+				logs[len(logs)-1].Message += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		var l StartupLog
+		l.Level = m[1]
+		l.Date = m[2]
+		l.PID, _ = strconv.Atoi(m[3])
+		l.Message = m[4]
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
@@ -0,0 +1,78 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testStartupLogs = `[NOTICE]   (1866) : haproxy version is 2.8.3
+[WARNING]  (1866) : config : parsing [/etc/haproxy/haproxy.cfg:23] : 'option httplog' not usable with proxy 'stats' (needs 'mode http'). Falling back to 'option tcplog'.
+[ALERT]    (1866) : config : Proxy 'app': unable to find required default_backend: 'missing'.
+   continued here
+[WARNING] 123/104532 (1234) : old style message
+`
+
+func TestShowStartupLogs(t *testing.T) {
+	logs, err := parseStartupLogs(strings.Split(testStartupLogs, "\n"))
+	require.NoError(t, err)
+	require.Len(t, logs, 4)
+	assert.Equal(t, StartupLogNotice, logs[0].Level)
+	assert.Equal(t, 1866, logs[0].PID)
+	assert.Equal(t, "haproxy version is 2.8.3", logs[0].Message)
+	assert.True(t, logs[1].IsWarning())
+	assert.True(t, logs[2].IsAlert())
+	assert.Contains(t, logs[2].Message, "\ncontinued here")
+	assert.Equal(t, "123/104532", logs[3].Date)
+	assert.Equal(t, 1234, logs[3].PID)
+}
+
+func TestShowEvents(t *testing.T) {
+	httpLine := `<158>Aug 12 13:18:51 haproxy[1806]: 127.0.0.1:34636 [12/Aug/2022:13:18:51.161] default local-static/local-81 0/0/0/0/0 200 1537 - - ---- 1/1/0/0/0 0/0 {localhost} {|} "GET / HTTP/1.1"`
+	path := serveTestSocket(t, func(cmd string, conn net.Conn) {
+		switch cmd {
+		case "show events buf0 -w -n":
+			fmt.Fprintf(conn, "<0>2022-08-12T13:18:51.161232+02:00 [00|h2|0|mux_h2.c:2689] rcvd H2 request\n")
+			fmt.Fprintf(conn, "%s\n", httpLine)
+			// keep streaming until client goes away
+			buf := make([]byte, 1)
+			conn.Read(buf)
+		default:
+			fmt.Fprintf(conn, "No such event sink\n")
+		}
+	})
+	c := New(path)
+	t.Run("follow", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ch, err := c.ShowEvents(ctx, "buf0", RingOptions{Wait: true, SkipExisting: true})
+		require.NoError(t, err)
+		ev := <-ch
+		assert.Contains(t, ev.Line, "rcvd H2 request")
+		assert.Nil(t, ev.Request)
+		ev = <-ch
+		require.NotNil(t, ev.Request)
+		assert.Equal(t, "local-81", ev.Request.ServerName)
+		cancel()
+		for range ch {
+		}
+	})
+	t.Run("no such ring", func(t *testing.T) {
+		ch, err := c.ShowEvents(context.Background(), "nope", RingOptions{})
+		require.NoError(t, err)
+		ev := <-ch
+		assert.Error(t, ev.Err)
+		_, ok := <-ch
+		assert.False(t, ok)
+	})
+	t.Run("bad ring name", func(t *testing.T) {
+		_, err := c.ShowEvents(context.Background(), "buf0; shutdown sessions", RingOptions{})
+		assert.Error(t, err)
+	})
+}
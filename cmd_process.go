@@ -0,0 +1,433 @@
+//go:build !test
+// +build !test
+
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ActivityCounter is a single counter from `show activity`
+type ActivityCounter struct {
+	// Raw value as printed by haproxy (some counters like date_now are not integers)
+	Raw string `json:"raw"`
+	// Total for all threads. Older haproxy versions do not print the total, it is then sum of per-thread values
+	Total uint64 `json:"total"`
+	// Per-thread values, in thread order
+	PerThread []uint64 `json:"per_thread"`
+}
+
+// Activity is decoded output of `show activity`
+// Counter names are the same as printed by haproxy (loops, wake_tasks, ctxsw etc.)
+type Activity struct {
+	// thread that executed the command
+	Thread   int                        `json:"thread"`
+	Counters map[string]ActivityCounter `json:"counters"`
+}
+
+// Pool is single memory pool entry from `show pools`
+type Pool struct {
+	Name           string `json:"name"`
+	Size           uint64 `json:"size"`
+	Allocated      uint64 `json:"allocated"`
+	AllocatedBytes uint64 `json:"allocated_bytes"`
+	Used           uint64 `json:"used"`
+	NeededAvg      uint64 `json:"needed_avg"`
+	Failures       uint64 `json:"failures"`
+	Users          uint64 `json:"users"`
+	Shared         bool   `json:"shared"`
+}
+
+// ThreadInfo is single thread entry from `show threads`
+type ThreadInfo struct {
+	// thread number as printed by haproxy (starts from 1)
+	Num int `json:"num"`
+	// thread that executed the command is marked with '*'
+	Current bool `json:"current"`
+	// thread detected as stuck by the watchdog is marked with '>'
+	Marked bool `json:"marked"`
+	// all of the key=value pairs, including ones from continuation lines
+	Fields map[string]string `json:"fields"`
+}
+
+// FD is single file descriptor entry from `show fd`
+type FD struct {
+	FD int `json:"fd"`
+	// full state, e.g. "0x21(cl heopI W:sRa R:srA)"
+	State string `json:"state"`
+	// owner address
+	Owner string `json:"owner"`
+	// I/O callback name, e.g. "listener_accept" or "sock_conn_iocb"
+	IOCB string `json:"iocb"`
+	// I/O callback address
+	IOCBAddr string `json:"iocb_addr"`
+	// all of the key=value pairs
+	Fields map[string]string `json:"fields"`
+}
+
+// PoolDiff is difference between two `show pools` snapshots of the same pool
+type PoolDiff struct {
+	Name           string `json:"name"`
+	Allocated      int64  `json:"allocated"`
+	AllocatedBytes int64  `json:"allocated_bytes"`
+	Used           int64  `json:"used"`
+	Failures       int64  `json:"failures"`
+}
+
+var activityThreadRegex = regexp.MustCompile(`^thr:\s*(\d+)`)
+
+// 2.0:  - Pool vars (16 bytes) : 3 allocated (48 bytes), 3 used, 0 failures, 1 users, @0x55c1ed5e1ac0=00 [SHARED]
+// 2.4+: - Pool vars (16 bytes) : 3 allocated (48 bytes), 3 used, needed_avg 2, 0 failures, 1 users, @0x55c1ed5e1ac0=00 [SHARED]
+// 2.6+: - Pool buffer (16384 bytes) : 3 allocated (49152 bytes), 3 used (~3 by thread caches), needed_avg 2, 0 failures, 1 users, @0x55c1ed5e1ac0 [SHARED]
+var poolRegex = regexp.MustCompile(`^\s*- Pool (\S+) \((\d+) bytes\) : (\d+) allocated \((\d+) bytes\), (\d+) used(?: \([^)]*\))?,(?: needed_avg (\d+),)? (\d+) failures, (\d+) users`)
+
+var threadRegex = regexp.MustCompile(`^\s*(\*?)(>?)\s*Thread\s+(\d+)\s*:(.*)$`)
+
+var fdRegex = regexp.MustCompile(`^\s*(\d+) : (.*)$`)
+
+// Get process activity counters (`show activity`)
+func (c *Conn) ShowActivity() (Activity, error) {
+	out, err := c.RunCmd("show activity")
+	if err != nil {
+		return Activity{}, err
+	}
+	return parseActivity(out)
+}
+
+// Get memory pool usage (`show pools`)
+func (c *Conn) ShowPools() ([]Pool, error) {
+	out, err := c.RunCmd("show pools")
+	if err != nil {
+		return nil, err
+	}
+	return parsePools(out)
+}
+
+// Get state of all threads (`show threads`)
+func (c *Conn) ShowThreads() ([]ThreadInfo, error) {
+	out, err := c.RunCmd("show threads")
+	if err != nil {
+		return nil, err
+	}
+	return parseThreads(out)
+}
+
+// Get list of file descriptors (`show fd`)
+func (c *Conn) ShowFD() ([]FD, error) {
+	out, err := c.RunCmd("show fd")
+	if err != nil {
+		return nil, err
+	}
+	return parseFD(out)
+}
+
+// Counter returns total value of named counter, 0 if it does not exist
+func (a Activity) Counter(name string) uint64 {
+	return a.Counters[name].Total
+}
+
+// Loops returns number of event loop iterations
+func (a Activity) Loops() uint64 {
+	return a.Counter("loops")
+}
+
+// WakeTasks returns number of wake-ups caused by tasks
+func (a Activity) WakeTasks() uint64 {
+	return a.Counter("wake_tasks")
+}
+
+// WakeSignal returns number of wake-ups caused by signals
+func (a Activity) WakeSignal() uint64 {
+	return a.Counter("wake_signal")
+}
+
+// ActivityDiff returns counters that changed between prev and cur snapshot.
+// Counters that went backwards (process restart) are reported as cur value.
+// Non-integer counters (like date_now) are copied from cur
+func ActivityDiff(prev, cur Activity) Activity {
+	d := Activity{
+		Thread:   cur.Thread,
+		Counters: make(map[string]ActivityCounter, len(cur.Counters)),
+	}
+	for name, c := range cur.Counters {
+		p, ok := prev.Counters[name]
+		if !ok || !isUint(c.Raw) {
+			d.Counters[name] = c
+			continue
+		}
+		var diff ActivityCounter
+		diff.Total = counterDiff(p.Total, c.Total)
+		diff.Raw = strconv.FormatUint(diff.Total, 10)
+		if len(p.PerThread) == len(c.PerThread) {
+			diff.PerThread = make([]uint64, len(c.PerThread))
+			for i := range c.PerThread {
+				diff.PerThread[i] = counterDiff(p.PerThread[i], c.PerThread[i])
+			}
+		} else {
+			diff.PerThread = c.PerThread
+		}
+		d.Counters[name] = diff
+	}
+	return d
+}
+
+// PoolsDiff returns per-pool difference between two `show pools` snapshots.
+// Pools that disappeared are reported with negative values, new ones with their current values
+func PoolsDiff(prev, cur []Pool) []PoolDiff {
+	prevByName := make(map[string]Pool, len(prev))
+	for _, p := range prev {
+		prevByName[p.Name] = p
+	}
+	var out []PoolDiff
+	seen := make(map[string]bool, len(cur))
+	for _, c := range cur {
+		p := prevByName[c.Name]
+		seen[c.Name] = true
+		out = append(out, PoolDiff{
+			Name:           c.Name,
+			Allocated:      int64(c.Allocated) - int64(p.Allocated),
+			AllocatedBytes: int64(c.AllocatedBytes) - int64(p.AllocatedBytes),
+			Used:           int64(c.Used) - int64(p.Used),
+			Failures:       int64(c.Failures) - int64(p.Failures),
+		})
+	}
+	for _, p := range prev {
+		if seen[p.Name] {
+			continue
+		}
+		out = append(out, PoolDiff{
+			Name:           p.Name,
+			Allocated:      -int64(p.Allocated),
+			AllocatedBytes: -int64(p.AllocatedBytes),
+			Used:           -int64(p.Used),
+			Failures:       -int64(p.Failures),
+		})
+	}
+	return out
+}
+
+func counterDiff(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+func isUint(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// 2.4+ format:  loops: 3034 [ 1619 1415 ]
+// older format: loops: 1619 1415
+func parseActivity(lines []string) (Activity, error) {
+	a := Activity{Counters: make(map[string]ActivityCounter)}
+	if err := cmdError(lines); err != nil {
+		return a, err
+	}
+	for _, line := range lines {
+		if m := activityThreadRegex.FindStringSubmatch(line); len(m) > 1 {
+			a.Thread, _ = strconv.Atoi(m[1])
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		if name == "" || strings.Contains(name, " ") {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		var c ActivityCounter
+		if i := strings.Index(value, "["); i >= 0 {
+			c.Raw = strings.TrimSpace(value[:i])
+			c.Total, _ = strconv.ParseUint(c.Raw, 10, 64)
+			c.PerThread = parseUintFields(strings.Trim(value[i:], "[] "))
+		} else {
+			fields := strings.Fields(value)
+			if len(fields) == 0 {
+				continue
+			}
+			c.Raw = fields[0]
+			if len(fields) > 1 {
+				c.PerThread = parseUintFields(value)
+				for _, v := range c.PerThread {
+					c.Total += v
+				}
+			} else {
+				c.Total, _ = strconv.ParseUint(c.Raw, 10, 64)
+			}
+		}
+		a.Counters[name] = c
+	}
+	return a, nil
+}
+
+func parseUintFields(s string) []uint64 {
+	fields := strings.Fields(s)
+	out := make([]uint64, 0, len(fields))
+	for _, f := range fields {
+		// newer versions print some per-thread values with unit suffixes, ignore those
+		v, _ := strconv.ParseUint(f, 10, 64)
+		out = append(out, v)
+	}
+	return out
+}
+
+func parsePools(lines []string) ([]Pool, error) {
+	if err := cmdError(lines); err != nil {
+		return nil, err
+	}
+	var pools []Pool
+	for _, line := range lines {
+		m := poolRegex.FindStringSubmatch(line)
+		if len(m) < 9 {
+			continue
+		}
+		var p Pool
+		p.Name = m[1]
+		p.Size, _ = strconv.ParseUint(m[2], 10, 64)
+		p.Allocated, _ = strconv.ParseUint(m[3], 10, 64)
+		p.AllocatedBytes, _ = strconv.ParseUint(m[4], 10, 64)
+		p.Used, _ = strconv.ParseUint(m[5], 10, 64)
+		if m[6] != "" {
+			p.NeededAvg, _ = strconv.ParseUint(m[6], 10, 64)
+		}
+		p.Failures, _ = strconv.ParseUint(m[7], 10, 64)
+		p.Users, _ = strconv.ParseUint(m[8], 10, 64)
+		p.Shared = strings.Contains(line, "[SHARED]")
+		pools = append(pools, p)
+	}
+	return pools, nil
+}
+
+// Thread 1 : id=0x7f1c8e3a7a00 act=0 glob=0 wq=1 rq=0 tl=0 tlsz=0 rqsz=0
+//
+//	stuck=0 prof=0 harmless=1 wantrdv=0
+//	cpu_ns: poll=1083226 now=1088596 diff=5370
+//	curr_task=0
+func parseThreads(lines []string) ([]ThreadInfo, error) {
+	if err := cmdError(lines); err != nil {
+		return nil, err
+	}
+	var threads []ThreadInfo
+	for _, line := range lines {
+		if m := threadRegex.FindStringSubmatch(line); len(m) > 4 {
+			var t ThreadInfo
+			t.Num, _ = strconv.Atoi(m[3])
+			t.Current = m[1] == "*"
+			t.Marked = m[2] == ">"
+			t.Fields = make(map[string]string)
+			addKeyValues(t.Fields, m[4])
+			threads = append(threads, t)
+			continue
+		}
+		if len(threads) == 0 {
+			continue
+		}
+		addKeyValues(threads[len(threads)-1].Fields, line)
+	}
+	return threads, nil
+}
+
+// Int returns integer value of thread field, -1 if it does not exist or is not a number
+func (t ThreadInfo) Int(name string) int64 {
+	v, err := strconv.ParseInt(t.Fields[name], 0, 64)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+// Stuck returns whether watchdog considers thread as stuck
+func (t ThreadInfo) Stuck() bool {
+	return t.Marked || t.Fields["stuck"] == "1"
+}
+
+// 10 : st=0x21(cl heopI W:sRa R:srA) tmask=0x1 umask=0x0 owner=0x55e1f0b6d2d0 iocb=0x55e1ef6a1d70(listener_accept) l.st=RDY fe=GLOBAL
+func parseFD(lines []string) ([]FD, error) {
+	if err := cmdError(lines); err != nil {
+		return nil, err
+	}
+	var fds []FD
+	for _, line := range lines {
+		m := fdRegex.FindStringSubmatch(line)
+		if len(m) < 3 {
+			continue
+		}
+		var fd FD
+		fd.FD, _ = strconv.Atoi(m[1])
+		fd.Fields = make(map[string]string)
+		addKeyValues(fd.Fields, m[2])
+		fd.State = fd.Fields["st"]
+		fd.Owner = fd.Fields["owner"]
+		iocb := fd.Fields["iocb"]
+		if i := strings.Index(iocb, "("); i >= 0 && strings.HasSuffix(iocb, ")") {
+			fd.IOCBAddr = iocb[:i]
+			fd.IOCB = iocb[i+1 : len(iocb)-1]
+		} else {
+			fd.IOCBAddr = iocb
+			fd.IOCB = iocb
+		}
+		fds = append(fds, fd)
+	}
+	return fds, nil
+}
+
+// addKeyValues adds all key=value pairs from the line into m
+// values can contain spaces inside parenthesis, like st=0x21(cl heopI W:sRa R:srA)
+func addKeyValues(m map[string]string, line string) {
+	for _, token := range splitOutsideParens(line) {
+		kv := strings.SplitN(token, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		m[kv[0]] = kv[1]
+	}
+}
+
+func splitOutsideParens(s string) []string {
+	var out []string
+	depth := 0
+	start := -1
+	for i, ch := range s {
+		switch {
+		case ch == '(':
+			depth++
+		case ch == ')' && depth > 0:
+			depth--
+		case (ch == ' ' || ch == '\t') && depth == 0:
+			if start >= 0 {
+				out = append(out, s[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		out = append(out, s[start:])
+	}
+	return out
+}
+
+// cmdError checks for the usual "command unknown/not permitted" responses
+func cmdError(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	first := strings.TrimSpace(lines[0])
+	if strings.HasPrefix(first, "Unknown command") ||
+		strings.HasPrefix(first, "Permission denied") ||
+		strings.HasPrefix(first, "Usage:") {
+		return errors.New(fmt.Sprintf("error: %s", first))
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package haproxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testShowActivity = `thr: 1
+date_now: 1620810375.447217
+ctxsw: 2361 [ 1283 1078 ]
+tasksw: 1176 [ 670 506 ]
+empty_rq: 1239 [ 608 631 ]
+long_rq: 0 [ 0 0 ]
+loops: 3034 [ 1619 1415 ]
+wake_tasks: 10 [ 4 6 ]
+wake_signal: 0 [ 0 0 ]
+poll_io: 1164 [ 616 548 ]
+accepted: 3 [ 2 1 ]
+`
+
+var testShowActivityOld = `thread_id: 0
+date_now: 1620810375.447217
+loops: 1619 1415
+wake_tasks: 4 6
+`
+
+var testShowPools = `Dumping pools usage. Use SIGQUIT to flush them.
+  - Pool vars (16 bytes) : 3 allocated (48 bytes), 3 used, needed_avg 2, 0 failures, 1 users, @0x55c1ed5e1ac0=00 [SHARED]
+  - Pool buffer (16384 bytes) : 5 allocated (81920 bytes), 3 used (~3 by thread caches), needed_avg 4, 1 failures, 2 users, @0x55c1ed5e1bc0 [SHARED]
+  - Pool cache_st (16 bytes) : 0 allocated (0 bytes), 0 used, 0 failures, 2 users, @0x5606ce2d2440=03
+Total: 3 pools, 81968 bytes allocated, 49200 used.
+`
+
+var testShowThreads = `  Thread 1 : id=0x7f1c8e3a7a00 act=0 glob=0 wq=1 rq=0 tl=0 tlsz=0 rqsz=0
+             stuck=0 prof=0 harmless=1 wantrdv=0
+             cpu_ns: poll=1083226 now=1088596 diff=5370
+             curr_task=0
+*>Thread 2 : id=0x7f1c8d9fe700 act=1 glob=0 wq=1 rq=0 tl=1 tlsz=0 rqsz=1
+             stuck=1 prof=0 harmless=0 wantrdv=0
+             curr_task=0x7f1c84013ad0 (tasklet) calls=1
+`
+
+var testShowFD = `      4 : st=0x20(cl heopi W:sra R:srA) tmask=0x1 umask=0x0 owner=0x55e1f0ba2880 iocb=0x55e1ef5b9e20(poller_pipe_io_handler)
+     10 : st=0x21(cl heopI W:sRa R:srA) tmask=0x1 umask=0x0 prmsk=0x1 pwmsk=0x0 owner=0x55e1f0b6d2d0 iocb=0x55e1ef6a1d70(listener_accept) back=1 cflg=0x00000000 l.st=RDY fe=GLOBAL mux=PASS
+`
+
+func TestShowActivity(t *testing.T) {
+	a, err := parseActivity(strings.Split(testShowActivity, "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, a.Thread)
+	assert.EqualValues(t, 3034, a.Loops())
+	assert.EqualValues(t, 10, a.WakeTasks())
+	assert.Equal(t, []uint64{1619, 1415}, a.Counters["loops"].PerThread)
+	assert.Equal(t, "1620810375.447217", a.Counters["date_now"].Raw)
+	t.Run("old format", func(t *testing.T) {
+		a, err := parseActivity(strings.Split(testShowActivityOld, "\n"))
+		require.NoError(t, err)
+		assert.EqualValues(t, 3034, a.Loops())
+		assert.Equal(t, []uint64{4, 6}, a.Counters["wake_tasks"].PerThread)
+	})
+	t.Run("diff", func(t *testing.T) {
+		prev, _ := parseActivity(strings.Split(testShowActivity, "\n"))
+		cur, _ := parseActivity(strings.Split(strings.Replace(testShowActivity, "loops: 3034 [ 1619 1415 ]", "loops: 3134 [ 1669 1465 ]", 1), "\n"))
+		d := ActivityDiff(prev, cur)
+		assert.EqualValues(t, 100, d.Loops())
+		assert.Equal(t, []uint64{50, 50}, d.Counters["loops"].PerThread)
+		assert.EqualValues(t, 0, d.WakeTasks())
+		assert.Equal(t, "1620810375.447217", d.Counters["date_now"].Raw)
+	})
+	t.Run("error", func(t *testing.T) {
+		_, err := parseActivity([]string{"Unknown command. Please enter one of the following commands only :"})
+		assert.Error(t, err)
+	})
+}
+
+func TestShowPools(t *testing.T) {
+	pools, err := parsePools(strings.Split(testShowPools, "\n"))
+	require.NoError(t, err)
+	require.Len(t, pools, 3)
+	assert.Equal(t, Pool{Name: "vars", Size: 16, Allocated: 3, AllocatedBytes: 48, Used: 3, NeededAvg: 2, Users: 1, Shared: true}, pools[0])
+	assert.Equal(t, "buffer", pools[1].Name)
+	assert.EqualValues(t, 3, pools[1].Used)
+	assert.EqualValues(t, 1, pools[1].Failures)
+	assert.False(t, pools[2].Shared)
+	assert.EqualValues(t, 2, pools[2].Users)
+
+	cur := []Pool{{Name: "vars", Allocated: 5, AllocatedBytes: 80, Used: 4}, {Name: "new", Allocated: 1}}
+	d := PoolsDiff(pools, cur)
+	require.Len(t, d, 4)
+	assert.Equal(t, PoolDiff{Name: "vars", Allocated: 2, AllocatedBytes: 32, Used: 1}, d[0])
+	assert.EqualValues(t, 1, d[1].Allocated)
+	assert.Equal(t, "buffer", d[2].Name)
+	assert.EqualValues(t, -5, d[2].Allocated)
+}
+
+func TestShowThreads(t *testing.T) {
+	threads, err := parseThreads(strings.Split(testShowThreads, "\n"))
+	require.NoError(t, err)
+	require.Len(t, threads, 2)
+	assert.Equal(t, 1, threads[0].Num)
+	assert.False(t, threads[0].Current)
+	assert.EqualValues(t, 1, threads[0].Int("wq"))
+	assert.EqualValues(t, 1083226, threads[0].Int("poll"))
+	assert.False(t, threads[0].Stuck())
+	assert.True(t, threads[1].Current)
+	assert.True(t, threads[1].Stuck())
+	assert.EqualValues(t, 1, threads[1].Int("act"))
+	assert.EqualValues(t, -1, threads[1].Int("nonexistent"))
+}
+
+func TestShowFD(t *testing.T) {
+	fds, err := parseFD(strings.Split(testShowFD, "\n"))
+	require.NoError(t, err)
+	require.Len(t, fds, 2)
+	assert.Equal(t, 4, fds[0].FD)
+	assert.Equal(t, "poller_pipe_io_handler", fds[0].IOCB)
+	assert.Equal(t, 10, fds[1].FD)
+	assert.Equal(t, "0x21(cl heopI W:sRa R:srA)", fds[1].State)
+	assert.Equal(t, "0x55e1f0b6d2d0", fds[1].Owner)
+	assert.Equal(t, "listener_accept", fds[1].IOCB)
+	assert.Equal(t, "0x55e1ef6a1d70", fds[1].IOCBAddr)
+	assert.Equal(t, "GLOBAL", fds[1].Fields["fe"])
+}
@@ -0,0 +1,120 @@
+//go:build !test
+// +build !test
+
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// `show stat` row types (`type` column)
+const (
+	StatTypeFrontend = 0
+	StatTypeBackend  = 1
+	StatTypeServer   = 2
+	StatTypeListener = 3
+)
+
+// Stat is single row of `show stat`, proxy (FRONTEND/BACKEND) or server
+type Stat struct {
+	ProxyName string `json:"proxy_name"`
+	// FRONTEND, BACKEND or server/listener name
+	ServerName string `json:"server_name"`
+	Type       int    `json:"type"`
+	// all non-empty columns by name from the CSV header (scur, stot, status, check_status...)
+	Fields map[string]string `json:"fields"`
+}
+
+// ProcessInfo is output of `show info`, keyed by field name (Uptime_sec, CurrConns, Version...)
+type ProcessInfo map[string]string
+
+// Get proxy and server statistics (`show stat`)
+func (c *Conn) ShowStat() ([]Stat, error) {
+	out, err := c.RunCmd("show stat")
+	if err != nil {
+		return nil, err
+	}
+	return parseStat(out)
+}
+
+// Get process information (`show info`)
+func (c *Conn) ShowInfo() (ProcessInfo, error) {
+	out, err := c.RunCmd("show info")
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(out)
+}
+
+// Int returns numeric column, 0 if it is empty or not a number
+func (s Stat) Int(name string) int64 {
+	v, _ := strconv.ParseInt(s.Fields[name], 10, 64)
+	return v
+}
+
+// Status returns status column (UP, DOWN, OPEN, MAINT, "UP 1/3"...)
+func (s Stat) Status() string {
+	return s.Fields["status"]
+}
+
+// Int returns numeric field, 0 if it is missing or not a number
+func (i ProcessInfo) Int(name string) int64 {
+	v, _ := strconv.ParseInt(i[name], 10, 64)
+	return v
+}
+
+func parseStat(lines []string) ([]Stat, error) {
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "# ") {
+		if err := cmdError(lines); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("unexpected show stat output, no CSV header")
+	}
+	header := strings.Split(strings.TrimPrefix(lines[0], "# "), ",")
+	var out []Stat
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		cols := strings.Split(line, ",")
+		if len(cols) < 3 {
+			return out, errors.New(fmt.Sprintf("invalid stat line [%s]", line))
+		}
+		s := Stat{ProxyName: cols[0], ServerName: cols[1], Type: -1, Fields: make(map[string]string)}
+		for i, v := range cols {
+			if i < len(header) && header[i] != "" && v != "" {
+				s.Fields[header[i]] = v
+			}
+		}
+		if t, err := strconv.Atoi(s.Fields["type"]); err == nil {
+			s.Type = t
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func parseInfo(lines []string) (ProcessInfo, error) {
+	out := make(ProcessInfo)
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			if strings.HasSuffix(line, ":") {
+				out[strings.TrimSuffix(line, ":")] = ""
+				continue
+			}
+			if err := cmdError(lines); err != nil {
+				return nil, err
+			}
+			return out, errors.New(fmt.Sprintf("invalid info line [%s]", line))
+		}
+		out[line[:i]] = line[i+2:]
+	}
+	return out, nil
+}
@@ -0,0 +1,59 @@
+package haproxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testShowStat = `# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,weight,act,bck,chkfail,chkdown,lastchg,downtime,qlimit,pid,iid,sid,throttle,lbtot,tracked,type,rate,rate_lim,rate_max,check_status,check_code,check_duration,hrsp_1xx,hrsp_2xx,hrsp_3xx,hrsp_4xx,hrsp_5xx,hrsp_other,
+web,FRONTEND,,,3,10,2000,120,5000,90000,0,0,1,,,,,OPEN,,,,,,,,,1,2,0,,,,0,1,0,5,,,,0,100,5,10,5,0,
+app,app1,0,0,1,4,,60,2500,45000,,0,,0,0,0,0,UP,1,1,0,0,0,300,0,,1,3,1,,60,,2,1,,3,L7OK,200,1,0,50,2,5,3,0,
+app,app2,0,0,0,4,,60,2500,45000,,0,,2,0,0,0,DOWN,1,1,0,3,1,10,10,,1,3,2,,60,,2,0,,3,L4CON,,0,0,50,3,5,2,0,
+app,BACKEND,0,0,1,8,200,120,5000,90000,0,0,,2,0,0,0,UP,2,2,0,,0,300,0,,1,3,0,,120,,1,1,,5,,,,0,100,5,10,5,0,
+`
+
+var testShowInfo = `Name: HAProxy
+Version: 2.6.6
+Release_date: 2022/09/22
+Nbthread: 4
+Pid: 1806
+Uptime_sec: 3600
+CurrConns: 3
+Description:
+`
+
+func TestParseStat(t *testing.T) {
+	stats, err := parseStat(strings.Split(testShowStat, "\n"))
+	require.NoError(t, err)
+	require.Len(t, stats, 4)
+	assert.Equal(t, "web", stats[0].ProxyName)
+	assert.Equal(t, "FRONTEND", stats[0].ServerName)
+	assert.Equal(t, StatTypeFrontend, stats[0].Type)
+	assert.Equal(t, "OPEN", stats[0].Status())
+	assert.EqualValues(t, 3, stats[0].Int("scur"))
+	assert.EqualValues(t, 100, stats[0].Int("hrsp_2xx"))
+	assert.Equal(t, StatTypeServer, stats[2].Type)
+	assert.Equal(t, "DOWN", stats[2].Status())
+	assert.Equal(t, "L4CON", stats[2].Fields["check_status"])
+	_, ok := stats[2].Fields["check_code"]
+	assert.False(t, ok)
+	assert.Equal(t, StatTypeBackend, stats[3].Type)
+
+	_, err = parseStat([]string{"Unknown command."})
+	assert.Error(t, err)
+	_, err = parseStat(nil)
+	assert.Error(t, err)
+}
+
+func TestParseInfo(t *testing.T) {
+	info, err := parseInfo(strings.Split(testShowInfo, "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "2.6.6", info["Version"])
+	assert.EqualValues(t, 3600, info.Int("Uptime_sec"))
+	assert.Equal(t, "", info["Description"])
+	_, err = parseInfo([]string{"Permission denied"})
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package haproxy
 
 import (
 	"fmt"
+	"github.com/efigence/go-haproxy/haproxytest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -9,27 +10,24 @@ import (
 
 var testConn Conn
 
+func TestACLWithoutSocket(t *testing.T) {
+	c := &Conn{}
+	// TODO fix panics
+	assert.Error(t, c.AddACL("asd", "/asd"))
+	assert.Panics(t, func() { c.DeleteACL("asd", "1") })
+	_, err := c.GetACL("asd")
+	assert.Error(t, err)
+	assert.Panics(t, func() { c.ClearACL("asd") })
+	_, err = c.ListACL()
+	assert.Error(t, err)
+	_, err = c.ListACLFiles()
+	assert.Error(t, err)
+}
+
 func TestACL(t *testing.T) {
-	// fixme generate tmpfile unix socket
-	err := runTestHaproxy()
-	defer stopTestHaproxy()
-	t.Run("Start without module", func(t *testing.T) {
-		c := &Conn{}
-		// TODO fix panics
-		assert.Error(t, c.AddACL("asd", "/asd"))
-		assert.Panics(t, func() { c.DeleteACL("asd", "1") })
-		_, err := c.GetACL("asd")
-		assert.Error(t, err)
-		assert.Panics(t, func() { c.ClearACL("asd") })
-		_, err = c.ListACL()
-		assert.Error(t, err)
-		_, err = c.ListACLFiles()
-		assert.Error(t, err)
-	})
-	t.Run("Start test haproxy", func(t *testing.T) {
-		assert.NoError(t, err)
-	})
-	c := New("tmp/haproxy.sock")
+	ha := haproxytest.NewInstanceFromFile(t, "t-data/haproxy.conf", nil)
+	var err error
+	c := New(ha.SocketPath)
 	t.Run("List ACL", func(t *testing.T) {
 		out, err := c.RunCmd("show acl")
 		assert.NoError(t, err)
@@ -119,6 +117,4 @@ func TestACL(t *testing.T) {
 			assert.EqualValues(t, out["t-data/blacklist.lst"].ID, 0)
 		})
 	})
-
-	defer stopTestHaproxy()
 }
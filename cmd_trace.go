@@ -0,0 +1,137 @@
+//go:build !test
+// +build !test
+
+package haproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Trace levels, each one includes previous ones
+const (
+	TraceLevelUser      = "user"
+	TraceLevelProto     = "proto"
+	TraceLevelState     = "state"
+	TraceLevelData      = "data"
+	TraceLevelDeveloper = "developer"
+)
+
+// TraceConfig describes trace source setup (`trace <source> ...` commands)
+// Empty fields are left as they are currently set in haproxy
+type TraceConfig struct {
+	// trace source, e.g. h1, h2, fcgi, spoe, stream, peers, quic
+	Source string
+	// sink to send traces to. It has to be a ring (like buf0) to be streamed back
+	Sink string
+	// one of TraceLevel* constants
+	Level string
+	// source-specific verbosity, e.g. quiet, minimal, simple, advanced, complete
+	Verbosity string
+	// lock on first matching criterion, e.g. backend, connection, frontend, server, session, thread
+	Lock string
+	// events to enable (+name) or disable (-name), e.g. "+any"
+	Events []string
+	// start condition, "now" if empty
+	Start string
+}
+
+// TraceSet configures trace source without starting it
+func (c *Conn) TraceSet(cfg TraceConfig) error {
+	if err := validateTraceArg(cfg.Source); err != nil {
+		return err
+	}
+	settings := [][2]string{
+		{"sink", cfg.Sink},
+		{"level", cfg.Level},
+		{"verbosity", cfg.Verbosity},
+		{"lock", cfg.Lock},
+	}
+	for _, ev := range cfg.Events {
+		settings = append(settings, [2]string{"event", ev})
+	}
+	for _, s := range settings {
+		if s[1] == "" {
+			continue
+		}
+		if err := validateTraceArg(s[1]); err != nil {
+			return err
+		}
+		if err := c.runCmdNoOutput(fmt.Sprintf("trace %s %s %s", cfg.Source, s[0], s[1])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TraceStart starts tracing source. when is either "now" or event name
+func (c *Conn) TraceStart(source string, when string) error {
+	return c.traceAction(source, "start", when)
+}
+
+// TraceStop stops tracing source. when is either "now" or event name
+func (c *Conn) TraceStop(source string, when string) error {
+	return c.traceAction(source, "stop", when)
+}
+
+// TracePause pauses tracing source. when is either "now" or event name
+func (c *Conn) TracePause(source string, when string) error {
+	return c.traceAction(source, "pause", when)
+}
+
+// Trace configures and starts trace source and streams its sink back.
+// Trace is stopped when ctx is cancelled, so caller should always cancel it when done
+func (c *Conn) Trace(ctx context.Context, cfg TraceConfig) (<-chan RingEvent, error) {
+	if cfg.Sink == "" {
+		return nil, errors.New("trace sink has to be set to a ring name (like buf0) to stream it")
+	}
+	if err := c.TraceSet(cfg); err != nil {
+		return nil, err
+	}
+	// subscribe to the ring first so events from the start of the trace are most likely caught.
+	// haproxy doesn't acknowledge `show events -w`, so there is no guarantee the first few aren't missed
+	ctx, cancel := context.WithCancel(ctx)
+	ch, err := c.ShowEvents(ctx, cfg.Sink, RingOptions{Wait: true, SkipExisting: true})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	start := cfg.Start
+	if start == "" {
+		start = "now"
+	}
+	if err := c.TraceStart(cfg.Source, start); err != nil {
+		// closes the ring connection and stops its reader
+		cancel()
+		c.TraceStop(cfg.Source, "now")
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		cancel()
+		c.TraceStop(cfg.Source, "now")
+	}()
+	return ch, nil
+}
+
+func (c *Conn) traceAction(source string, action string, when string) error {
+	if when == "" {
+		when = "now"
+	}
+	if err := validateTraceArg(source); err != nil {
+		return err
+	}
+	if err := validateTraceArg(when); err != nil {
+		return err
+	}
+	return c.runCmdNoOutput(fmt.Sprintf("trace %s %s %s", source, action, when))
+}
+
+func validateTraceArg(s string) error {
+	if s == "" || strings.ContainsAny(s, " \t\n;") {
+		return errors.New(fmt.Sprintf("invalid trace argument [%s]", s))
+	}
+	return nil
+}
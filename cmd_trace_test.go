@@ -0,0 +1,95 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	var lock sync.Mutex
+	var cmds []string
+	path := serveTestSocket(t, func(cmd string, conn net.Conn) {
+		lock.Lock()
+		cmds = append(cmds, cmd)
+		lock.Unlock()
+		switch cmd {
+		case "show events buf0 -w -n":
+			fmt.Fprintf(conn, "<0>2022-08-12T13:18:51.161232+02:00 [00|h2|0|mux_h2.c:2689] rcvd H2 request\n")
+			buf := make([]byte, 1)
+			conn.Read(buf)
+		case "trace h2 level nonsense":
+			fmt.Fprintf(conn, "No such trace level 'nonsense'\n")
+		case "show events buf1 -w -n":
+			buf := make([]byte, 1)
+			conn.Read(buf)
+			lock.Lock()
+			cmds = append(cmds, "closed buf1")
+			lock.Unlock()
+		case "trace h1 start now":
+			fmt.Fprintf(conn, "Permission denied\n")
+		}
+	})
+	commands := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, cmds...)
+	}
+	c := New(path)
+	t.Run("stream", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, err := c.Trace(ctx, TraceConfig{
+			Source:    "h2",
+			Sink:      "buf0",
+			Level:     TraceLevelDeveloper,
+			Verbosity: "minimal",
+			Lock:      "connection",
+			Events:    []string{"+any"},
+		})
+		require.NoError(t, err)
+		ev := <-ch
+		assert.Contains(t, ev.Line, "rcvd H2 request")
+		cancel()
+		for range ch {
+		}
+		assert.Eventually(t, func() bool {
+			c := commands()
+			return len(c) > 0 && c[len(c)-1] == "trace h2 stop now"
+		}, time.Second, 10*time.Millisecond)
+		// ring reader connection is handled concurrently so order is not guaranteed
+		assert.ElementsMatch(t, []string{
+			"trace h2 sink buf0",
+			"trace h2 level developer",
+			"trace h2 verbosity minimal",
+			"trace h2 lock connection",
+			"trace h2 event +any",
+			"show events buf0 -w -n",
+			"trace h2 start now",
+			"trace h2 stop now",
+		}, commands())
+	})
+	t.Run("errors", func(t *testing.T) {
+		assert.Error(t, c.TraceSet(TraceConfig{Source: "h2", Level: "nonsense"}))
+		assert.Error(t, c.TraceSet(TraceConfig{Source: "h2; shutdown sessions"}))
+		_, err := c.Trace(context.Background(), TraceConfig{Source: "h2"})
+		assert.Error(t, err)
+
+		// ring connection is closed when the trace can't be started
+		_, err = c.Trace(context.Background(), TraceConfig{Source: "h1", Sink: "buf1"})
+		assert.Error(t, err)
+		assert.Eventually(t, func() bool {
+			for _, cmd := range commands() {
+				if cmd == "closed buf1" {
+					return true
+				}
+			}
+			return false
+		}, time.Second, 10*time.Millisecond)
+	})
+}
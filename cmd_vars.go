@@ -0,0 +1,259 @@
+//go:build !test
+// +build !test
+
+package haproxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Var is process-wide variable returned by `get var`
+type Var struct {
+	Name string `json:"name"`
+	// sample type: bool, sint, str, bin, ipv4, ipv6, meth
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// severity-output modes, see SetSeverityOutput
+const (
+	SeverityOutputNone   = "none"
+	SeverityOutputNumber = "number"
+	SeverityOutputString = "string"
+)
+
+// proc.t1: type=sint value=<1>
+var getVarRegex = regexp.MustCompile(`^(\S+): type=(\S+) value=<(.*)>$`)
+
+// ShowEnv returns environment of haproxy process (`show env`)
+func (c *Conn) ShowEnv() (map[string]string, error) {
+	out, err := c.RunCmd("show env")
+	if err != nil {
+		return nil, err
+	}
+	if err := cmdError(out); err != nil {
+		return nil, err
+	}
+	env := make(map[string]string)
+	for _, line := range out {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		env[kv[0]] = kv[1]
+	}
+	return env, nil
+}
+
+// GetEnv returns single environment variable of haproxy process
+func (c *Conn) GetEnv(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, " \t\n;=") {
+		return "", errors.New(fmt.Sprintf("invalid variable name [%s]", name))
+	}
+	out, err := c.RunCmd("show env " + name)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range out {
+		if strings.HasPrefix(line, name+"=") {
+			return line[len(name)+1:], nil
+		}
+	}
+	return "", errors.New(fmt.Sprintf("error: %s", strings.Join(out, "\n")))
+}
+
+// GetVar returns process-wide variable. Only proc.* variables are readable
+func (c *Conn) GetVar(name string) (Var, error) {
+	var v Var
+	if err := validateProcVar(name); err != nil {
+		return v, err
+	}
+	out, err := c.RunCmd("get var " + name)
+	if err != nil {
+		return v, err
+	}
+	for _, line := range out {
+		m := getVarRegex.FindStringSubmatch(line)
+		if len(m) < 4 {
+			continue
+		}
+		v.Name = m[1]
+		v.Type = m[2]
+		v.Value = m[3]
+		return v, nil
+	}
+	return v, errors.New(fmt.Sprintf("error: %s", strings.Join(out, "\n")))
+}
+
+// Int returns value of integer variable
+func (v Var) Int() (int64, error) {
+	return strconv.ParseInt(v.Value, 10, 64)
+}
+
+// Bool returns value of boolean variable
+// non-bool variables are true if they are non-empty and not "0", like in haproxy's bool converter
+func (v Var) Bool() bool {
+	return v.Value != "" && v.Value != "0" && v.Value != "false"
+}
+
+// SetVar sets process-wide variable to result of sample expression (HAProxy 2.4+)
+// like `int(1)` or `str(enabled)`
+func (c *Conn) SetVar(name string, expr string) error {
+	if err := validateProcVar(name); err != nil {
+		return err
+	}
+	if expr == "" || strings.ContainsAny(expr, " \t\n;") {
+		return errors.New(fmt.Sprintf("expression should not contain whitespaces or be empty [%s]", expr))
+	}
+	return c.runCmdNoOutput(fmt.Sprintf("set var %s %s", name, expr))
+}
+
+// SetVarString sets process-wide variable to a string
+func (c *Conn) SetVarString(name string, value string) error {
+	if strings.ContainsAny(value, " \t\n;,()") {
+		return errors.New(fmt.Sprintf("value can't contain whitespaces, commas, semicolons or parenthesis [%s]", value))
+	}
+	return c.SetVar(name, fmt.Sprintf("str(%s)", value))
+}
+
+// SetVarInt sets process-wide variable to integer
+func (c *Conn) SetVarInt(name string, value int64) error {
+	return c.SetVar(name, fmt.Sprintf("int(%d)", value))
+}
+
+// SetVarBool sets process-wide variable to boolean
+func (c *Conn) SetVarBool(name string, value bool) error {
+	if value {
+		return c.SetVar(name, "bool(1)")
+	}
+	return c.SetVar(name, "bool(0)")
+}
+
+// SetDynamicCookieKey sets secret key used to generate dynamic persistence cookies in backend
+func (c *Conn) SetDynamicCookieKey(backend string, key string) error {
+	if err := validateArg("backend", backend); err != nil {
+		return err
+	}
+	if err := validateArg("key", key); err != nil {
+		return err
+	}
+	return c.runCmdNoOutput(fmt.Sprintf("set dynamic-cookie-key backend %s %s", backend, key))
+}
+
+// EnableDynamicCookie enables generation of dynamic cookies in backend
+func (c *Conn) EnableDynamicCookie(backend string) error {
+	if err := validateArg("backend", backend); err != nil {
+		return err
+	}
+	return c.runCmdNoOutput(fmt.Sprintf("enable dynamic-cookie backend %s", backend))
+}
+
+// DisableDynamicCookie disables generation of dynamic cookies in backend
+func (c *Conn) DisableDynamicCookie(backend string) error {
+	if err := validateArg("backend", backend); err != nil {
+		return err
+	}
+	return c.runCmdNoOutput(fmt.Sprintf("disable dynamic-cookie backend %s", backend))
+}
+
+// SetSeverityOutput makes haproxy prefix command responses with their severity
+// one of SeverityOutput* constants.
+// It is a per-session setting so it is sent along with every following command
+func (c *Conn) SetSeverityOutput(mode string) error {
+	switch mode {
+	case SeverityOutputNone, SeverityOutputNumber, SeverityOutputString:
+	default:
+		return errors.New(fmt.Sprintf("invalid severity output [%s]", mode))
+	}
+	if mode == SeverityOutputNone {
+		mode = ""
+	}
+	c.severityOutput = mode
+	return nil
+}
+
+// SetCLITimeout sets CLI idle timeout, rounded up to a second. Zero resets to the one from config.
+// It is a per-session setting so it is sent along with every following command
+func (c *Conn) SetCLITimeout(d time.Duration) error {
+	if d < 0 {
+		return errors.New("timeout can't be negative")
+	}
+	c.cliTimeout = d
+	return nil
+}
+
+// commands to send before every command to apply per-session settings
+func (c *Conn) sessionPrefix() string {
+	var prefix string
+	if c.severityOutput != "" {
+		prefix += fmt.Sprintf("set severity-output %s; ", c.severityOutput)
+	}
+	if c.cliTimeout > 0 {
+		prefix += fmt.Sprintf("set timeout cli %d; ", int64((c.cliTimeout+time.Second-1)/time.Second))
+	}
+	return prefix
+}
+
+// number of response lines to skip, one per command in sessionPrefix
+func (c *Conn) sessionPrefixLines() int {
+	return strings.Count(c.sessionPrefix(), ";")
+}
+
+// skipSessionPrefix reads responses of sessionPrefix commands. Each is just an empty line, anything else means the setting failed
+func (c *Conn) skipSessionPrefix(scanner *bufio.Scanner) error {
+	var failed []string
+	for skip := c.sessionPrefixLines(); skip > 0 && scanner.Scan(); {
+		line := scanner.Text()
+		if line == "" {
+			skip--
+			continue
+		}
+		_, line = cutSeverity(line)
+		failed = append(failed, line)
+	}
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("error applying session settings: %s", strings.Join(failed, " ")))
+	}
+	return scanner.Err()
+}
+
+// syslog level names used by `set severity-output string`
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// cutSeverity strips `[3]: ` or `[err]: ` prefix haproxy adds to messages when severity output is on
+func cutSeverity(line string) (int, string) {
+	end := strings.Index(line, "]: ")
+	if !strings.HasPrefix(line, "[") || end < 0 {
+		return -1, line
+	}
+	level := line[1:end]
+	if n, err := strconv.Atoi(level); err == nil && n >= 0 && n < len(severityNames) {
+		return n, line[end+3:]
+	}
+	for n, name := range severityNames {
+		if name == level {
+			return n, line[end+3:]
+		}
+	}
+	return -1, line
+}
+
+func validateProcVar(name string) error {
+	if !strings.HasPrefix(name, "proc.") || len(name) == len("proc.") {
+		return errors.New(fmt.Sprintf("only process-wide (proc.*) variables are accessible, got [%s]", name))
+	}
+	return validateArg("variable name", name)
+}
+
+func validateArg(what string, s string) error {
+	if s == "" || strings.ContainsAny(s, " \t\n;") {
+		return errors.New(fmt.Sprintf("%s should not contain whitespaces or be empty [%s]", what, s))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package haproxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeVars(t *testing.T) {
+	var lock sync.Mutex
+	var lastCmd string
+	path := serveTestSocket(t, func(line string, conn net.Conn) {
+		lock.Lock()
+		lastCmd = line
+		lock.Unlock()
+		cmds := strings.Split(line, "; ")
+		for _, cmd := range cmds {
+			switch {
+			case cmd == "show env":
+				fmt.Fprintf(conn, "HOME=/var/lib/haproxy\nPATH=/usr/bin:/bin\nEMPTY=\n")
+			case cmd == "show env HOME":
+				fmt.Fprintf(conn, "HOME=/var/lib/haproxy\n")
+			case cmd == "show env NOPE":
+				fmt.Fprintf(conn, "Variable not found\n")
+			case cmd == "get var proc.feature":
+				fmt.Fprintf(conn, "proc.feature: type=sint value=<12>\n")
+			case cmd == "get var proc.missing":
+				fmt.Fprintf(conn, "Variable not found\n")
+			case strings.HasPrefix(cmd, "set var proc.bad"):
+				fmt.Fprintf(conn, "failed to parse expression\n")
+			case strings.HasPrefix(cmd, "set "), strings.HasPrefix(cmd, "enable "), strings.HasPrefix(cmd, "disable "):
+			default:
+				fmt.Fprintf(conn, "Unknown command\n")
+			}
+			fmt.Fprintf(conn, "\n")
+		}
+	})
+	last := func() string {
+		lock.Lock()
+		defer lock.Unlock()
+		return lastCmd
+	}
+	c := New(path)
+	t.Run("env", func(t *testing.T) {
+		env, err := c.ShowEnv()
+		require.NoError(t, err)
+		assert.Equal(t, "/var/lib/haproxy", env["HOME"])
+		assert.Equal(t, "/usr/bin:/bin", env["PATH"])
+		assert.Contains(t, env, "EMPTY")
+		v, err := c.GetEnv("HOME")
+		assert.NoError(t, err)
+		assert.Equal(t, "/var/lib/haproxy", v)
+		_, err = c.GetEnv("NOPE")
+		assert.Error(t, err)
+	})
+	t.Run("get var", func(t *testing.T) {
+		v, err := c.GetVar("proc.feature")
+		require.NoError(t, err)
+		assert.Equal(t, Var{Name: "proc.feature", Type: "sint", Value: "12"}, v)
+		i, err := v.Int()
+		assert.NoError(t, err)
+		assert.EqualValues(t, 12, i)
+		assert.True(t, v.Bool())
+		_, err = c.GetVar("proc.missing")
+		assert.Error(t, err)
+		_, err = c.GetVar("txn.feature")
+		assert.Error(t, err)
+	})
+	t.Run("set var", func(t *testing.T) {
+		assert.NoError(t, c.SetVarInt("proc.feature", 1))
+		assert.Equal(t, "set var proc.feature int(1)", last())
+		assert.NoError(t, c.SetVarString("proc.mode", "maintenance"))
+		assert.Equal(t, "set var proc.mode str(maintenance)", last())
+		assert.NoError(t, c.SetVarBool("proc.on", false))
+		assert.Equal(t, "set var proc.on bool(0)", last())
+		assert.Error(t, c.SetVarString("proc.mode", "a b"))
+		assert.Error(t, c.SetVar("proc.bad", "nonsense("))
+		assert.Error(t, c.SetVar("sess.x", "int(1)"))
+	})
+	t.Run("dynamic cookie", func(t *testing.T) {
+		assert.NoError(t, c.SetDynamicCookieKey("app", "s3cr3t"))
+		assert.Equal(t, "set dynamic-cookie-key backend app s3cr3t", last())
+		assert.NoError(t, c.EnableDynamicCookie("app"))
+		assert.Equal(t, "enable dynamic-cookie backend app", last())
+		assert.NoError(t, c.DisableDynamicCookie("app"))
+		assert.Equal(t, "disable dynamic-cookie backend app", last())
+		assert.Error(t, c.EnableDynamicCookie("app; shutdown sessions"))
+	})
+	t.Run("session settings", func(t *testing.T) {
+		c := New(path)
+		assert.Error(t, c.SetSeverityOutput("loud"))
+		require.NoError(t, c.SetSeverityOutput(SeverityOutputString))
+		require.NoError(t, c.SetCLITimeout(1500*time.Millisecond))
+		env, err := c.ShowEnv()
+		require.NoError(t, err)
+		assert.Equal(t, "set severity-output string; set timeout cli 2; show env", last())
+		assert.Equal(t, "/var/lib/haproxy", env["HOME"])
+		out, err := c.RunCmd("show env HOME")
+		require.NoError(t, err)
+		assert.Equal(t, "HOME=/var/lib/haproxy", out[0])
+		require.NoError(t, c.SetSeverityOutput(SeverityOutputNone))
+		require.NoError(t, c.SetCLITimeout(0))
+		c.ShowEnv()
+		assert.Equal(t, "show env", last())
+	})
+}
@@ -25,3 +25,25 @@ const (
 	SessionCloseTarpit     = 'T'
 	SessionCloseNone       = '-'
 )
+
+// persistence cookie sent by the client (3rd character of termination state)
+const (
+	ClientCookieNone    = 'N'
+	ClientCookieInvalid = 'I'
+	ClientCookieDown    = 'D'
+	ClientCookieValid   = 'V'
+	ClientCookieExpired = 'E'
+	ClientCookieOld     = 'O'
+	ClientCookieUnused  = '-'
+)
+
+// persistence cookie sent by the server (4th character of termination state)
+const (
+	ServerCookieNone      = 'N'
+	ServerCookieInserted  = 'I'
+	ServerCookieUpdated   = 'U'
+	ServerCookiePassed    = 'P'
+	ServerCookieRewritten = 'R'
+	ServerCookieDeleted   = 'D'
+	ServerCookieUnused    = '-'
+)
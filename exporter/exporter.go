@@ -0,0 +1,196 @@
+// Package exporter exposes prometheus metrics computed from decoded haproxy logs
+// and from `show stat`/`show info` of the stats socket
+package exporter
+
+import (
+	"strings"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Config configures Exporter
+type Config struct {
+	// metric name prefix, "haproxy" by default
+	Namespace string
+	// buckets of timer histograms in seconds, 1ms to ~32s by default
+	Buckets []float64
+}
+
+// Exporter is prometheus.Collector with metrics from logs passed to HandleLog and stats passed to Poll.
+// It also implements haproxy.LogHandler so it can be plugged directly into haproxy.LogServer.
+// It is safe for concurrent use
+type Exporter struct {
+	messages     *prometheus.CounterVec
+	decodeErrors prometheus.Counter
+	requests     *prometheus.CounterVec
+	bytesRead    *prometheus.CounterVec
+	timers       *prometheus.HistogramVec
+	terminations *prometheus.CounterVec
+	serverStates *prometheus.CounterVec
+
+	stats *statsCollector
+}
+
+// methods that get their own label value, anything else is counted as OTHER so garbage in requests doesn't blow up cardinality
+var knownMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+	"CONNECT": true, "OPTIONS": true, "TRACE": true, "PATCH": true,
+}
+
+// New creates Exporter
+func New(cfg Config) *Exporter {
+	if cfg.Namespace == "" {
+		cfg.Namespace = "haproxy"
+	}
+	if len(cfg.Buckets) == 0 {
+		cfg.Buckets = prometheus.ExponentialBuckets(0.001, 2, 16)
+	}
+	ns := cfg.Namespace
+	return &Exporter{
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Subsystem: "log", Name: "messages_total",
+			Help: "Decoded log messages by type.",
+		}, []string{"type"}),
+		decodeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Subsystem: "log", Name: "decode_errors_total",
+			Help: "Log messages that failed to decode.",
+		}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Subsystem: "log", Name: "http_requests_total",
+			Help: "HTTP requests by backend, method and status class.",
+		}, []string{"backend", "method", "status"}),
+		bytesRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Subsystem: "log", Name: "bytes_read_total",
+			Help: "Bytes sent to clients by backend.",
+		}, []string{"backend"}),
+		timers: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns, Subsystem: "log", Name: "timer_seconds",
+			Help:    "Timers from the log by backend: request (Tq), queue (Tw), connect (Tc), response (Tr) and total (Tt/Ta).",
+			Buckets: cfg.Buckets,
+		}, []string{"backend", "timer"}),
+		terminations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Subsystem: "log", Name: "terminations_total",
+			Help: "Sessions by backend and termination state (reason and phase), class says which side ended it.",
+		}, []string{"backend", "state", "class"}),
+		serverStates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Subsystem: "log", Name: "server_state_changes_total",
+			Help: "Server state changes logged by health checks.",
+		}, []string{"backend", "server", "state"}),
+		stats: newStatsCollector(ns),
+	}
+}
+
+// Registry returns new registry with the exporter registered
+func (e *Exporter) Registry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(e)
+	return r
+}
+
+// Describe implements prometheus.Collector
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range e.collectors() {
+		c.Describe(ch)
+	}
+	e.stats.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range e.collectors() {
+		c.Collect(ch)
+	}
+	e.stats.Collect(ch)
+}
+
+func (e *Exporter) collectors() []prometheus.Collector {
+	return []prometheus.Collector{e.messages, e.decodeErrors, e.requests, e.bytesRead, e.timers, e.terminations, e.serverStates}
+}
+
+// HandleLog records anything haproxy.DecodeLog returns
+func (e *Exporter) HandleLog(log interface{}) {
+	switch l := log.(type) {
+	case haproxy.HTTPRequest:
+		e.messages.WithLabelValues("http").Inc()
+		e.addHTTP(&l)
+	case *haproxy.HTTPRequest:
+		e.messages.WithLabelValues("http").Inc()
+		e.addHTTP(l)
+	case haproxy.TCPRequest:
+		e.messages.WithLabelValues("tcp").Inc()
+		e.addTCP(&l)
+	case *haproxy.TCPRequest:
+		e.messages.WithLabelValues("tcp").Inc()
+		e.addTCP(l)
+	case haproxy.ServerStateEvent:
+		e.messages.WithLabelValues("server_state").Inc()
+		e.serverStates.WithLabelValues(l.BackendName, l.ServerName, l.State).Inc()
+	case haproxy.NoServerEvent:
+		e.messages.WithLabelValues("no_server").Inc()
+	case haproxy.ProxyEvent:
+		e.messages.WithLabelValues("proxy").Inc()
+	case haproxy.ConnectionErrorEvent:
+		e.messages.WithLabelValues("connection_error").Inc()
+	case haproxy.SPOEEvent:
+		e.messages.WithLabelValues("spoe").Inc()
+	case haproxy.RawLog:
+		e.messages.WithLabelValues("raw").Inc()
+	default:
+		e.messages.WithLabelValues("other").Inc()
+	}
+}
+
+// HandleError counts decode errors
+func (e *Exporter) HandleError(err error) {
+	e.decodeErrors.Inc()
+}
+
+func (e *Exporter) addHTTP(r *haproxy.HTTPRequest) {
+	method := strings.ToUpper(r.RequestMethod)
+	if !knownMethods[method] {
+		method = "OTHER"
+	}
+	e.requests.WithLabelValues(r.BackendName, method, statusClass(r.StatusCode)).Inc()
+	e.bytesRead.WithLabelValues(r.BackendName).Add(float64(r.BytesRead))
+	e.observe(r.BackendName, "request", r.RequestTimeMs())
+	e.observe(r.BackendName, "queue", r.QueueDurationMs)
+	e.observe(r.BackendName, "connect", r.ServerConnDurationMs)
+	e.observe(r.BackendName, "response", r.ResponseHeaderDurationMs)
+	e.observe(r.BackendName, "total", r.TotalTimeMs())
+	e.addTermination(r.BackendName, r.TerminationState())
+}
+
+func (e *Exporter) addTCP(r *haproxy.TCPRequest) {
+	e.bytesRead.WithLabelValues(r.BackendName).Add(float64(r.BytesRead))
+	e.observe(r.BackendName, "queue", r.QueueDurationMs)
+	e.observe(r.BackendName, "connect", r.ServerConnDurationMs)
+	e.observe(r.BackendName, "total", r.TotalDurationMs)
+	e.addTermination(r.BackendName, r.TerminationState())
+}
+
+// observe records timer, -1 (timer didn't happen) is skipped
+func (e *Exporter) observe(backend, timer string, ms int) {
+	if ms < 0 {
+		return
+	}
+	e.timers.WithLabelValues(backend, timer).Observe(float64(ms) / 1000)
+}
+
+func (e *Exporter) addTermination(backend string, t haproxy.TerminationState) {
+	// cookie states would multiply series without saying much about health
+	state := string([]rune(string(t))[:2])
+	e.terminations.WithLabelValues(backend, state, string(t.Class())).Inc()
+}
+
+func statusClass(code int16) string {
+	if code < 100 || code > 599 {
+		return "other"
+	}
+	return string(rune('0'+code/100)) + "xx"
+}
+
+var (
+	_ prometheus.Collector = &Exporter{}
+	_ haproxy.LogHandler   = &Exporter{}
+)
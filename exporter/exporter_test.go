@@ -0,0 +1,78 @@
+package exporter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLines = []string{
+	`<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1~ app/app3 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/0 "POST /query/q/Sql HTTP/1.1"`,
+	`<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61060 [23/Jul/2015:13:49:11.933] front1~ app/app3 10/0/-1/-1/5002 503 212 - - sC-- 1637/7/5/6/0 0/0 "GET / HTTP/1.1"`,
+	`<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61061 [23/Jul/2015:13:49:11.933] front1~ app/app3 10/0/1/2/3 200 212 - - ---- 1637/7/5/6/0 0/0 "FOO / HTTP/1.1"`,
+	`<158>Feb  6 12:12:56 haproxy[14387]: 10.0.1.2:33313 [06/Feb/2009:12:12:51.443] fnt bck/srv1 0/0/5007 212 -- 0/0/0/0/3 0/0`,
+	`<134>Feb  6 12:12:56 haproxy[1]: Proxy fnt started.`,
+}
+
+func TestExporterLogs(t *testing.T) {
+	e := New(Config{})
+	for _, line := range testLines {
+		l, err := haproxy.DecodeLog(line)
+		require.NoError(t, err, line)
+		e.HandleLog(l)
+	}
+	e.HandleError(errors.New("garbage"))
+
+	err := testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP haproxy_log_http_requests_total HTTP requests by backend, method and status class.
+# TYPE haproxy_log_http_requests_total counter
+haproxy_log_http_requests_total{backend="app",method="GET",status="5xx"} 1
+haproxy_log_http_requests_total{backend="app",method="OTHER",status="2xx"} 1
+haproxy_log_http_requests_total{backend="app",method="POST",status="2xx"} 1
+# HELP haproxy_log_messages_total Decoded log messages by type.
+# TYPE haproxy_log_messages_total counter
+haproxy_log_messages_total{type="http"} 3
+haproxy_log_messages_total{type="proxy"} 1
+haproxy_log_messages_total{type="tcp"} 1
+# HELP haproxy_log_decode_errors_total Log messages that failed to decode.
+# TYPE haproxy_log_decode_errors_total counter
+haproxy_log_decode_errors_total 1
+# HELP haproxy_log_terminations_total Sessions by backend and termination state (reason and phase), class says which side ended it.
+# TYPE haproxy_log_terminations_total counter
+haproxy_log_terminations_total{backend="app",class="ok",state="--"} 2
+haproxy_log_terminations_total{backend="app",class="server",state="sC"} 1
+haproxy_log_terminations_total{backend="bck",class="ok",state="--"} 1
+`), "haproxy_log_http_requests_total", "haproxy_log_messages_total", "haproxy_log_decode_errors_total", "haproxy_log_terminations_total")
+	assert.NoError(t, err)
+
+	// -1 timers are skipped
+	var m dto.Metric
+	require.NoError(t, e.timers.WithLabelValues("app", "connect").(prometheus.Metric).Write(&m))
+	assert.EqualValues(t, 2, m.GetHistogram().GetSampleCount())
+	assert.InDelta(t, 0.002, m.GetHistogram().GetSampleSum(), 0.0001)
+	assert.InDelta(t, 1140+212+212+212, testutil.ToFloat64(e.bytesRead.WithLabelValues("app"))+testutil.ToFloat64(e.bytesRead.WithLabelValues("bck")), 0.1)
+}
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", statusClass(200))
+	assert.Equal(t, "5xx", statusClass(599))
+	assert.Equal(t, "other", statusClass(-1))
+	assert.Equal(t, "other", statusClass(600))
+}
+
+func TestRegistry(t *testing.T) {
+	e := New(Config{Namespace: "lb"})
+	e.HandleLog(haproxy.RawLog{})
+	families, err := e.Registry().Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+	assert.Equal(t, "lb_log_decode_errors_total", families[0].GetName())
+	assert.Equal(t, "lb_log_messages_total", families[1].GetName())
+}
@@ -0,0 +1,249 @@
+package exporter
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatsSource is where Poll gets stats from, *haproxy.Conn implements it
+type StatsSource interface {
+	ShowStat() ([]haproxy.Stat, error)
+	ShowInfo() (haproxy.ProcessInfo, error)
+}
+
+// statColumn maps `show stat` CSV column to metric
+type statColumn struct {
+	column    string
+	name      string
+	help      string
+	valueType prometheus.ValueType
+	// divisor, used to convert ms to seconds
+	div float64
+}
+
+var statColumns = []statColumn{
+	{"scur", "current_sessions", "Current sessions.", prometheus.GaugeValue, 1},
+	{"smax", "max_sessions", "Maximum observed sessions.", prometheus.GaugeValue, 1},
+	{"slim", "limit_sessions", "Configured session limit.", prometheus.GaugeValue, 1},
+	{"stot", "sessions_total", "Total sessions.", prometheus.CounterValue, 1},
+	{"rate", "current_session_rate", "Sessions per second over last elapsed second.", prometheus.GaugeValue, 1},
+	{"qcur", "current_queue", "Current queued requests.", prometheus.GaugeValue, 1},
+	{"qmax", "max_queue", "Maximum observed queued requests.", prometheus.GaugeValue, 1},
+	{"bin", "bytes_in_total", "Bytes received from clients.", prometheus.CounterValue, 1},
+	{"bout", "bytes_out_total", "Bytes sent to clients.", prometheus.CounterValue, 1},
+	{"dreq", "requests_denied_total", "Requests denied because of security concerns.", prometheus.CounterValue, 1},
+	{"dresp", "responses_denied_total", "Responses denied because of security concerns.", prometheus.CounterValue, 1},
+	{"ereq", "request_errors_total", "Request errors.", prometheus.CounterValue, 1},
+	{"econ", "connection_errors_total", "Errors connecting to servers.", prometheus.CounterValue, 1},
+	{"eresp", "response_errors_total", "Response errors.", prometheus.CounterValue, 1},
+	{"wretr", "retry_warnings_total", "Retried connections to servers.", prometheus.CounterValue, 1},
+	{"wredis", "redispatch_warnings_total", "Requests redispatched to other server.", prometheus.CounterValue, 1},
+	{"req_tot", "http_requests_total", "HTTP requests.", prometheus.CounterValue, 1},
+	{"weight", "weight", "Effective weight.", prometheus.GaugeValue, 1},
+	{"act", "active_servers", "Active servers (or 1 for active server).", prometheus.GaugeValue, 1},
+	{"bck", "backup_servers", "Backup servers (or 1 for backup server).", prometheus.GaugeValue, 1},
+	{"chkfail", "check_failures_total", "Failed health checks.", prometheus.CounterValue, 1},
+	{"chkdown", "check_up_down_total", "UP to DOWN transitions.", prometheus.CounterValue, 1},
+	{"downtime", "downtime_seconds_total", "Total downtime.", prometheus.CounterValue, 1},
+	{"lbtot", "selected_total", "Times server was selected by load balancing, redispatch or persistence.", prometheus.CounterValue, 1},
+	{"check_duration", "check_duration_seconds", "Duration of last health check.", prometheus.GaugeValue, 1000},
+	{"qtime", "queue_time_average_seconds", "Average queue time of last 1024 requests.", prometheus.GaugeValue, 1000},
+	{"ctime", "connect_time_average_seconds", "Average connect time of last 1024 requests.", prometheus.GaugeValue, 1000},
+	{"rtime", "response_time_average_seconds", "Average response time of last 1024 requests.", prometheus.GaugeValue, 1000},
+	{"ttime", "total_time_average_seconds", "Average total time of last 1024 requests.", prometheus.GaugeValue, 1000},
+}
+
+// `show info` fields
+var infoFields = []statColumn{
+	{"Uptime_sec", "uptime_seconds", "Process uptime.", prometheus.GaugeValue, 1},
+	{"Nbthread", "threads", "Configured threads.", prometheus.GaugeValue, 1},
+	{"Maxconn", "max_connections", "Configured connection limit.", prometheus.GaugeValue, 1},
+	{"CurrConns", "current_connections", "Current connections.", prometheus.GaugeValue, 1},
+	{"CumConns", "connections_total", "Total connections.", prometheus.CounterValue, 1},
+	{"CumReq", "requests_total", "Total requests.", prometheus.CounterValue, 1},
+	{"ConnRate", "current_connection_rate", "Connections per second.", prometheus.GaugeValue, 1},
+	{"SessRate", "current_session_rate", "Sessions per second.", prometheus.GaugeValue, 1},
+	{"Run_queue", "run_queue", "Tasks in the run queue.", prometheus.GaugeValue, 1},
+	{"Idle_pct", "idle_ratio", "Idle time ratio.", prometheus.GaugeValue, 100},
+}
+
+// row types exported, listeners are skipped
+var statSubsystems = map[int]string{
+	haproxy.StatTypeFrontend: "frontend",
+	haproxy.StatTypeBackend:  "backend",
+	haproxy.StatTypeServer:   "server",
+}
+
+type statDescs struct {
+	// [row type][column] => desc
+	stat      map[int]map[string]*prometheus.Desc
+	up        map[int]*prometheus.Desc
+	responses map[int]*prometheus.Desc
+	info      map[string]*prometheus.Desc
+	infoLabel *prometheus.Desc
+	pollUp    *prometheus.Desc
+	errors    *prometheus.Desc
+}
+
+func newStatsCollector(ns string) *statsCollector {
+	d := statDescs{
+		stat:      make(map[int]map[string]*prometheus.Desc),
+		up:        make(map[int]*prometheus.Desc),
+		responses: make(map[int]*prometheus.Desc),
+		info:      make(map[string]*prometheus.Desc),
+	}
+	for typ, sub := range statSubsystems {
+		labels := []string{"proxy"}
+		if typ == haproxy.StatTypeServer {
+			labels = append(labels, "server")
+		}
+		d.stat[typ] = make(map[string]*prometheus.Desc)
+		for _, c := range statColumns {
+			d.stat[typ][c.column] = prometheus.NewDesc(prometheus.BuildFQName(ns, sub, c.name), c.help, labels, nil)
+		}
+		d.up[typ] = prometheus.NewDesc(prometheus.BuildFQName(ns, sub, "up"), "1 if status is UP or OPEN.", labels, nil)
+		d.responses[typ] = prometheus.NewDesc(prometheus.BuildFQName(ns, sub, "http_responses_total"), "HTTP responses by status class.",
+			append(append([]string{}, labels...), "code"), nil)
+	}
+	for _, c := range infoFields {
+		d.info[c.column] = prometheus.NewDesc(prometheus.BuildFQName(ns, "process", c.name), c.help, nil, nil)
+	}
+	d.infoLabel = prometheus.NewDesc(prometheus.BuildFQName(ns, "process", "info"), "HAProxy version, always 1.", []string{"version", "release_date"}, nil)
+	d.pollUp = prometheus.NewDesc(prometheus.BuildFQName(ns, "", "up"), "1 if last poll of the stats socket succeeded.", nil, nil)
+	d.errors = prometheus.NewDesc(prometheus.BuildFQName(ns, "", "poll_errors_total"), "Failed polls of the stats socket.", nil, nil)
+	return &statsCollector{descs: d}
+}
+
+// statsCollector keeps last polled stats
+type statsCollector struct {
+	lock   sync.Mutex
+	descs  statDescs
+	polled bool
+	up     bool
+	errors uint64
+	stat   []haproxy.Stat
+	info   haproxy.ProcessInfo
+}
+
+// Poll fetches stats and info from the source, they are exported until next Poll.
+// On error previous values are dropped, so stale stats are not mistaken for current ones
+func (e *Exporter) Poll(src StatsSource) error {
+	stat, err := src.ShowStat()
+	var info haproxy.ProcessInfo
+	if err == nil {
+		info, err = src.ShowInfo()
+	}
+	s := e.stats
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.polled = true
+	s.up = err == nil
+	if err != nil {
+		s.errors++
+		s.stat, s.info = nil, nil
+		return err
+	}
+	s.stat, s.info = stat, info
+	return nil
+}
+
+// RunPoller polls the source every interval until context is cancelled. Errors are passed to onError if it is not nil
+func (e *Exporter) RunPoller(ctx context.Context, src StatsSource, interval time.Duration, onError func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := e.Poll(src); err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for typ := range statSubsystems {
+		for _, d := range s.descs.stat[typ] {
+			ch <- d
+		}
+		ch <- s.descs.up[typ]
+		ch <- s.descs.responses[typ]
+	}
+	for _, d := range s.descs.info {
+		ch <- d
+	}
+	ch <- s.descs.infoLabel
+	ch <- s.descs.pollUp
+	ch <- s.descs.errors
+}
+
+func (s *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.polled {
+		return
+	}
+	up := 0.0
+	if s.up {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(s.descs.pollUp, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(s.descs.errors, prometheus.CounterValue, float64(s.errors))
+	for _, st := range s.stat {
+		s.collectStat(ch, st)
+	}
+	if s.info == nil {
+		return
+	}
+	for _, c := range infoFields {
+		if v, ok := parseFloat(s.info[c.column]); ok {
+			ch <- prometheus.MustNewConstMetric(s.descs.info[c.column], c.valueType, v/c.div)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(s.descs.infoLabel, prometheus.GaugeValue, 1, s.info["Version"], s.info["Release_date"])
+}
+
+func (s *statsCollector) collectStat(ch chan<- prometheus.Metric, st haproxy.Stat) {
+	descs, ok := s.descs.stat[st.Type]
+	if !ok {
+		return
+	}
+	labels := []string{st.ProxyName}
+	if st.Type == haproxy.StatTypeServer {
+		labels = append(labels, st.ServerName)
+	}
+	for _, c := range statColumns {
+		if v, ok := parseFloat(st.Fields[c.column]); ok {
+			ch <- prometheus.MustNewConstMetric(descs[c.column], c.valueType, v/c.div, labels...)
+		}
+	}
+	if status := st.Status(); status != "" {
+		up := 0.0
+		// "UP 1/3" is going down but still serving
+		if strings.HasPrefix(status, "UP") || status == "OPEN" || status == "no check" {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(s.descs.up[st.Type], prometheus.GaugeValue, up, labels...)
+	}
+	for _, code := range []string{"1xx", "2xx", "3xx", "4xx", "5xx", "other"} {
+		if v, ok := parseFloat(st.Fields["hrsp_"+code]); ok {
+			ch <- prometheus.MustNewConstMetric(s.descs.responses[st.Type], prometheus.CounterValue, v, append(labels, code)...)
+		}
+	}
+}
+
+func parseFloat(s string) (float64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
@@ -0,0 +1,101 @@
+package exporter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testStatsSource struct {
+	stat []haproxy.Stat
+	info haproxy.ProcessInfo
+	err  error
+}
+
+func (s *testStatsSource) ShowStat() ([]haproxy.Stat, error)      { return s.stat, s.err }
+func (s *testStatsSource) ShowInfo() (haproxy.ProcessInfo, error) { return s.info, s.err }
+
+func TestExporterStats(t *testing.T) {
+	e := New(Config{})
+	// nothing until first poll
+	assert.Equal(t, 0, testutil.CollectAndCount(e.stats))
+
+	src := &testStatsSource{
+		stat: []haproxy.Stat{
+			{ProxyName: "web", ServerName: "FRONTEND", Type: haproxy.StatTypeFrontend,
+				Fields: map[string]string{"scur": "3", "stot": "120", "status": "OPEN", "hrsp_2xx": "100", "hrsp_5xx": "5"}},
+			{ProxyName: "app", ServerName: "app1", Type: haproxy.StatTypeServer,
+				Fields: map[string]string{"scur": "1", "status": "UP 1/3", "check_duration": "15"}},
+			{ProxyName: "app", ServerName: "app2", Type: haproxy.StatTypeServer,
+				Fields: map[string]string{"scur": "0", "status": "DOWN"}},
+			{ProxyName: "web", ServerName: "sock-1", Type: haproxy.StatTypeListener,
+				Fields: map[string]string{"scur": "3"}},
+		},
+		info: haproxy.ProcessInfo{"Version": "2.6.6", "Release_date": "2022/09/22", "Uptime_sec": "3600", "Idle_pct": "95"},
+	}
+	require.NoError(t, e.Poll(src))
+	err := testutil.CollectAndCompare(e.stats, strings.NewReader(`
+# HELP haproxy_up 1 if last poll of the stats socket succeeded.
+# TYPE haproxy_up gauge
+haproxy_up 1
+# HELP haproxy_frontend_current_sessions Current sessions.
+# TYPE haproxy_frontend_current_sessions gauge
+haproxy_frontend_current_sessions{proxy="web"} 3
+# HELP haproxy_frontend_http_responses_total HTTP responses by status class.
+# TYPE haproxy_frontend_http_responses_total counter
+haproxy_frontend_http_responses_total{code="2xx",proxy="web"} 100
+haproxy_frontend_http_responses_total{code="5xx",proxy="web"} 5
+# HELP haproxy_frontend_up 1 if status is UP or OPEN.
+# TYPE haproxy_frontend_up gauge
+haproxy_frontend_up{proxy="web"} 1
+# HELP haproxy_server_current_sessions Current sessions.
+# TYPE haproxy_server_current_sessions gauge
+haproxy_server_current_sessions{proxy="app",server="app1"} 1
+haproxy_server_current_sessions{proxy="app",server="app2"} 0
+# HELP haproxy_server_check_duration_seconds Duration of last health check.
+# TYPE haproxy_server_check_duration_seconds gauge
+haproxy_server_check_duration_seconds{proxy="app",server="app1"} 0.015
+# HELP haproxy_server_up 1 if status is UP or OPEN.
+# TYPE haproxy_server_up gauge
+haproxy_server_up{proxy="app",server="app1"} 1
+haproxy_server_up{proxy="app",server="app2"} 0
+# HELP haproxy_process_uptime_seconds Process uptime.
+# TYPE haproxy_process_uptime_seconds gauge
+haproxy_process_uptime_seconds 3600
+# HELP haproxy_process_idle_ratio Idle time ratio.
+# TYPE haproxy_process_idle_ratio gauge
+haproxy_process_idle_ratio 0.95
+# HELP haproxy_process_info HAProxy version, always 1.
+# TYPE haproxy_process_info gauge
+haproxy_process_info{release_date="2022/09/22",version="2.6.6"} 1
+`), "haproxy_up", "haproxy_frontend_current_sessions", "haproxy_frontend_http_responses_total", "haproxy_frontend_up",
+		"haproxy_server_current_sessions", "haproxy_server_check_duration_seconds", "haproxy_server_up",
+		"haproxy_process_uptime_seconds", "haproxy_process_idle_ratio", "haproxy_process_info")
+	assert.NoError(t, err)
+
+	// failed poll drops stale stats
+	src.err = errors.New("connection refused")
+	assert.Error(t, e.Poll(src))
+	err = testutil.CollectAndCompare(e.stats, strings.NewReader(`
+# HELP haproxy_poll_errors_total Failed polls of the stats socket.
+# TYPE haproxy_poll_errors_total counter
+haproxy_poll_errors_total 1
+# HELP haproxy_up 1 if last poll of the stats socket succeeded.
+# TYPE haproxy_up gauge
+haproxy_up 0
+`))
+	assert.NoError(t, err)
+}
+
+func TestExporterDescribe(t *testing.T) {
+	// registering checks descriptors for duplicates and inconsistencies
+	e := New(Config{})
+	require.NoError(t, e.Poll(&testStatsSource{info: haproxy.ProcessInfo{"Version": "2.6.6"}}))
+	_, err := e.Registry().Gather()
+	assert.NoError(t, err)
+}
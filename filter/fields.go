@@ -0,0 +1,141 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	haproxy "github.com/efigence/go-haproxy"
+)
+
+// Field is a value of decoded request that can be filtered on, grouped by or printed
+type Field struct {
+	Name string
+	// numeric fields have Int, others Str
+	Int func(r *haproxy.HTTPRequest) int64
+	Str func(r *haproxy.HTTPRequest) string
+	// for boolean fields, usable in expressions without comparison
+	Bool func(r *haproxy.HTTPRequest) bool
+}
+
+// String returns field value as text
+func (f Field) String(r *haproxy.HTTPRequest) string {
+	switch {
+	case f.Int != nil:
+		return strconv.FormatInt(f.Int(r), 10)
+	case f.Bool != nil:
+		return strconv.FormatBool(f.Bool(r))
+	}
+	return f.Str(r)
+}
+
+func strField(get func(r *haproxy.HTTPRequest) string) Field {
+	return Field{Str: get}
+}
+
+func intField(get func(r *haproxy.HTTPRequest) int64) Field {
+	return Field{Int: get}
+}
+
+func boolField(get func(r *haproxy.HTTPRequest) bool) Field {
+	return Field{Bool: get}
+}
+
+// timer names are case-sensitive like in haproxy (TR is not Tr), lowercase aliases are added for the unambiguous ones.
+// Tq and Tt follow the log layout, in 1.7+ logs they are TR and Ta (RequestTimeMs/TotalTimeMs) so `tt > 2000` works for both
+var fields = map[string]Field{
+	"frontend":    strField(func(r *haproxy.HTTPRequest) string { return r.FrontendName }),
+	"backend":     strField(func(r *haproxy.HTTPRequest) string { return r.BackendName }),
+	"server":      strField(func(r *haproxy.HTTPRequest) string { return r.ServerName }),
+	"client_ip":   strField(func(r *haproxy.HTTPRequest) string { return r.ClientIP }),
+	"client_port": intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.ClientPort) }),
+	"method":      strField(func(r *haproxy.HTTPRequest) string { return r.RequestMethod }),
+	"uri":         strField(func(r *haproxy.HTTPRequest) string { return r.RequestPath }),
+	"path":        strField(func(r *haproxy.HTTPRequest) string { return r.Path() }),
+	"host":        strField(func(r *haproxy.HTTPRequest) string { return r.Host() }),
+	"version":     strField(func(r *haproxy.HTTPRequest) string { return r.ProtoString() }),
+	"status":      intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.StatusCode) }),
+	"bytes":       intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.BytesRead) }),
+	"Tq":          intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.RequestTimeMs()) }),
+	"Tw":          intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.QueueDurationMs) }),
+	"Tc":          intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.ServerConnDurationMs) }),
+	"Tr":          intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.ResponseHeaderDurationMs) }),
+	"Tt":          intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.TotalTimeMs()) }),
+	"TR":          intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.RequestReceiveDurationMs) }),
+	"Ta":          intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.ActiveDurationMs) }),
+	"Ti":          intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.IdleDurationMs) }),
+	// reason and phase, like sD
+	"termination": strField(func(r *haproxy.HTTPRequest) string {
+		return string([]rune{r.TerminationReason, r.SessionCloseState})
+	}),
+	// all four characters, like sD--
+	"termination_state": strField(func(r *haproxy.HTTPRequest) string { return string(r.TerminationState()) }),
+	"termination_class": strField(func(r *haproxy.HTTPRequest) string { return string(r.TerminationState().Class()) }),
+	"retries":           intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.Retries) }),
+	"backend_queue":     intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.BackendQueue) }),
+	"server_queue":      intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.ServerQueue) }),
+	"unique_id":         strField(func(r *haproxy.HTTPRequest) string { return r.UniqueID }),
+	"pid":               intField(func(r *haproxy.HTTPRequest) int64 { return int64(r.PID) }),
+	"hostname":          strField(func(r *haproxy.HTTPRequest) string { return r.Syslog.Hostname }),
+	"ts":                intField(func(r *haproxy.HTTPRequest) int64 { return r.TS }),
+	"ssl":               boolField(func(r *haproxy.HTTPRequest) bool { return r.ClientSSL }),
+	"bad_request":       boolField(func(r *haproxy.HTTPRequest) bool { return r.BadReq }),
+	"truncated":         boolField(func(r *haproxy.HTTPRequest) bool { return r.Truncated }),
+}
+
+func init() {
+	for _, t := range []string{"Tq", "Tw", "Tc", "Tr", "Tt", "Ta", "Ti"} {
+		fields[strings.ToLower(t)] = fields[t]
+	}
+}
+
+// LookupField finds field by name. Besides fixed fields, `req.<name>` and `res.<name>` are captured headers
+// (see haproxy.HTTPLogDecoder) and `extra.<name>` are extra log-format variables
+func LookupField(name string) (Field, error) {
+	if f, ok := fields[name]; ok {
+		f.Name = name
+		return f, nil
+	}
+	prefix, key, ok := strings.Cut(name, ".")
+	if ok && key != "" {
+		var f Field
+		switch prefix {
+		case "req":
+			f = strField(func(r *haproxy.HTTPRequest) string { return header(r.RequestHeaders, key) })
+		case "res":
+			f = strField(func(r *haproxy.HTTPRequest) string { return header(r.ResponseHeaders, key) })
+		case "extra":
+			f = strField(func(r *haproxy.HTTPRequest) string { return r.Extra[key] })
+		}
+		if f.Str != nil {
+			f.Name = name
+			return f, nil
+		}
+	}
+	return Field{}, errors.New(fmt.Sprintf("unknown field [%s]", name))
+}
+
+// FieldNames returns names of fixed fields, sorted
+func FieldNames() []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// header looks up captured header, exact name first
+func header(h map[string]string, name string) string {
+	if v, ok := h[name]; ok {
+		return v
+	}
+	for k, v := range h {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,304 @@
+// Package filter is a small expression language for selecting decoded requests, like
+//
+//	backend == "app" && status >= 500 && tt > 2000 && termination in ["sD", "SH"]
+//
+// Expressions are compiled once into closures with constants and regexps prepared, so matching is just the comparisons.
+//
+// Operators: == != < <= > >= on numbers and strings, =~ !~ (regexp), in / not in [list], && || ! (also and, or, not) and parentheses.
+// Strings are "double quoted" with Go escapes or 'single quoted' raw, handy for regexps.
+// Boolean fields (ssl, bad_request, truncated) can be used on their own. See FieldNames for the list of fields
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	haproxy "github.com/efigence/go-haproxy"
+)
+
+// Filter is compiled expression, it is safe for concurrent use
+type Filter struct {
+	expr  string
+	match func(r *haproxy.HTTPRequest) bool
+}
+
+// Compile parses expression. Empty expression matches everything
+func Compile(expr string) (*Filter, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	f := &Filter{expr: expr}
+	if tokens[0].kind == tokEOF {
+		f.match = func(*haproxy.HTTPRequest) bool { return true }
+		return f, nil
+	}
+	p := &parser{tokens: tokens}
+	f.match, err = p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.unexpected(t)
+	}
+	return f, nil
+}
+
+// MustCompile is Compile that panics on error
+func MustCompile(expr string) *Filter {
+	f, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Match evaluates filter against request
+func (f *Filter) Match(r *haproxy.HTTPRequest) bool {
+	return f.match(r)
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type matcher func(r *haproxy.HTTPRequest) bool
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes operator or keyword if it is next
+func (p *parser) accept(texts ...string) bool {
+	t := p.peek()
+	if t.kind != tokOp && t.kind != tokIdent {
+		return false
+	}
+	for _, s := range texts {
+		if t.text == s {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) unexpected(t token) error {
+	if t.kind == tokEOF {
+		return errors.New(fmt.Sprintf("unexpected end of expression at %d", t.pos))
+	}
+	return errors.New(fmt.Sprintf("unexpected [%s] at %d", t.text, t.pos))
+}
+
+func (p *parser) parseOr() (matcher, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||", "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(r *haproxy.HTTPRequest) bool { return l(r) || right(r) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (matcher, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&", "and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(r *haproxy.HTTPRequest) bool { return l(r) && right(r) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (matcher, error) {
+	if p.accept("!", "not") {
+		m, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(r *haproxy.HTTPRequest) bool { return !m(r) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (matcher, error) {
+	if p.accept("(") {
+		m, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.unexpected(p.peek())
+		}
+		return m, nil
+	}
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, p.unexpected(t)
+	}
+	switch t.text {
+	case "true":
+		return func(*haproxy.HTTPRequest) bool { return true }, nil
+	case "false":
+		return func(*haproxy.HTTPRequest) bool { return false }, nil
+	}
+	field, err := LookupField(t.text)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s at %d", err, t.pos))
+	}
+	op := p.peek()
+	switch {
+	case op.kind == tokOp && (op.text == "==" || op.text == "!=" || op.text == "<" || op.text == "<=" || op.text == ">" || op.text == ">="):
+		p.next()
+		return p.compare(field, op)
+	case op.kind == tokOp && (op.text == "=~" || op.text == "!~"):
+		p.next()
+		return p.regexp(field, op)
+	case op.kind == tokIdent && op.text == "in":
+		p.next()
+		return p.in(field, false)
+	case op.kind == tokIdent && op.text == "not" && p.tokens[p.pos+1].kind == tokIdent && p.tokens[p.pos+1].text == "in":
+		p.pos += 2
+		return p.in(field, true)
+	}
+	if field.Bool != nil {
+		return field.Bool, nil
+	}
+	return nil, errors.New(fmt.Sprintf("field [%s] at %d needs comparison", t.text, t.pos))
+}
+
+func (p *parser) compare(field Field, op token) (matcher, error) {
+	v := p.next()
+	if v.kind == tokEOF {
+		return nil, p.unexpected(v)
+	}
+	switch {
+	case field.Int != nil:
+		if v.kind != tokNumber {
+			return nil, errors.New(fmt.Sprintf("field [%s] is a number, got [%s] at %d", field.Name, v.text, v.pos))
+		}
+		get, n := field.Int, v.num
+		switch op.text {
+		case "==":
+			return func(r *haproxy.HTTPRequest) bool { return get(r) == n }, nil
+		case "!=":
+			return func(r *haproxy.HTTPRequest) bool { return get(r) != n }, nil
+		case "<":
+			return func(r *haproxy.HTTPRequest) bool { return get(r) < n }, nil
+		case "<=":
+			return func(r *haproxy.HTTPRequest) bool { return get(r) <= n }, nil
+		case ">":
+			return func(r *haproxy.HTTPRequest) bool { return get(r) > n }, nil
+		default:
+			return func(r *haproxy.HTTPRequest) bool { return get(r) >= n }, nil
+		}
+	case field.Bool != nil:
+		if v.kind != tokIdent || (v.text != "true" && v.text != "false") || (op.text != "==" && op.text != "!=") {
+			return nil, errors.New(fmt.Sprintf("field [%s] is a boolean, it can only be compared with == or != to true or false at %d", field.Name, v.pos))
+		}
+		get, want := field.Bool, (v.text == "true") == (op.text == "==")
+		return func(r *haproxy.HTTPRequest) bool { return get(r) == want }, nil
+	}
+	if v.kind != tokString {
+		return nil, errors.New(fmt.Sprintf("field [%s] is a string, got [%s] at %d", field.Name, v.text, v.pos))
+	}
+	get, s := field.Str, v.str
+	switch op.text {
+	case "==":
+		return func(r *haproxy.HTTPRequest) bool { return get(r) == s }, nil
+	case "!=":
+		return func(r *haproxy.HTTPRequest) bool { return get(r) != s }, nil
+	case "<":
+		return func(r *haproxy.HTTPRequest) bool { return get(r) < s }, nil
+	case "<=":
+		return func(r *haproxy.HTTPRequest) bool { return get(r) <= s }, nil
+	case ">":
+		return func(r *haproxy.HTTPRequest) bool { return get(r) > s }, nil
+	default:
+		return func(r *haproxy.HTTPRequest) bool { return get(r) >= s }, nil
+	}
+}
+
+func (p *parser) regexp(field Field, op token) (matcher, error) {
+	v := p.next()
+	if v.kind == tokEOF {
+		return nil, p.unexpected(v)
+	}
+	if v.kind != tokString {
+		return nil, errors.New(fmt.Sprintf("expected regexp string, got [%s] at %d", v.text, v.pos))
+	}
+	re, err := regexp.Compile(v.str)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid regexp at %d: %s", v.pos, err))
+	}
+	// numbers are matched as text
+	get := field.String
+	if field.Str != nil {
+		get = field.Str
+	}
+	if op.text == "!~" {
+		return func(r *haproxy.HTTPRequest) bool { return !re.MatchString(get(r)) }, nil
+	}
+	return func(r *haproxy.HTTPRequest) bool { return re.MatchString(get(r)) }, nil
+}
+
+func (p *parser) in(field Field, negate bool) (matcher, error) {
+	if !p.accept("[") {
+		return nil, p.unexpected(p.peek())
+	}
+	var ints map[int64]bool
+	var strs map[string]bool
+	if field.Int != nil {
+		ints = make(map[int64]bool)
+	} else if field.Str != nil {
+		strs = make(map[string]bool)
+	} else {
+		return nil, errors.New(fmt.Sprintf("field [%s] is a boolean, it can't be used with in", field.Name))
+	}
+	for !p.accept("]") {
+		if (len(ints) > 0 || len(strs) > 0) && !p.accept(",") {
+			return nil, p.unexpected(p.peek())
+		}
+		v := p.next()
+		switch {
+		case ints != nil && v.kind == tokNumber:
+			ints[v.num] = true
+		case strs != nil && v.kind == tokString:
+			strs[v.str] = true
+		default:
+			return nil, errors.New(fmt.Sprintf("invalid list value [%s] for field [%s] at %d", v.text, field.Name, v.pos))
+		}
+	}
+	if ints != nil {
+		get := field.Int
+		return func(r *haproxy.HTTPRequest) bool { return ints[get(r)] != negate }, nil
+	}
+	get := field.Str
+	return func(r *haproxy.HTTPRequest) bool { return strs[get(r)] != negate }, nil
+}
@@ -0,0 +1,142 @@
+package filter
+
+import (
+	"testing"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLine = `<158>Jul 23 13:49:13 lb1 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1~ app/app3 10/0/1/2000/2503 503 1140 - - sD-- 1637/7/5/6/0 0/0 "GET /api/v1/users?id=5 HTTP/1.1"`
+
+func testRequest(t testing.TB) *haproxy.HTTPRequest {
+	r, err := haproxy.DecodeHTTPLog(testLine)
+	require.NoError(t, err)
+	r.Extra = map[string]string{"tenant": "acme"}
+	r.RequestHeaders = map[string]string{"User-Agent": "curl/8.0"}
+	return &r
+}
+
+func TestFilterMatch(t *testing.T) {
+	r := testRequest(t)
+	for expr, want := range map[string]bool{
+		``:                                     true,
+		`true`:                                 true,
+		`false`:                                false,
+		`backend == "app"`:                     true,
+		`backend != "app"`:                     false,
+		`backend == 'app'`:                     true,
+		`status >= 500`:                        true,
+		`status < 500`:                         false,
+		`tt > 2000`:                            true,
+		`Tt > 2000 && Tr >= 2000`:              true,
+		`tq == 10 and tw == 0`:                 true,
+		`Tq <= 9 || Tc == 1`:                   true,
+		`termination in ["sD","SH"]`:           true,
+		`termination not in ["sD", "SH"]`:      false,
+		`termination_state == "sD--"`:          true,
+		`termination_class == "server"`:        true,
+		`status in [500, 502, 503]`:            true,
+		`method in []`:                         false,
+		`path == "/api/v1/users"`:              true,
+		`uri =~ "^/api/v\\d+/"`:                true,
+		`uri =~ '^/api/v\d+/'`:                 true,
+		`path !~ '^/api'`:                      false,
+		`status =~ '^5'`:                       true,
+		`ssl`:                                  true,
+		`!ssl`:                                 false,
+		`not ssl || truncated`:                 false,
+		`ssl == false`:                         false,
+		`ssl != false`:                         true,
+		`!(backend == "app" && status == 200)`: true,
+		`(status == 200 || status == 503) && server == "app3"`: true,
+		`bytes > 1000 && retries == 0`:                         true,
+		`extra.tenant == "acme"`:                               true,
+		`req.user-agent =~ "^curl/"`:                           true,
+		`res.Location == ""`:                                   true,
+		`hostname == "lb1" && pid == 11446`:                    true,
+		`client_ip == "83.3.255.169" && client_port > 60000`:   true,
+		`Tq == -1`: false,
+	} {
+		f, err := Compile(expr)
+		require.NoError(t, err, expr)
+		assert.Equal(t, want, f.Match(r), expr)
+		assert.Equal(t, expr, f.String())
+	}
+}
+
+func TestFilterTimersV17(t *testing.T) {
+	// same line in 1.7 layout has TR and Ta, Tq and Tt fields of the request are left empty
+	r, err := haproxy.HTTPLogV17.Decode(testLine)
+	require.NoError(t, err)
+	for _, expr := range []string{`tt > 2000`, `Tt == 2503 && Ta == 2503`, `tq == 10 && TR == 10`} {
+		assert.True(t, MustCompile(expr).Match(&r), expr)
+	}
+}
+
+func TestFilterPrecedence(t *testing.T) {
+	r := testRequest(t)
+	// && binds tighter than ||
+	assert.True(t, MustCompile(`status == 503 || status == 200 && backend == "x"`).Match(r))
+	assert.False(t, MustCompile(`(status == 503 || status == 200) && backend == "x"`).Match(r))
+	assert.True(t, MustCompile(`!!ssl`).Match(r))
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		`nope == 1`,
+		`status == "500"`,
+		`backend == 5`,
+		`backend`,
+		`backend ==`,
+		`backend == "app" &&`,
+		`(status == 500`,
+		`status == 500)`,
+		`status == 500 status`,
+		`backend == "app`,
+		`backend == 'app`,
+		`uri =~ "("`,
+		`uri =~ 5`,
+		`status in [500, "x"]`,
+		`status in [500 502]`,
+		`status in 500`,
+		`ssl in [true]`,
+		`ssl > true`,
+		`backend # "x"`,
+		`foo.bar == "x"`,
+		`req. == "x"`,
+	} {
+		_, err := Compile(expr)
+		assert.Error(t, err, expr)
+	}
+	assert.Panics(t, func() { MustCompile(`(`) })
+}
+
+func TestLookupField(t *testing.T) {
+	r := testRequest(t)
+	for name, want := range map[string]string{
+		"status":         "503",
+		"termination":    "sD",
+		"ssl":            "true",
+		"req.User-Agent": "curl/8.0",
+		"version":        "HTTP/1.1",
+	} {
+		f, err := LookupField(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, f.Name)
+		assert.Equal(t, want, f.String(r), name)
+	}
+	assert.Contains(t, FieldNames(), "tt")
+	assert.Contains(t, FieldNames(), "TR")
+}
+
+func BenchmarkFilterMatch(b *testing.B) {
+	r := testRequest(b)
+	f := MustCompile(`backend == "app" && status >= 500 && tt > 2000 && termination in ["sD","SH"]`)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Match(r)
+	}
+}
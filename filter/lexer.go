@@ -0,0 +1,109 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	// operators and punctuation, text is in token.text
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// unquoted value of string and parsed value of number
+	str string
+	num int64
+	pos int
+}
+
+// two-char operators first so `<=` is not lexed as `<`
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func lex(s string) ([]token, error) {
+	var out []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isIdentStart(c):
+			start := i
+			for i < len(s) && isIdentChar(s[i]) {
+				i++
+			}
+			out = append(out, token{kind: tokIdent, text: s[start:i], pos: start})
+		case c >= '0' && c <= '9' || (c == '-' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9'):
+			start := i
+			i++
+			for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+				i++
+			}
+			n, err := strconv.ParseInt(s[start:i], 10, 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid number [%s] at %d", s[start:i], start))
+			}
+			out = append(out, token{kind: tokNumber, text: s[start:i], num: n, pos: start})
+		case c == '"':
+			start := i
+			i++
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(s) {
+				return nil, errors.New(fmt.Sprintf("unterminated string at %d", start))
+			}
+			i++
+			v, err := strconv.Unquote(s[start:i])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid string %s at %d: %s", s[start:i], start, err))
+			}
+			out = append(out, token{kind: tokString, text: s[start:i], str: v, pos: start})
+		case c == '\'':
+			// raw string, handy for regexes
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New(fmt.Sprintf("unterminated string at %d", i))
+			}
+			out = append(out, token{kind: tokString, text: s[i : i+end+2], str: s[i+1 : i+1+end], pos: i})
+			i += end + 2
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, errors.New(fmt.Sprintf("unexpected character %q at %d", c, i))
+			}
+			out = append(out, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(out, token{kind: tokEOF, pos: len(s)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// dots and dashes allow `req.User-Agent`
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9' || c == '.' || c == '-'
+}
@@ -0,0 +1,287 @@
+package haproxytest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// StartTimeout is how long Instance waits for haproxy stats socket to appear
+var StartTimeout = 10 * time.Second
+
+// Instance is a real haproxy process started for the duration of a test
+type Instance struct {
+	// temporary directory with config and socket, removed after test
+	Dir string
+	// rendered config
+	ConfigPath string
+	// stats socket, pass it to haproxy.New()
+	SocketPath string
+	// haproxy binary used
+	Binary string
+
+	t      testing.TB
+	tmpl   *template.Template
+	data   *TemplateData
+	lock   sync.Mutex
+	cmd    *exec.Cmd
+	exited chan struct{}
+	stderr *lockedBuffer
+}
+
+// TemplateData is passed to config template
+type TemplateData struct {
+	// temporary directory of the instance
+	Dir string
+	// path of stats socket that should be used in `stats socket` line
+	Socket string
+	// user data passed to NewInstance
+	Data interface{}
+
+	ports map[string]int
+}
+
+// Port returns free TCP port on 127.0.0.1 for given name. Same name always returns same port,
+// including after reload
+func (d *TemplateData) Port(name string) (int, error) {
+	if p, ok := d.ports[name]; ok {
+		return p, nil
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	p := l.Addr().(*net.TCPAddr).Port
+	d.ports[name] = p
+	return p, nil
+}
+
+// FindBinary returns path to haproxy binary: HAPROXY_BIN environment variable, haproxy from PATH or from usual sbin directories.
+// Returns empty string if not found
+func FindBinary() string {
+	if bin := os.Getenv("HAPROXY_BIN"); bin != "" {
+		return bin
+	}
+	if bin, err := exec.LookPath("haproxy"); err == nil {
+		return bin
+	}
+	for _, bin := range []string{"/usr/sbin/haproxy", "/usr/local/sbin/haproxy"} {
+		if _, err := os.Stat(bin); err == nil {
+			return bin
+		}
+	}
+	return ""
+}
+
+// NewInstance renders configTemplate (text/template, see TemplateData for available fields) into temporary directory
+// and starts haproxy with it, waiting until its stats socket accepts connections.
+// Test is skipped if there is no haproxy binary. Instance is stopped when test finishes
+func NewInstance(t testing.TB, configTemplate string, data interface{}) *Instance {
+	t.Helper()
+	bin := FindBinary()
+	if bin == "" {
+		t.Skip("haproxy binary not found, set HAPROXY_BIN to run this test")
+	}
+	tmpl, err := template.New("haproxy.cfg").Parse(configTemplate)
+	if err != nil {
+		t.Fatalf("can't parse haproxy config template: %s", err)
+	}
+	dir := t.TempDir()
+	i := &Instance{
+		Dir:        dir,
+		ConfigPath: filepath.Join(dir, "haproxy.cfg"),
+		SocketPath: filepath.Join(dir, "haproxy.sock"),
+		Binary:     bin,
+		t:          t,
+		tmpl:       tmpl,
+		data:       &TemplateData{Dir: dir, Data: data, ports: make(map[string]int)},
+		stderr:     &lockedBuffer{},
+	}
+	i.data.Socket = i.SocketPath
+	t.Cleanup(i.Stop)
+	if err := i.start(); err != nil {
+		t.Fatalf("can't start haproxy: %s", err)
+	}
+	return i
+}
+
+// NewInstanceFromFile is NewInstance with config template read from file
+func NewInstanceFromFile(t testing.TB, path string, data interface{}) *Instance {
+	t.Helper()
+	cfg, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("can't read haproxy config template: %s", err)
+	}
+	return NewInstance(t, string(cfg), data)
+}
+
+// Port returns port allocated for name in config template
+func (i *Instance) Port(name string) int {
+	p, err := i.data.Port(name)
+	if err != nil {
+		i.t.Fatalf("can't allocate port: %s", err)
+	}
+	return p
+}
+
+// Addr returns 127.0.0.1:port address for named port
+func (i *Instance) Addr(name string) string {
+	return fmt.Sprintf("127.0.0.1:%d", i.Port(name))
+}
+
+// Stderr returns everything haproxy printed on stderr so far
+func (i *Instance) Stderr() string {
+	return i.stderr.String()
+}
+
+// Reload re-renders config with new data (nil keeps current one) and gracefully replaces running haproxy with new process (-sf)
+func (i *Instance) Reload(data interface{}) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	prevData := i.data.Data
+	if data != nil {
+		i.data.Data = data
+	}
+	old := i.cmd
+	oldExited := i.exited
+	err := i.render()
+	if err == nil {
+		err = i.check()
+	}
+	if err != nil {
+		i.data.Data = prevData
+		return err
+	}
+	args := []string{"-db", "-f", i.ConfigPath}
+	if old != nil {
+		args = append(args, "-sf", fmt.Sprint(old.Process.Pid))
+	}
+	if err := i.run(args); err != nil {
+		return err
+	}
+	if oldExited != nil {
+		select {
+		case <-oldExited:
+		case <-time.After(StartTimeout):
+			old.Process.Kill()
+			<-oldExited
+		}
+	}
+	return i.waitReady()
+}
+
+// Stop kills haproxy. It is called automatically at the end of the test
+func (i *Instance) Stop() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.cmd == nil {
+		return
+	}
+	i.cmd.Process.Kill()
+	<-i.exited
+	i.cmd = nil
+	if i.t.Failed() && i.stderr.Len() > 0 {
+		i.t.Logf("haproxy stderr:\n%s", i.stderr.String())
+	}
+}
+
+func (i *Instance) start() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if err := i.render(); err != nil {
+		return err
+	}
+	if err := i.run([]string{"-db", "-f", i.ConfigPath}); err != nil {
+		return err
+	}
+	return i.waitReady()
+}
+
+func (i *Instance) render() error {
+	var buf bytes.Buffer
+	if err := i.tmpl.Execute(&buf, i.data); err != nil {
+		return fmt.Errorf("can't render config: %w", err)
+	}
+	return os.WriteFile(i.ConfigPath, buf.Bytes(), 0o600)
+}
+
+// check validates config before reload so broken config doesn't kill running instance
+func (i *Instance) check() error {
+	out, err := exec.Command(i.Binary, "-c", "-f", i.ConfigPath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("config check failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+func (i *Instance) run(args []string) error {
+	cmd := exec.Command(i.Binary, args...)
+	cmd.Stderr = i.stderr
+	cmd.Stdout = i.stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	i.cmd = cmd
+	i.exited = exited
+	return nil
+}
+
+func (i *Instance) waitReady() error {
+	deadline := time.Now().Add(StartTimeout)
+	delay := 5 * time.Millisecond
+	for {
+		select {
+		case <-i.exited:
+			return fmt.Errorf("haproxy exited: %s", i.stderr.String())
+		default:
+		}
+		conn, err := net.Dial("unix", i.SocketPath)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("stats socket %s not ready after %s: %s | stderr: %s", i.SocketPath, StartTimeout, err, i.stderr.String())
+		}
+		time.Sleep(delay)
+		if delay < 200*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
+
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Len()
+}
@@ -0,0 +1,51 @@
+package haproxytest_test
+
+import (
+	"net/http"
+	"testing"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/efigence/go-haproxy/haproxytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testConfig = `
+global
+    stats socket unix@{{ .Socket }} mode 600 level admin
+
+defaults
+    mode http
+    timeout connect 1s
+    timeout client  10s
+    timeout server  10s
+
+frontend f_test
+    bind 127.0.0.1:{{ .Port "http" }}
+    http-request return status {{ .Data }}
+`
+
+func TestInstance(t *testing.T) {
+	ha := haproxytest.NewInstance(t, testConfig, 200)
+	c := haproxy.New(ha.SocketPath)
+	out, err := c.RunCmd("show info")
+	require.NoError(t, err)
+	assert.Contains(t, out[0], "Name: HAProxy")
+
+	resp, err := http.Get("http://" + ha.Addr("http") + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	port := ha.Port("http")
+	require.NoError(t, ha.Reload(204))
+	assert.Equal(t, port, ha.Port("http"))
+	resp, err = http.Get("http://" + ha.Addr("http") + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 204, resp.StatusCode)
+
+	assert.Error(t, ha.Reload("not-a-status"), "broken config should not replace running instance")
+	_, err = c.RunCmd("show info")
+	assert.NoError(t, err)
+}
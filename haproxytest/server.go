@@ -0,0 +1,1055 @@
+// Package haproxytest provides utilities for testing code that talks to HAProxy runtime API
+// without having to run real haproxy
+package haproxytest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HandlerFunc returns response for a command. args are whitespace-separated words of the command.
+// Response is sent as-is, server adds the empty line haproxy sends after each command
+type HandlerFunc func(args []string) string
+
+// Fault describes error injected into command processing
+type Fault struct {
+	// replace response with this text, for example "Permission denied"
+	Response string
+	// close connection without sending anything
+	Drop bool
+	// wait before responding
+	Delay time.Duration
+	// how many times fault should trigger, 0 means every time
+	Times int
+}
+
+// Server is in-memory emulation of HAProxy runtime API (stats socket) listening on unix socket.
+// It emulates ACLs, maps, servers, stats, info, stick tables and process variables with haproxy output formats,
+// anything else can be added via Handle/SetResponse
+type Server struct {
+	// path to unix socket, pass it to haproxy.New()
+	SocketPath string
+
+	listener net.Listener
+	dir      string
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	nextID    int
+	nextPtr   uint64
+	refs      []*patRef
+	frontends []string
+	backends  []*backend
+	tables    []*table
+	info      [][2]string
+	env       [][2]string
+	vars      map[string]procVar
+	stats     map[string]map[string]string
+	handlers  map[string]HandlerFunc
+	faults    map[string]*Fault
+	commands  []string
+}
+
+type patRef struct {
+	id      int
+	kind    string // "acl" or "map"
+	file    string // empty for inline ACLs
+	desc    string // ACL type for inline ACLs
+	cfgFile string
+	cfgLine int
+	entries []patEntry
+}
+
+type patEntry struct {
+	ptr   uint64
+	key   string
+	value string
+}
+
+type backend struct {
+	id      int
+	name    string
+	servers []*server
+}
+
+type server struct {
+	id         int
+	name       string
+	addr       string
+	port       int
+	opState    int
+	adminState int
+	weight     int
+	iweight    int
+}
+
+type table struct {
+	name    string
+	typ     string
+	size    int
+	entries []*tableEntry
+}
+
+type tableEntry struct {
+	ptr  uint64
+	key  string
+	data [][2]string
+}
+
+type procVar struct {
+	typ   string
+	value string
+}
+
+const (
+	srvAdminMaint = 0x01
+	srvAdminDrain = 0x08
+	srvOpStopped  = 0
+	srvOpRunning  = 2
+	configFile    = "/etc/haproxy/haproxy.cfg"
+)
+
+// NewServer starts fake haproxy runtime API on unix socket in temporary directory.
+// Like httptest.NewServer, it panics if it can't listen. Call Close when done
+func NewServer() *Server {
+	dir, err := os.MkdirTemp("", "haproxytest")
+	if err != nil {
+		panic(fmt.Sprintf("haproxytest: can't create temporary directory: %s", err))
+	}
+	s := newServer()
+	s.dir = dir
+	s.SocketPath = filepath.Join(dir, "haproxy.sock")
+	s.listener, err = net.Listen("unix", s.SocketPath)
+	if err != nil {
+		os.RemoveAll(dir)
+		panic(fmt.Sprintf("haproxytest: can't listen on %s: %s", s.SocketPath, err))
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		nextPtr:  0x55d8a8c90000,
+		vars:     make(map[string]procVar),
+		stats:    make(map[string]map[string]string),
+		handlers: make(map[string]HandlerFunc),
+		faults:   make(map[string]*Fault),
+		info: [][2]string{
+			{"Name", "HAProxy"},
+			{"Version", "2.8.3"},
+			{"Release_date", "2023/09/08"},
+			{"Nbthread", "1"},
+			{"Nbproc", "1"},
+			{"Process_num", "1"},
+			{"Pid", "1"},
+			{"Uptime", "0d 0h00m10s"},
+			{"Uptime_sec", "10"},
+			{"Memmax_MB", "0"},
+			{"PoolAlloc_MB", "0"},
+			{"PoolUsed_MB", "0"},
+			{"PoolFailed", "0"},
+			{"Ulimit-n", "4033"},
+			{"Maxsock", "4033"},
+			{"Maxconn", "2000"},
+			{"Hard_maxconn", "2000"},
+			{"CurrConns", "0"},
+			{"CumConns", "1"},
+			{"CumReq", "1"},
+			{"Maxpipes", "0"},
+			{"PipesUsed", "0"},
+			{"PipesFree", "0"},
+			{"ConnRate", "0"},
+			{"ConnRateLimit", "0"},
+			{"MaxConnRate", "0"},
+			{"SessRate", "0"},
+			{"SessRateLimit", "0"},
+			{"MaxSessRate", "0"},
+			{"Tasks", "10"},
+			{"Run_queue", "1"},
+			{"Idle_pct", "100"},
+			{"node", "localhost"},
+			{"Stopping", "0"},
+			{"Jobs", "3"},
+			{"Unstoppable Jobs", "1"},
+			{"Listeners", "2"},
+			{"ActivePeers", "0"},
+			{"ConnectedPeers", "0"},
+			{"DroppedLogs", "0"},
+			{"BusyPolling", "0"},
+			{"FailedResolutions", "0"},
+			{"TotalBytesOut", "0"},
+			{"TotalSplicedBytesOut", "0"},
+			{"BytesOutRate", "0"},
+			{"DebugCommandsIssued", "0"},
+			{"CumRecvLogs", "0"},
+			{"Build info", "2.8.3"},
+		},
+	}
+}
+
+// Close stops the server and removes the socket
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	os.RemoveAll(s.dir)
+	return err
+}
+
+// AddACLFile adds ACL loaded from file (via -f) with entries, returns its ID
+func (s *Server) AddACLFile(file string, entries ...string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addRef("acl", file, "", entries, nil)
+}
+
+// AddInlineACL adds ACL defined in config, like `acl blocked path_beg /admin`, returns its ID
+func (s *Server) AddInlineACL(aclType string, line int, entries ...string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.addRef("acl", "", aclType, entries, nil)
+	s.refs[len(s.refs)-1].cfgLine = line
+	return id
+}
+
+// AddMap adds map loaded from file, kv is list of key, value pairs. Returns map ID
+func (s *Server) AddMap(file string, kv ...string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys, values []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		keys = append(keys, kv[i])
+		values = append(values, kv[i+1])
+	}
+	return s.addRef("map", file, "", keys, values)
+}
+
+// AddFrontend adds frontend visible in stats
+func (s *Server) AddFrontend(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frontends = append(s.frontends, name)
+}
+
+// AddBackend adds backend with servers. Servers are given as "name" or "name=addr:port"
+func (s *Server) AddBackend(name string, servers ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &backend{id: len(s.backends) + 1, name: name}
+	for i, srv := range servers {
+		sv := &server{id: i + 1, name: srv, addr: "127.0.0.1", port: 8080 + i, opState: srvOpRunning, weight: 1, iweight: 1}
+		if parts := strings.SplitN(srv, "=", 2); len(parts) == 2 {
+			sv.name = parts[0]
+			if host, port, err := net.SplitHostPort(parts[1]); err == nil {
+				sv.addr = host
+				sv.port, _ = strconv.Atoi(port)
+			} else {
+				sv.addr = parts[1]
+			}
+		}
+		b.servers = append(b.servers, sv)
+	}
+	s.backends = append(s.backends, b)
+}
+
+// SetServerUp changes operational state of the server, like health checks would
+func (s *Server) SetServerUp(backend, name string, up bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sv := s.findServer(backend + "/" + name); sv != nil {
+		if up {
+			sv.opState = srvOpRunning
+		} else {
+			sv.opState = srvOpStopped
+		}
+	}
+}
+
+// AddTable adds stick table
+func (s *Server) AddTable(name string, typ string, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables = append(s.tables, &table{name: name, typ: typ, size: size})
+}
+
+// SetTableEntry sets data of the stick table entry, creating it if needed. data is list of name, value pairs like "gpc0", "1"
+func (s *Server) SetTableEntry(tableName string, key string, data ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.findTable(tableName)
+	if t == nil {
+		return
+	}
+	e := t.entry(key, s)
+	for i := 0; i+1 < len(data); i += 2 {
+		e.set(data[i], data[i+1])
+	}
+}
+
+// SetInfo sets (or adds) field returned by `show info`
+func (s *Server) SetInfo(name string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info = setKV(s.info, name, value)
+}
+
+// SetStat overrides field of `show stat` row for proxy/server (use FRONTEND/BACKEND as server for proxy rows)
+func (s *Server) SetStat(proxy, server, field, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := proxy + "/" + server
+	if s.stats[key] == nil {
+		s.stats[key] = make(map[string]string)
+	}
+	s.stats[key][field] = value
+}
+
+// SetEnv sets environment variable returned by `show env`
+func (s *Server) SetEnv(name string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.env = setKV(s.env, name, value)
+}
+
+// Handle registers handler for all commands starting with prefix. Longest matching prefix wins, handlers take precedence over builtin commands
+func (s *Server) Handle(prefix string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[prefix] = fn
+}
+
+// SetResponse makes server respond with fixed text to all commands starting with prefix
+func (s *Server) SetResponse(prefix string, response string) {
+	s.Handle(prefix, func([]string) string { return response })
+}
+
+// InjectFault makes commands starting with prefix fail. Empty prefix matches every command
+func (s *Server) InjectFault(prefix string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[prefix] = &f
+}
+
+// ClearFaults removes all injected faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = make(map[string]*Fault)
+}
+
+// Commands returns all commands received so far, in order
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// ACL returns current entries of ACL (by "#id" or file name)
+func (s *Server) ACL(ref string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.findRef("acl", ref)
+	if r == nil {
+		return nil
+	}
+	var out []string
+	for _, e := range r.entries {
+		out = append(out, e.key)
+	}
+	return out
+}
+
+// Map returns current content of map (by "#id" or file name)
+func (s *Server) Map(ref string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.findRef("map", ref)
+	if r == nil {
+		return nil
+	}
+	out := make(map[string]string)
+	for _, e := range r.entries {
+		out[e.key] = e.value
+	}
+	return out
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// haproxy in non-interactive mode reads single line, runs all ;-separated commands in it and closes connection
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	// per-session `set severity-output`
+	severityOutput := "none"
+	for _, cmd := range splitCommands(strings.TrimRight(line, "\r\n")) {
+		out, drop := s.run(cmd)
+		if drop {
+			return
+		}
+		args := strings.Fields(cmd)
+		if sev := messageSeverity(args, out); sev >= 0 {
+			switch severityOutput {
+			case "number":
+				out = fmt.Sprintf("[%d]: %s", sev, out)
+			case "string":
+				out = fmt.Sprintf("[%s]: %s", severityNames[sev], out)
+			}
+		}
+		if out == "" && len(args) == 3 && args[0] == "set" && args[1] == "severity-output" {
+			severityOutput = args[2]
+		}
+		if _, err := fmt.Fprintf(conn, "%s\n", out); err != nil {
+			return
+		}
+	}
+}
+
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// messageSeverity returns severity haproxy prefixes response with when severity output is on, -1 if it doesn't.
+// Only messages are prefixed, not dumps. Here that is single-line responses of commands that don't show anything,
+// which are all errors except value of `get var`
+func messageSeverity(args []string, out string) int {
+	if len(args) == 0 || out == "" || strings.Count(out, "\n") > 1 || args[0] == "show" || (args[0] == "get" && len(args) > 1 && args[1] != "var") {
+		return -1
+	}
+	if out == "Done.\n" || strings.Contains(out, ": type=") {
+		return 6
+	}
+	return 3
+}
+
+func (s *Server) run(cmd string) (out string, drop bool) {
+	s.mu.Lock()
+	s.commands = append(s.commands, cmd)
+	fault := s.matchFault(cmd)
+	var delay time.Duration
+	if fault != nil {
+		delay = fault.Delay
+	}
+	s.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if fault != nil && (fault.Drop || fault.Response != "") {
+		return fault.Response, fault.Drop
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	args := strings.Fields(cmd)
+	if len(args) == 0 {
+		return "", false
+	}
+	if h := s.matchHandler(cmd); h != nil {
+		return h(args), false
+	}
+	return s.builtin(args), false
+}
+
+func (s *Server) matchFault(cmd string) *Fault {
+	prefix, f := "", (*Fault)(nil)
+	for p, fault := range s.faults {
+		if strings.HasPrefix(cmd, p) && (f == nil || len(p) > len(prefix)) {
+			prefix, f = p, fault
+		}
+	}
+	if f != nil && f.Times > 0 {
+		f.Times--
+		if f.Times == 0 {
+			delete(s.faults, prefix)
+		}
+	}
+	return f
+}
+
+func (s *Server) matchHandler(cmd string) HandlerFunc {
+	prefix, h := "", HandlerFunc(nil)
+	for p, handler := range s.handlers {
+		if strings.HasPrefix(cmd, p) && (h == nil || len(p) > len(prefix)) {
+			prefix, h = p, handler
+		}
+	}
+	return h
+}
+
+func (s *Server) builtin(args []string) string {
+	cmd := strings.Join(args, " ")
+	switch {
+	case len(args) >= 2 && args[1] == "acl":
+		return s.cmdPattern("acl", args)
+	case len(args) >= 2 && args[1] == "map":
+		return s.cmdPattern("map", args)
+	case cmd == "show stat" || strings.HasPrefix(cmd, "show stat "):
+		return s.showStat()
+	case cmd == "show info" || strings.HasPrefix(cmd, "show info "):
+		return s.showInfo()
+	case strings.HasPrefix(cmd, "show servers state"):
+		return s.showServersState(args[3:])
+	case strings.HasPrefix(cmd, "show backend"):
+		out := "# name\n"
+		for _, b := range s.backends {
+			out += b.name + "\n"
+		}
+		return out
+	case len(args) >= 3 && args[1] == "server" && (args[0] == "set" || args[0] == "enable" || args[0] == "disable"):
+		return s.cmdServer(args)
+	case len(args) == 3 && args[0] == "get" && args[1] == "weight":
+		sv := s.findServer(args[2])
+		if sv == nil {
+			return "No such server.\n"
+		}
+		return fmt.Sprintf("%d (initial %d)\n", sv.weight, sv.iweight)
+	case len(args) >= 2 && args[1] == "table":
+		return s.cmdTable(args)
+	case cmd == "show env" || strings.HasPrefix(cmd, "show env "):
+		return s.showEnv(args[2:])
+	case len(args) >= 2 && args[1] == "var":
+		return s.cmdVar(args)
+	case len(args) >= 3 && args[0] == "set" && args[1] == "severity-output":
+		// per-session, handled by handleConn
+		if len(args) != 3 || (args[2] != "none" && args[2] != "number" && args[2] != "string") {
+			return "one of 'none', 'number', 'string' is expected\n"
+		}
+		return ""
+	case len(args) >= 3 && args[0] == "set" && args[1] == "timeout":
+		// per-session, nothing to emulate
+		if len(args) != 4 || args[2] != "cli" {
+			return "'set timeout' only supports 'cli'.\n"
+		}
+		if n, err := strconv.Atoi(args[3]); err != nil || n <= 0 {
+			return "Expects an integer value.\n"
+		}
+		return ""
+	}
+	return fmt.Sprintf("Unknown command: '%s'\n\nThe following ones are valid:\n  help           : full commands list\n  prompt         : toggle interactive mode with prompt\n  quit           : disconnect\n", args[0])
+}
+
+// ACL and map commands share the implementation as they do in haproxy
+func (s *Server) cmdPattern(kind string, args []string) string {
+	if args[0] == "show" && len(args) == 2 {
+		out := "# id (file) description\n"
+		for _, r := range s.refs {
+			if r.kind != kind {
+				continue
+			}
+			if r.file == "" {
+				out += fmt.Sprintf("%d () %s '%s' file '%s' line %d. curr_ver=0 next_ver=0 entry_cnt=%d\n", r.id, kind, r.desc, r.cfgFile, r.cfgLine, len(r.entries))
+			} else {
+				out += fmt.Sprintf("%d (%s) pattern loaded from file '%s' used by %s at file '%s' line %d. curr_ver=0 next_ver=0 entry_cnt=%d\n", r.id, r.file, r.file, kind, r.cfgFile, r.cfgLine, len(r.entries))
+			}
+		}
+		return out
+	}
+	if len(args) < 3 {
+		return fmt.Sprintf("Missing %s identifier.\n", strings.ToUpper(kind))
+	}
+	r := s.findRef(kind, args[2])
+	if r == nil {
+		return fmt.Sprintf("Unknown %s identifier. Please use #<id> or <file>.\n", strings.ToUpper(kind))
+	}
+	switch args[0] {
+	case "show":
+		var out string
+		for _, e := range r.entries {
+			if kind == "map" {
+				out += fmt.Sprintf("0x%x %s %s\n", e.ptr, e.key, e.value)
+			} else {
+				out += fmt.Sprintf("0x%x %s\n", e.ptr, e.key)
+			}
+		}
+		return out
+	case "add":
+		if len(args) < 4 || (kind == "map" && len(args) < 5) {
+			return "'add " + kind + "' expects two parameters: " + kind + " identifier and pattern.\n"
+		}
+		value := ""
+		if kind == "map" {
+			value = strings.Join(args[4:], " ")
+		}
+		r.entries = append(r.entries, patEntry{ptr: s.ptr(), key: args[3], value: value})
+		return ""
+	case "del":
+		if len(args) < 4 {
+			return "This command expects two parameters: " + kind + " identifier and key.\n"
+		}
+		var kept []patEntry
+		for _, e := range r.entries {
+			if e.key != args[3] && fmt.Sprintf("#0x%x", e.ptr) != args[3] {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == len(r.entries) {
+			return "Key not found.\n"
+		}
+		r.entries = kept
+		return ""
+	case "clear":
+		r.entries = nil
+		return ""
+	case "set":
+		if kind != "map" || len(args) < 5 {
+			return "'set map' expects three parameters: map identifier, key and value.\n"
+		}
+		found := false
+		for i := range r.entries {
+			if r.entries[i].key == args[3] || fmt.Sprintf("#0x%x", r.entries[i].ptr) == args[3] {
+				r.entries[i].value = strings.Join(args[4:], " ")
+				found = true
+			}
+		}
+		if !found {
+			return "entry not found.\n"
+		}
+		return ""
+	case "get":
+		if len(args) < 4 {
+			return "Missing input string.\n"
+		}
+		for _, e := range r.entries {
+			if e.key == args[3] {
+				if kind == "map" {
+					return fmt.Sprintf("type=str, case=sensitive, found=yes, idx=tree, key=\"%s\", value=\"%s\", type=\"str\"\n", e.key, e.value)
+				}
+				return fmt.Sprintf("type=str, case=sensitive, match=yes, idx=tree, pattern=\"%s\"\n", e.key)
+			}
+		}
+		if kind == "map" {
+			return "type=str, case=sensitive, found=no\n"
+		}
+		return "type=str, case=sensitive, match=no\n"
+	}
+	return fmt.Sprintf("Unknown command: '%s'\n", args[0])
+}
+
+var statFields = []string{
+	"pxname", "svname", "qcur", "qmax", "scur", "smax", "slim", "stot", "bin", "bout", "dreq", "dresp", "ereq", "econ", "eresp",
+	"wretr", "wredis", "status", "weight", "act", "bck", "chkfail", "chkdown", "lastchg", "downtime", "qlimit", "pid", "iid", "sid",
+	"throttle", "lbtot", "tracked", "type", "rate", "rate_lim", "rate_max", "check_status", "check_code", "check_duration",
+	"hrsp_1xx", "hrsp_2xx", "hrsp_3xx", "hrsp_4xx", "hrsp_5xx", "hrsp_other", "hanafail", "req_rate", "req_rate_max", "req_tot",
+	"cli_abrt", "srv_abrt", "comp_in", "comp_out", "comp_byp", "comp_rsp", "lastsess", "last_chk", "last_agt", "qtime", "ctime",
+	"rtime", "ttime", "agent_status", "agent_code", "agent_duration", "check_desc", "agent_desc", "check_rise", "check_fall",
+	"check_health", "agent_rise", "agent_fall", "agent_health", "addr", "cookie", "mode", "algo", "conn_rate", "conn_rate_max",
+	"conn_tot", "intercepted", "dcon", "dses", "wrew", "connect", "reuse", "cache_lookups", "cache_hits", "srv_icur", "src_ilim",
+	"qtime_max", "ctime_max", "rtime_max", "ttime_max", "eint", "idle_conn_cur", "safe_conn_cur", "used_conn_cur",
+	"need_conn_est", "uweight", "agg_server_status", "agg_server_check_status", "agg_check_status",
+}
+
+func (s *Server) showStat() string {
+	var b strings.Builder
+	b.WriteString("# " + strings.Join(statFields, ",") + ",\n")
+	row := func(defaults map[string]string) {
+		key := defaults["pxname"] + "/" + defaults["svname"]
+		for k, v := range s.stats[key] {
+			defaults[k] = v
+		}
+		for _, f := range statFields {
+			b.WriteString(defaults[f])
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	iid := 0
+	for _, fe := range s.frontends {
+		iid++
+		row(map[string]string{"pxname": fe, "svname": "FRONTEND", "scur": "0", "smax": "0", "slim": "2000", "stot": "0",
+			"bin": "0", "bout": "0", "dreq": "0", "dresp": "0", "ereq": "0", "status": "OPEN", "pid": "1", "iid": strconv.Itoa(iid),
+			"sid": "0", "type": "0", "rate": "0", "rate_lim": "0", "rate_max": "0", "req_tot": "0", "mode": "http"})
+	}
+	for _, be := range s.backends {
+		up := 0
+		for _, sv := range be.servers {
+			status := serverStatus(sv)
+			if status == "UP" {
+				up++
+			}
+			row(map[string]string{"pxname": be.name, "svname": sv.name, "qcur": "0", "qmax": "0", "scur": "0", "smax": "0",
+				"stot": "0", "bin": "0", "bout": "0", "dresp": "0", "econ": "0", "eresp": "0", "wretr": "0", "wredis": "0",
+				"status": status, "weight": strconv.Itoa(sv.weight), "act": "1", "bck": "0", "chkfail": "0", "chkdown": "0",
+				"lastchg": "10", "downtime": "0", "pid": "1", "iid": strconv.Itoa(be.id), "sid": strconv.Itoa(sv.id), "lbtot": "0",
+				"type": "2", "rate": "0", "rate_max": "0", "check_status": "L4OK", "check_duration": "0", "cli_abrt": "0", "srv_abrt": "0",
+				"addr": fmt.Sprintf("%s:%d", sv.addr, sv.port), "mode": "http", "uweight": strconv.Itoa(sv.weight)})
+		}
+		status := "UP"
+		if up == 0 && len(be.servers) > 0 {
+			status = "DOWN"
+		}
+		row(map[string]string{"pxname": be.name, "svname": "BACKEND", "qcur": "0", "qmax": "0", "scur": "0", "smax": "0",
+			"slim": "200", "stot": "0", "bin": "0", "bout": "0", "dreq": "0", "dresp": "0", "econ": "0", "eresp": "0", "wretr": "0",
+			"wredis": "0", "status": status, "weight": strconv.Itoa(up), "act": strconv.Itoa(up), "bck": "0", "chkdown": "0",
+			"lastchg": "10", "downtime": "0", "pid": "1", "iid": strconv.Itoa(be.id), "sid": "0", "lbtot": "0", "type": "1",
+			"rate": "0", "rate_max": "0", "req_tot": "0", "cli_abrt": "0", "srv_abrt": "0", "mode": "http", "algo": "roundrobin"})
+	}
+	return b.String()
+}
+
+func serverStatus(sv *server) string {
+	switch {
+	case sv.adminState&srvAdminMaint != 0:
+		return "MAINT"
+	case sv.opState != srvOpRunning:
+		return "DOWN"
+	case sv.adminState&srvAdminDrain != 0:
+		return "DRAIN"
+	}
+	return "UP"
+}
+
+func (s *Server) showInfo() string {
+	var b strings.Builder
+	for _, kv := range s.info {
+		fmt.Fprintf(&b, "%s: %s\n", kv[0], kv[1])
+	}
+	return b.String()
+}
+
+func (s *Server) showServersState(args []string) string {
+	var b strings.Builder
+	b.WriteString("1\n# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port srvrecord srv_use_ssl srv_check_port srv_check_addr srv_agent_addr srv_agent_port\n")
+	found := len(args) == 0
+	for _, be := range s.backends {
+		if len(args) > 0 && args[0] != be.name {
+			continue
+		}
+		found = true
+		for _, sv := range be.servers {
+			fmt.Fprintf(&b, "%d %s %d %s %s %d %d %d %d 10 6 3 4 6 0 0 0 - %d - 0 0 - - 0\n",
+				be.id, be.name, sv.id, sv.name, sv.addr, sv.opState, sv.adminState, sv.weight, sv.iweight, sv.port)
+		}
+	}
+	if !found {
+		return "Can't find backend.\n"
+	}
+	return b.String()
+}
+
+func (s *Server) cmdServer(args []string) string {
+	sv := s.findServer(args[2])
+	if sv == nil {
+		return "No such server.\n"
+	}
+	switch args[0] {
+	case "enable":
+		sv.adminState &^= srvAdminMaint
+		return ""
+	case "disable":
+		sv.adminState |= srvAdminMaint
+		return ""
+	}
+	if len(args) < 5 {
+		return "'set server <srv>' only supports 'agent', 'health', 'state', 'weight', 'addr', 'fqdn' and 'check-addr'.\n"
+	}
+	switch args[3] {
+	case "state":
+		switch args[4] {
+		case "ready":
+			sv.adminState = 0
+		case "drain":
+			sv.adminState = srvAdminDrain
+		case "maint":
+			sv.adminState = srvAdminMaint
+		default:
+			return "'set server <srv> state' expects 'ready', 'drain' and 'maint'.\n"
+		}
+		return ""
+	case "health":
+		switch args[4] {
+		case "up":
+			sv.opState = srvOpRunning
+		case "down", "stopping":
+			sv.opState = srvOpStopped
+		default:
+			return "'set server <srv> health' expects 'up', 'stopping', or 'down'.\n"
+		}
+		return ""
+	case "weight":
+		w := strings.TrimSuffix(args[4], "%")
+		v, err := strconv.Atoi(w)
+		if err != nil || v < 0 || v > 256 {
+			return "Absolute weight can only be between 0 and 256 inclusive.\n"
+		}
+		if strings.HasSuffix(args[4], "%") {
+			v = sv.iweight * v / 100
+		}
+		sv.weight = v
+		return ""
+	case "addr":
+		if net.ParseIP(args[4]) == nil {
+			return "Invalid addr.\n"
+		}
+		out := fmt.Sprintf("IP changed from '%s' to '%s'", sv.addr, args[4])
+		sv.addr = args[4]
+		if len(args) >= 7 && args[5] == "port" {
+			port, err := strconv.Atoi(args[6])
+			if err != nil {
+				return "Invalid port.\n"
+			}
+			out += fmt.Sprintf(", port changed from '%d' to '%d'", sv.port, port)
+			sv.port = port
+		} else {
+			out += ", no need to change the port"
+		}
+		return out + " by 'stats socket command'\n"
+	}
+	return "'set server <srv>' only supports 'agent', 'health', 'state', 'weight', 'addr', 'fqdn' and 'check-addr'.\n"
+}
+
+func (s *Server) cmdTable(args []string) string {
+	if args[0] == "show" && len(args) == 2 {
+		var out string
+		for _, t := range s.tables {
+			out += fmt.Sprintf("# table: %s, type: %s, size:%d, used:%d\n", t.name, t.typ, t.size, len(t.entries))
+		}
+		return out
+	}
+	if len(args) < 3 {
+		return "Optional table name expected\n"
+	}
+	t := s.findTable(args[2])
+	if t == nil {
+		return "No such table\n"
+	}
+	var key string
+	if len(args) >= 5 && args[3] == "key" {
+		key = args[4]
+	}
+	switch args[0] {
+	case "show":
+		out := fmt.Sprintf("# table: %s, type: %s, size:%d, used:%d\n", t.name, t.typ, t.size, len(t.entries))
+		for _, e := range t.entries {
+			if key != "" && e.key != key {
+				continue
+			}
+			out += fmt.Sprintf("0x%x: key=%s use=0 exp=0 shard=0", e.ptr, e.key)
+			for _, d := range e.data {
+				out += fmt.Sprintf(" %s=%s", d[0], d[1])
+			}
+			out += "\n"
+		}
+		return out
+	case "clear":
+		if key == "" {
+			t.entries = nil
+			return ""
+		}
+		var kept []*tableEntry
+		for _, e := range t.entries {
+			if e.key != key {
+				kept = append(kept, e)
+			}
+		}
+		t.entries = kept
+		return ""
+	case "set":
+		if key == "" {
+			return "table key expected\n"
+		}
+		e := t.entry(key, s)
+		rest := args[5:]
+		for i := 0; i+1 < len(rest); i += 2 {
+			if !strings.HasPrefix(rest[i], "data.") {
+				return "\"data.<type>\" followed by a value expected\n"
+			}
+			e.set(strings.TrimPrefix(rest[i], "data."), rest[i+1])
+		}
+		return ""
+	}
+	return fmt.Sprintf("Unknown command: '%s'\n", args[0])
+}
+
+func (s *Server) showEnv(args []string) string {
+	var b strings.Builder
+	for _, kv := range s.env {
+		if len(args) > 0 && kv[0] != args[0] {
+			continue
+		}
+		fmt.Fprintf(&b, "%s=%s\n", kv[0], kv[1])
+	}
+	if len(args) > 0 && b.Len() == 0 {
+		return "Variable not found\n"
+	}
+	return b.String()
+}
+
+func (s *Server) cmdVar(args []string) string {
+	if len(args) < 3 || !strings.HasPrefix(args[2], "proc.") {
+		return "Variable name must be in the 'proc.' scope\n"
+	}
+	switch args[0] {
+	case "get":
+		v, ok := s.vars[args[2]]
+		if !ok {
+			return "Variable not found\n"
+		}
+		return fmt.Sprintf("%s: type=%s value=<%s>\n", args[2], v.typ, v.value)
+	case "set":
+		if len(args) < 4 {
+			return "Missing an expression.\n"
+		}
+		expr := strings.Join(args[3:], " ")
+		fn, arg := expr, ""
+		if i := strings.Index(expr, "("); i > 0 && strings.HasSuffix(expr, ")") {
+			fn, arg = expr[:i], expr[i+1:len(expr)-1]
+		}
+		switch fn {
+		case "int":
+			if _, err := strconv.ParseInt(arg, 10, 64); err != nil {
+				return "Failed to evaluate the expression.\n"
+			}
+			s.vars[args[2]] = procVar{typ: "sint", value: arg}
+		case "str":
+			s.vars[args[2]] = procVar{typ: "str", value: arg}
+		case "bool":
+			v := "0"
+			if arg != "" && arg != "0" {
+				v = "1"
+			}
+			s.vars[args[2]] = procVar{typ: "bool", value: v}
+		default:
+			return fmt.Sprintf("Failed to parse the expression: unknown fetch method '%s'.\n", fn)
+		}
+		return ""
+	}
+	return fmt.Sprintf("Unknown command: '%s'\n", args[0])
+}
+
+func (s *Server) addRef(kind, file, desc string, keys []string, values []string) int {
+	r := &patRef{id: s.nextID, kind: kind, file: file, desc: desc, cfgFile: configFile, cfgLine: 10 + s.nextID}
+	s.nextID++
+	for i, k := range keys {
+		e := patEntry{ptr: s.ptr(), key: k}
+		if values != nil {
+			e.value = values[i]
+		}
+		r.entries = append(r.entries, e)
+	}
+	s.refs = append(s.refs, r)
+	return r.id
+}
+
+func (s *Server) findRef(kind, ref string) *patRef {
+	for _, r := range s.refs {
+		if r.kind != kind {
+			continue
+		}
+		if strings.HasPrefix(ref, "#") {
+			if strconv.Itoa(r.id) == ref[1:] {
+				return r
+			}
+		} else if r.file != "" && r.file == ref {
+			return r
+		}
+	}
+	return nil
+}
+
+func (s *Server) findServer(name string) *server {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	for _, b := range s.backends {
+		if b.name != parts[0] {
+			continue
+		}
+		for _, sv := range b.servers {
+			if sv.name == parts[1] {
+				return sv
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) findTable(name string) *table {
+	for _, t := range s.tables {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *Server) ptr() uint64 {
+	s.nextPtr += 0x40
+	return s.nextPtr
+}
+
+func (t *table) entry(key string, s *Server) *tableEntry {
+	for _, e := range t.entries {
+		if e.key == key {
+			return e
+		}
+	}
+	e := &tableEntry{ptr: s.ptr(), key: key}
+	t.entries = append(t.entries, e)
+	return e
+}
+
+func (e *tableEntry) set(name, value string) {
+	for i := range e.data {
+		if e.data[i][0] == name {
+			e.data[i][1] = value
+			return
+		}
+	}
+	e.data = append(e.data, [2]string{name, value})
+	sort.SliceStable(e.data, func(i, j int) bool { return e.data[i][0] < e.data[j][0] })
+}
+
+func setKV(kv [][2]string, name, value string) [][2]string {
+	for i := range kv {
+		if kv[i][0] == name {
+			kv[i][1] = value
+			return kv
+		}
+	}
+	return append(kv, [2]string{name, value})
+}
+
+// split line into commands on unescaped ';'
+func splitCommands(line string) []string {
+	var cmds []string
+	var cur strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+		case line[i] == ';':
+			cmds = append(cmds, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteByte(line[i])
+		}
+	}
+	if c := strings.TrimSpace(cur.String()); c != "" || len(cmds) == 0 {
+		cmds = append(cmds, c)
+	}
+	return cmds
+}
@@ -0,0 +1,271 @@
+package haproxytest_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/efigence/go-haproxy/haproxytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerACL(t *testing.T) {
+	srv := haproxytest.NewServer()
+	defer srv.Close()
+	fileID := srv.AddACLFile("t-data/blacklist.lst", "/from/file")
+	inlineID := srv.AddInlineACL("path_beg", 18, "/admin")
+	c := haproxy.New(srv.SocketPath)
+
+	acls, err := c.ListACL()
+	require.NoError(t, err)
+	require.Len(t, acls, 2)
+	assert.Equal(t, fileID, acls[0].ID)
+	assert.Equal(t, "file", acls[0].Type)
+	assert.Equal(t, "t-data/blacklist.lst", acls[0].SourceFile)
+	assert.Equal(t, inlineID, acls[1].ID)
+	assert.Equal(t, "path_beg", acls[1].Type)
+	assert.Equal(t, 18, acls[1].Line)
+
+	entries, err := c.GetACL("t-data/blacklist.lst")
+	require.NoError(t, err)
+	assert.NotEmpty(t, entries["/from/file"])
+
+	require.NoError(t, c.AddACL("t-data/blacklist.lst", "/bad/test1"))
+	require.NoError(t, c.AddACL("#1", "/bad/test2"))
+	assert.Equal(t, []string{"/from/file", "/bad/test1"}, srv.ACL("t-data/blacklist.lst"))
+	assert.Equal(t, []string{"/admin", "/bad/test2"}, srv.ACL("#1"))
+	assert.Error(t, c.AddACL("nonexistent", "/x"))
+
+	require.NoError(t, c.DeleteACL("t-data/blacklist.lst", "/bad/test1"))
+	assert.Error(t, c.DeleteACL("t-data/blacklist.lst", "/bad/test1"))
+	require.NoError(t, c.ClearACL("#1"))
+	assert.Empty(t, srv.ACL("#1"))
+	assert.Error(t, c.ClearACL("1"))
+}
+
+func TestServerMap(t *testing.T) {
+	srv := haproxytest.NewServer()
+	defer srv.Close()
+	id := srv.AddMap("/etc/haproxy/hosts.map", "example.com", "app", "static.example.com", "static")
+	c := haproxy.New(srv.SocketPath)
+
+	out, err := c.RunCmd("show map")
+	require.NoError(t, err)
+	assert.Equal(t, "# id (file) description", out[0])
+	assert.Contains(t, out[1], "0 (/etc/haproxy/hosts.map) pattern loaded from file '/etc/haproxy/hosts.map' used by map")
+	assert.Equal(t, 0, id)
+
+	out, err = c.RunCmd("show map #0")
+	require.NoError(t, err)
+	assert.Regexp(t, `^0x[0-9a-f]+ example.com app$`, out[0])
+
+	_, err = c.RunCmd("set map #0 example.com app2")
+	require.NoError(t, err)
+	_, err = c.RunCmd("add map /etc/haproxy/hosts.map new.example.com app3")
+	require.NoError(t, err)
+	_, err = c.RunCmd("del map #0 static.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"example.com": "app2", "new.example.com": "app3"}, srv.Map("#0"))
+
+	out, err = c.RunCmd("get map #0 example.com")
+	require.NoError(t, err)
+	assert.Contains(t, out[0], `found=yes`)
+	assert.Contains(t, out[0], `value="app2"`)
+	out, _ = c.RunCmd("del map #0 nope")
+	assert.Equal(t, "Key not found.", out[0])
+}
+
+func TestServerServersAndStats(t *testing.T) {
+	srv := haproxytest.NewServer()
+	defer srv.Close()
+	srv.AddFrontend("fe_http")
+	srv.AddBackend("app", "app1=10.0.0.1:8080", "app2")
+	srv.SetStat("app", "app1", "scur", "5")
+	c := haproxy.New(srv.SocketPath)
+
+	out, err := c.RunCmd("show stat")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out[0], "# pxname,svname,qcur,qmax,scur,"))
+	header := strings.Split(strings.TrimPrefix(out[0], "# "), ",")
+	rows := map[string][]string{}
+	for _, line := range out[1:] {
+		if line == "" {
+			continue
+		}
+		f := strings.Split(line, ",")
+		require.Len(t, f, len(header))
+		rows[f[0]+"/"+f[1]] = f
+	}
+	assert.Equal(t, "OPEN", rows["fe_http/FRONTEND"][17])
+	assert.Equal(t, "UP", rows["app/app1"][17])
+	assert.Equal(t, "5", rows["app/app1"][4])
+	assert.Equal(t, "UP", rows["app/BACKEND"][17])
+
+	_, err = c.RunCmd("set server app/app1 state maint")
+	require.NoError(t, err)
+	srv.SetServerUp("app", "app2", false)
+	out, _ = c.RunCmd("show stat")
+	assert.Contains(t, out[1+1], ",MAINT,")
+	assert.Contains(t, out[3], ",DOWN,")
+
+	out, err = c.RunCmd("show servers state app")
+	require.NoError(t, err)
+	assert.Equal(t, "1", out[0])
+	assert.Regexp(t, `^1 app 1 app1 10\.0\.0\.1 2 1 1 1 `, out[2])
+
+	out, _ = c.RunCmd("set server app/app2 addr 10.0.0.2 port 9090")
+	assert.Equal(t, "IP changed from '127.0.0.1' to '10.0.0.2', port changed from '8081' to '9090' by 'stats socket command'", out[0])
+	c.RunCmd("set server app/app2 weight 50")
+	out, _ = c.RunCmd("get weight app/app2")
+	assert.Equal(t, "50 (initial 1)", out[0])
+	out, _ = c.RunCmd("get weight app/nope")
+	assert.Equal(t, "No such server.", out[0])
+
+	srv.SetInfo("Version", "2.4.0")
+	out, _ = c.RunCmd("show info")
+	assert.Contains(t, out, "Version: 2.4.0")
+	assert.Contains(t, out, "Name: HAProxy")
+}
+
+func TestServerTables(t *testing.T) {
+	srv := haproxytest.NewServer()
+	defer srv.Close()
+	srv.AddTable("per_ip", "ip", 204800)
+	srv.SetTableEntry("per_ip", "127.0.0.1", "gpc0", "1", "conn_rate(10000)", "2")
+	c := haproxy.New(srv.SocketPath)
+
+	out, _ := c.RunCmd("show table")
+	assert.Equal(t, "# table: per_ip, type: ip, size:204800, used:1", out[0])
+	_, err := c.RunCmd("set table per_ip key 10.0.0.1 data.gpc0 5")
+	require.NoError(t, err)
+	out, _ = c.RunCmd("show table per_ip")
+	assert.Equal(t, "# table: per_ip, type: ip, size:204800, used:2", out[0])
+	assert.Regexp(t, `^0x[0-9a-f]+: key=127.0.0.1 use=0 exp=0 shard=0 conn_rate\(10000\)=2 gpc0=1$`, out[1])
+	assert.Regexp(t, `key=10.0.0.1 .* gpc0=5$`, out[2])
+	c.RunCmd("clear table per_ip key 127.0.0.1")
+	out, _ = c.RunCmd("show table per_ip")
+	assert.Equal(t, "# table: per_ip, type: ip, size:204800, used:1", out[0])
+	out, _ = c.RunCmd("show table nope")
+	assert.Equal(t, "No such table", out[0])
+}
+
+func TestServerVars(t *testing.T) {
+	srv := haproxytest.NewServer()
+	defer srv.Close()
+	srv.SetEnv("HOME", "/var/lib/haproxy")
+	c := haproxy.New(srv.SocketPath)
+
+	env, err := c.ShowEnv()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"HOME": "/var/lib/haproxy"}, env)
+	require.NoError(t, c.SetVarInt("proc.feature", 3))
+	v, err := c.GetVar("proc.feature")
+	require.NoError(t, err)
+	assert.Equal(t, haproxy.Var{Name: "proc.feature", Type: "sint", Value: "3"}, v)
+	_, err = c.GetVar("proc.nope")
+	assert.Error(t, err)
+	assert.Error(t, c.SetVar("proc.bad", "nonsense(1)"))
+}
+
+func TestServerSeverityOutput(t *testing.T) {
+	srv := haproxytest.NewServer()
+	defer srv.Close()
+	ref := fmt.Sprintf("#%d", srv.AddInlineACL("src", 12, "127.0.0.1"))
+	c := haproxy.New(srv.SocketPath)
+	require.NoError(t, c.SetVarInt("proc.feature", 3))
+
+	// fake prefixes messages like haproxy does
+	conn, err := net.Dial("unix", srv.SocketPath)
+	require.NoError(t, err)
+	fmt.Fprintf(conn, "set severity-output number; get var proc.nope; show acl\n")
+	raw, err := io.ReadAll(conn)
+	conn.Close()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "\n[3]: Variable not found\n\n# id (file) description\n"), string(raw))
+
+	for _, mode := range []string{haproxy.SeverityOutputNumber, haproxy.SeverityOutputString} {
+		t.Run(mode, func(t *testing.T) {
+			require.NoError(t, c.SetSeverityOutput(mode))
+			defer c.SetSeverityOutput(haproxy.SeverityOutputNone)
+			v, err := c.GetVar("proc.feature")
+			require.NoError(t, err)
+			assert.Equal(t, "3", v.Value)
+			_, err = c.GetVar("proc.nope")
+			assert.ErrorContains(t, err, "error: Variable not found")
+			assert.EqualError(t, c.AddACL("#99", "10.0.0.1"), "error: Unknown ACL identifier. Please use #<id> or <file>.")
+			require.NoError(t, c.AddACL(ref, "10.0.0.1"))
+			assert.Equal(t, []string{"127.0.0.1", "10.0.0.1"}, srv.ACL(ref))
+			require.NoError(t, c.DeleteACL(ref, "10.0.0.1"))
+
+			out, severity, err := c.RunCmdSeverity("get var proc.nope")
+			require.NoError(t, err)
+			assert.Equal(t, 3, severity)
+			assert.Equal(t, "Variable not found", out[0])
+			out, severity, err = c.RunCmdSeverity("show acl")
+			require.NoError(t, err)
+			assert.Equal(t, -1, severity)
+			assert.Equal(t, "# id (file) description", out[0])
+			// bare `get` with single-line response is a message too
+			srv.InjectFault("get", haproxytest.Fault{Response: "Permission denied\n", Times: 1})
+			out, severity, err = c.RunCmdSeverity("get")
+			require.NoError(t, err)
+			assert.Equal(t, 3, severity)
+			assert.Equal(t, "Permission denied", out[0])
+		})
+	}
+
+	// failing session setting is an error instead of part of the output
+	require.NoError(t, c.SetSeverityOutput(haproxy.SeverityOutputNumber))
+	require.NoError(t, c.SetCLITimeout(time.Second))
+	srv.InjectFault("set timeout", haproxytest.Fault{Response: "Permission denied\n", Times: 1})
+	_, err = c.ShowEnv()
+	assert.EqualError(t, err, "error applying session settings: Permission denied")
+	_, err = c.ShowEnv()
+	assert.NoError(t, err)
+}
+
+func TestServerProgrammable(t *testing.T) {
+	srv := haproxytest.NewServer()
+	defer srv.Close()
+	c := haproxy.New(srv.SocketPath)
+
+	out, _ := c.RunCmd("show nothing")
+	assert.Contains(t, out[0], "Unknown command")
+
+	srv.SetResponse("show pools", "  - Pool vars (16 bytes) : 3 allocated (48 bytes), 3 used, needed_avg 2, 0 failures, 1 users, @0x55c1ed5e1ac0=00 [SHARED]\n")
+	pools, err := c.ShowPools()
+	require.NoError(t, err)
+	require.Len(t, pools, 1)
+	assert.Equal(t, "vars", pools[0].Name)
+
+	srv.Handle("show env", func(args []string) string {
+		return "CMD=" + strings.Join(args, "_") + "\n"
+	})
+	env, err := c.ShowEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "show_env", env["CMD"])
+
+	srv.InjectFault("show env", haproxytest.Fault{Response: "Permission denied\n", Times: 1})
+	_, err = c.ShowEnv()
+	assert.Error(t, err)
+	_, err = c.ShowEnv()
+	assert.NoError(t, err)
+
+	srv.InjectFault("", haproxytest.Fault{Drop: true})
+	out, err = c.RunCmd("show info")
+	assert.NoError(t, err)
+	assert.Empty(t, out)
+	srv.ClearFaults()
+
+	srv.InjectFault("show info", haproxytest.Fault{Delay: 50 * time.Millisecond})
+	start := time.Now()
+	c.RunCmd("show info")
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	assert.Equal(t, "show nothing", srv.Commands()[0])
+}
@@ -2,7 +2,11 @@ package haproxy
 
 import (
 	"bufio"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"testing"
 )
 
 // test helpers
@@ -21,3 +25,28 @@ func readLines(path string) ([]string, error) {
 	}
 	return lines, scanner.Err()
 }
+
+// serveTestSocket starts unix socket server that calls handler with first line sent by the client
+// and closes the connection after handler returns
+func serveTestSocket(t *testing.T, handler func(cmd string, conn net.Conn)) string {
+	path := filepath.Join(t.TempDir(), "haproxy.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("can't listen on %s: %s", path, err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				cmd, _ := bufio.NewReader(conn).ReadString('\n')
+				handler(strings.TrimRight(cmd, "\n"), conn)
+			}()
+		}
+	}()
+	return path
+}
@@ -0,0 +1,136 @@
+package haproxy
+
+import (
+	"math"
+	"math/bits"
+)
+
+// histogramSubBits is log2 of number of sub-buckets per power of two, 5 gives under 3.2% relative error
+const histogramSubBits = 5
+
+// Histogram is mergeable log-linear histogram (HDR-style) of non-negative integers like durations in ms.
+// Values below 32 are exact, above that each power of two is split into 32 buckets.
+// Negative values (haproxy logs -1 for timers that didn't happen) are only counted.
+// It is plain data, so it can be serialized, shipped and merged with histograms from other instances
+type Histogram struct {
+	Counts   []uint64 `json:"counts"`
+	Negative uint64   `json:"negative,omitempty"`
+	Total    uint64   `json:"total"`
+	Sum      int64    `json:"sum"`
+	Min      int64    `json:"min"`
+	Max      int64    `json:"max"`
+}
+
+func histogramIndex(v int64) int {
+	if v < 1<<histogramSubBits {
+		return int(v)
+	}
+	exp := bits.Len64(uint64(v)) - 1
+	mantissa := int(v>>(exp-histogramSubBits)) - 1<<histogramSubBits
+	return (exp-histogramSubBits+1)<<histogramSubBits + mantissa
+}
+
+// histogramLowest returns lowest value that falls into bucket i
+func histogramLowest(i int) int64 {
+	if i < 1<<histogramSubBits {
+		return int64(i)
+	}
+	exp := i>>histogramSubBits + histogramSubBits - 1
+	mantissa := int64(i&(1<<histogramSubBits-1)) + 1<<histogramSubBits
+	return mantissa << (exp - histogramSubBits)
+}
+
+// Record adds value to histogram
+func (h *Histogram) Record(v int64) {
+	if v < 0 {
+		h.Negative++
+		return
+	}
+	i := histogramIndex(v)
+	if i >= len(h.Counts) {
+		counts := make([]uint64, i+1)
+		copy(counts, h.Counts)
+		h.Counts = counts
+	}
+	h.Counts[i]++
+	if h.Total == 0 || v < h.Min {
+		h.Min = v
+	}
+	if v > h.Max {
+		h.Max = v
+	}
+	h.Total++
+	h.Sum += v
+}
+
+// Merge adds all values from other histogram
+func (h *Histogram) Merge(o *Histogram) {
+	if o == nil {
+		return
+	}
+	if len(o.Counts) > len(h.Counts) {
+		counts := make([]uint64, len(o.Counts))
+		copy(counts, h.Counts)
+		h.Counts = counts
+	}
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	if o.Total > 0 {
+		if h.Total == 0 || o.Min < h.Min {
+			h.Min = o.Min
+		}
+		if o.Max > h.Max {
+			h.Max = o.Max
+		}
+	}
+	h.Negative += o.Negative
+	h.Total += o.Total
+	h.Sum += o.Sum
+}
+
+// Quantile returns approximate value at quantile q (0-1), like 0.99 for p99. Returns -1 if there are no values
+func (h *Histogram) Quantile(q float64) int64 {
+	if h.Total == 0 {
+		return -1
+	}
+	switch {
+	case q <= 0:
+		return h.Min
+	case q >= 1:
+		return h.Max
+	}
+	rank := uint64(math.Ceil(q * float64(h.Total)))
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank {
+			// middle of the bucket, clamped to what was actually seen
+			lo, hi := histogramLowest(i), histogramLowest(i+1)-1
+			v := lo + (hi-lo)/2
+			if v < h.Min {
+				v = h.Min
+			}
+			if v > h.Max {
+				v = h.Max
+			}
+			return v
+		}
+	}
+	return h.Max
+}
+
+// Mean returns average of recorded values, 0 if there are none
+func (h *Histogram) Mean() float64 {
+	if h.Total == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.Total)
+}
+
+// Clone returns deep copy
+func (h *Histogram) Clone() *Histogram {
+	c := *h
+	c.Counts = append([]uint64(nil), h.Counts...)
+	return &c
+}
@@ -0,0 +1,68 @@
+package haproxy
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramIndex(t *testing.T) {
+	prev := -1
+	for v := int64(0); v < 1<<16; v++ {
+		i := histogramIndex(v)
+		assert.GreaterOrEqual(t, v, histogramLowest(i))
+		assert.Less(t, v, histogramLowest(i+1))
+		if i != prev {
+			assert.Equal(t, prev+1, i)
+			prev = i
+		}
+	}
+	for _, v := range []int64{1 << 30, 1<<40 + 12345, 1<<62 - 1} {
+		i := histogramIndex(v)
+		assert.GreaterOrEqual(t, v, histogramLowest(i))
+		assert.Less(t, v, histogramLowest(i+1))
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	var h Histogram
+	assert.EqualValues(t, -1, h.Quantile(0.5))
+	rnd := rand.New(rand.NewSource(1))
+	var values []int64
+	for i := 0; i < 10000; i++ {
+		v := int64(rnd.ExpFloat64() * 200)
+		values = append(values, v)
+		h.Record(v)
+	}
+	h.Record(-1)
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99, 0.999} {
+		exact := values[int(q*float64(len(values)))-1]
+		assert.InEpsilon(t, float64(exact), float64(h.Quantile(q)), 0.04, "q=%v", q)
+	}
+	assert.Equal(t, values[0], h.Quantile(0))
+	assert.Equal(t, values[len(values)-1], h.Quantile(1))
+	assert.EqualValues(t, 10000, h.Total)
+	assert.EqualValues(t, 1, h.Negative)
+
+	// merging halves gives the same as recording everything
+	var a, b Histogram
+	for i, v := range values {
+		if i%2 == 0 {
+			a.Record(v)
+		} else {
+			b.Record(v)
+		}
+	}
+	a.Merge(&b)
+	a.Record(-1)
+	assert.Equal(t, h.Total, a.Total)
+	assert.Equal(t, h.Sum, a.Sum)
+	assert.Equal(t, h.Quantile(0.99), a.Quantile(0.99))
+	assert.InDelta(t, h.Mean(), a.Mean(), 0.001)
+	c := a.Clone()
+	c.Record(5)
+	assert.NotEqual(t, c.Total, a.Total)
+}
@@ -15,14 +15,60 @@ const haproxyTimeFormat = "02/Jan/2006:15:04:05.000"
 // defaults to local as haproxy by default uses localtime in logs
 var HaproxyLogTimezone = time.Local
 
+// HTTPLogVersion is layout of default `option httplog` format.
+// Both layouts have the same shape so it can't be guessed from the line itself, it has to be picked by the caller.
+// Empty version is HTTPLogV15
+type HTTPLogVersion string
+
+const (
+	// HAProxy 1.5/1.6: [accept date] Tq/Tw/Tc/Tr/Tt
+	HTTPLogV15 HTTPLogVersion = "1.5"
+	// HAProxy 1.7+: [request date] TR/Tw/Tc/Tr/Ta
+	HTTPLogV17 HTTPLogVersion = "1.7"
+)
+
+// ParseHTTPLogVersion checks version is one of HTTPLogV15 and HTTPLogV17
+func ParseHTTPLogVersion(s string) (HTTPLogVersion, error) {
+	v := HTTPLogVersion(s)
+	if err := v.check(); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+func (v HTTPLogVersion) check() error {
+	switch v {
+	case "", HTTPLogV15, HTTPLogV17:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("unknown httplog version [%s], use %s or %s", v, HTTPLogV15, HTTPLogV17))
+}
+
+// layout returns version lines are decoded as, empty one is 1.5
+func (v HTTPLogVersion) layout() HTTPLogVersion {
+	if v == "" {
+		return HTTPLogV15
+	}
+	return v
+}
+
+// HTTPLogDecoder decodes httplog lines with settings that can't be guessed from the line itself.
+// Zero value decodes 1.5 layout and leaves captures undecoded
+type HTTPLogDecoder struct {
+	Version HTTPLogVersion
+	// if set, RequestHeaders and ResponseHeaders are filled from CapturedSamples, mismatch goes to CaptureError
+	Captures CaptureLayout
+}
+
 // Haproxy log line regexp (shitty go fmt doesnt allow for breaking line ;/)
 var haproxyRegex = regexp.MustCompile(
-	`.*haproxy\[(\d+)]: (.+?):(\d+) \[(.+?)\] (.+?)(|[\~]) (.+?)\/(.+?) ([\-\d]+)\/([\-\d]+)\/([\-\d]+)\/([\-\d]+)\/([\-\d]+) ([\-\d]+) ([\-\d]+) (\S+) (\S+) (\S{4}) ([\-\d]+)\/([\-\d]+)\/([\-\d]+)\/([\-\d]+)\/([\-\d]+) ([\-\d]+)\/([\-\d]+)(| \{.*\}) (".*)([\n|\s]*?)$`)
+	`^(.+?):(\d+) \[(.+?)\] (.+?)(|[\~]) (.+?)\/(.+?) ([\-\d]+)\/([\-\d]+)\/([\-\d]+)\/([\-\d]+)\/([\-\d]+) ([\-\d]+) ([\-\d]+) (\S+) (\S+) (\S{4}) ([\-\d]+)\/([\-\d]+)\/([\-\d]+)\/([\-\d]+)\/([\-\d]+) ([\-\d]+)\/([\-\d]+)(| \{.*\}) (".*)([\n|\s]*?)$`)
 var reqPathRegex = regexp.MustCompile(`"(\S+) (\S+) (\S+)"`)
 var reqTooLongPathRegex = regexp.MustCompile(`"(\S+) (\S+)`)
 
 // HAProxy http log format
 // https://cbonte.github.io/haproxy-dconv/configuration-1.5.html#8.2.3
+// https://cbonte.github.io/haproxy-dconv/2.8/configuration.html#8.2.3
 type HTTPRequest struct {
 	TS                     int64  `json:"ts_us"`
 	PID                    int    `json:"pid"` // necessary to distinguish conns hitting different processes
@@ -39,13 +85,26 @@ type HTTPRequest struct {
 	// It is not possible to distinguish between "just captured request headers" and "just captured response headers"
 	// so we let user sort it out
 	CapturedSamples string `json:"captured_samples"`
+	// CapturedSamples decoded according to decoder's CaptureLayout, nil if it is not set or captures didn't match it
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	// why CapturedSamples didn't match CaptureLayout, the rest of the line is still decoded
+	CaptureError string `json:"capture_error,omitempty"`
 	// timings
-	// aborted connections are marked via -1 by haproxy
+	// aborted connections are marked via -1 by haproxy.
+	// Tq and Tt are not in 1.7+ httplog, they stay 0 when LogVersion is HTTPLogV17 and can't be told from real zeros
 	RequestHeaderDurationMs  int `json:"request_header_duration_ms"`  // Tq
 	QueueDurationMs          int `json:"queue_duration_ms"`           // Tw
 	ServerConnDurationMs     int `json:"server_conn_duration_ms"`     // Tc
 	ResponseHeaderDurationMs int `json:"response_header_duration_ms"` // Tr
 	TotalDurationMs          int `json:"total_duration_ms"`           // Tt
+	// 1.7+ timers, Ti is only available via custom log formats. 1.7+ httplog has TR and Ta in place of Tq and Tt,
+	// use RequestTimeMs and TotalTimeMs to get whichever is there
+	RequestReceiveDurationMs int `json:"request_receive_duration_ms"` // TR
+	ActiveDurationMs         int `json:"active_duration_ms"`          // Ta
+	IdleDurationMs           int `json:"idle_duration_ms"`            // Ti
+	// httplog layout the line was decoded with, as picked by the caller (it is not detected)
+	LogVersion HTTPLogVersion `json:"log_version,omitempty"`
 
 	RequestPath   string `json:"http_path"`
 	RequestMethod string `json:"http_method"`
@@ -66,79 +125,132 @@ type HTTPRequest struct {
 	ServerQueue  uint `json:"server_queue"`
 	BackendQueue uint `json:"backend_queue"`
 
+	// unique request ID (%ID), only available via custom log formats
+	UniqueID string `json:"unique_id,omitempty"`
+
 	// flags
 	BadReq    bool `json:"bad_request"`
 	Truncated bool `json:"truncated"`
+
+	// syslog envelope, PID is copied from it
+	Syslog SyslogHeader `json:"syslog"`
+
+	// fields from custom log formats that do not map to any of the above, by log-format variable name
+	Extra map[string]string `json:"extra,omitempty"`
 }
 
-// Decode haproxy UDP sender string into http request
+// Decode haproxy UDP sender string into http request.
+// Line is decoded as 1.5 layout (Tq/Tw/Tc/Tr/Tt). 1.7+ lines (TR/Tw/Tc/Tr/Ta) look exactly the same, so they can't be
+// recognised and would come out with TR in Tq and Ta in Tt; use HTTPLogV17.Decode for haproxy 1.7 and newer
 
 func DecodeHTTPLog(s string) (HTTPRequest, error) {
+	return HTTPLogV15.Decode(s)
+}
+
+// Decode decodes httplog line of this layout, LogVersion of the result says which one it was
+func (v HTTPLogVersion) Decode(s string) (HTTPRequest, error) {
+	return HTTPLogDecoder{Version: v}.Decode(s)
+}
+
+// Decode decodes httplog line, LogVersion of the result says which layout it was
+func (d HTTPLogDecoder) Decode(s string) (HTTPRequest, error) {
+	v := d.Version
 	var r HTTPRequest
 	var err error
 	var parse_err []error
+	if err := v.check(); err != nil {
+		return r, err
+	}
+	r.Syslog, s, err = DecodeSyslog(s)
+	if err != nil {
+		return r, err
+	}
+	r.PID = r.Syslog.PID
 	matches := haproxyRegex.FindStringSubmatch(s)
 	if len(matches) < 8 {
 		return r, errors.New("input not matching regex")
 	}
-	r.PID, err = strconv.Atoi(matches[1])
-	r.ClientIP = matches[2]
+	r.ClientIP = matches[1]
 
-	ui16_cp, err := strconv.ParseUint(matches[3], 10, 16)
+	ui16_cp, err := strconv.ParseUint(matches[2], 10, 16)
 	parse_err = append(parse_err, err)
 	r.ClientPort = uint16(ui16_cp)
 
-	ts, err := decodeTs(matches[4])
+	ts, err := decodeTs(matches[3])
 	parse_err = append(parse_err, err)
 	r.TS = ts.UnixMicro()
 
-	r.FrontendName = matches[5]
+	r.FrontendName = matches[4]
 
-	if matches[6] == `~` {
+	if matches[5] == `~` {
 		r.ClientSSL = true
 	}
 
-	r.BackendName = matches[7]
+	r.BackendName = matches[6]
 
-	r.ServerName = matches[8]
+	r.ServerName = matches[7]
 
-	r.RequestHeaderDurationMs, err = strconv.Atoi(matches[9])
+	r.LogVersion = v.layout()
+	tq, err := strconv.Atoi(matches[8])
 	parse_err = append(parse_err, err)
 
-	r.QueueDurationMs, err = strconv.Atoi(matches[10])
+	r.QueueDurationMs, err = strconv.Atoi(matches[9])
 	parse_err = append(parse_err, err)
 
-	r.ServerConnDurationMs, err = strconv.Atoi(matches[11])
+	r.ServerConnDurationMs, err = strconv.Atoi(matches[10])
 	parse_err = append(parse_err, err)
 
-	r.ResponseHeaderDurationMs, err = strconv.Atoi(matches[12])
+	r.ResponseHeaderDurationMs, err = strconv.Atoi(matches[11])
 	parse_err = append(parse_err, err)
 
-	r.TotalDurationMs, err = strconv.Atoi(matches[13])
+	tt, err := strconv.Atoi(matches[12])
+	parse_err = append(parse_err, err)
+	r.setHTTPLogTimers(tq, tt)
 
-	i16_sc, err := strconv.ParseInt(matches[14], 10, 16)
+	i16_sc, err := strconv.ParseInt(matches[13], 10, 16)
 	parse_err = append(parse_err, err)
 	r.StatusCode = int16(i16_sc)
 
-	ui64_br, err := strconv.ParseUint(matches[15], 10, 64)
+	ui64_br, err := strconv.ParseUint(matches[14], 10, 64)
 	parse_err = append(parse_err, err)
 	r.BytesRead = uint64(ui64_br)
-	r.CapturedRequestCookie = matches[16]
-	r.CapturedResponseCookie = matches[17]
-	r.TerminationReason = rune(matches[18][0])
-	r.SessionCloseState = rune(matches[18][1])
-	r.ClientPersistenceState = rune(matches[18][2])
-	r.PersistenceCookieState = rune(matches[18][3])
-	r.CapturedSamples = matches[26]
-	if matches[27] == `"<BADREQ>"` {
+	r.CapturedRequestCookie = matches[15]
+	r.CapturedResponseCookie = matches[16]
+	r.TerminationReason = rune(matches[17][0])
+	r.SessionCloseState = rune(matches[17][1])
+	r.ClientPersistenceState = rune(matches[17][2])
+	r.PersistenceCookieState = rune(matches[17][3])
+	var u uint64
+	for i, field := range []*uint{&r.TotalConn, &r.FrontendConn, &r.BackendConn, &r.ServerConn, &r.Retries, &r.ServerQueue, &r.BackendQueue} {
+		u, err = strconv.ParseUint(matches[18+i], 10, 32)
+		parse_err = append(parse_err, err)
+		*field = uint(u)
+	}
+	r.CapturedSamples = matches[25]
+	r.applyCaptureLayout(d.Captures)
+	if err := decodeRequestLine(&r, matches[26]); err != nil {
+		return r, err
+	}
+	for _, element := range parse_err {
+		if element != nil {
+			return r, element
+		}
+	}
+
+	return r, err
+}
+
+// decode quoted request line ("GET / HTTP/1.1") into request fields
+func decodeRequestLine(r *HTTPRequest, s string) error {
+	if s == `"<BADREQ>"` {
 		r.RequestMethod = "ERR"
 		r.RequestPath = "<BADREQ>"
 		r.HTTPVersion = "HTTP/0.0"
 		r.BadReq = true
-	} else if strings.HasSuffix(matches[27], `"`) {
-		submatches := reqPathRegex.FindStringSubmatch(matches[27])
+	} else if strings.HasSuffix(s, `"`) {
+		submatches := reqPathRegex.FindStringSubmatch(s)
 		if len(submatches) < 4 {
-			return r, errors.New(fmt.Sprintf("Not enough matches in subfield [%s]", matches[27]))
+			return errors.New(fmt.Sprintf("Not enough matches in subfield [%s]", s))
 		}
 		r.RequestMethod = submatches[1]
 		r.RequestPath = submatches[2]
@@ -146,20 +258,17 @@ func DecodeHTTPLog(s string) (HTTPRequest, error) {
 
 	} else {
 		// no ending " means request got truncated, just try to do what you can
-		submatches := reqTooLongPathRegex.FindStringSubmatch(matches[27])
+		submatches := reqTooLongPathRegex.FindStringSubmatch(s)
+		if len(submatches) < 3 {
+			return errors.New(fmt.Sprintf("Not enough matches in subfield [%s]", s))
+		}
 		r.RequestMethod = submatches[1]
 		r.RequestPath = submatches[2]
 		// pretend to know version, it probably got truncated with "
 		r.HTTPVersion = "HTTP/1.1"
 		r.Truncated = true
 	}
-	for _, element := range parse_err {
-		if element != nil {
-			return r, element
-		}
-	}
-
-	return r, err
+	return nil
 }
 
 func decodeTs(s string) (ts time.Time, err error) {
@@ -167,6 +276,31 @@ func decodeTs(s string) (ts time.Time, err error) {
 	return ts, err
 }
 
+// setHTTPLogTimers puts first and last httplog timer where they belong in r.LogVersion layout
+func (h *HTTPRequest) setHTTPLogTimers(first, last int) {
+	if h.LogVersion == HTTPLogV17 {
+		h.RequestReceiveDurationMs, h.ActiveDurationMs = first, last
+	} else {
+		h.RequestHeaderDurationMs, h.TotalDurationMs = first, last
+	}
+}
+
 func (h HTTPRequest) Timestamp() time.Time {
 	return time.UnixMicro(h.TS)
 }
+
+// RequestTimeMs returns time it took to receive the request: Tq, or TR for 1.7+ layout that doesn't have Tq
+func (h HTTPRequest) RequestTimeMs() int {
+	if h.LogVersion == HTTPLogV17 {
+		return h.RequestReceiveDurationMs
+	}
+	return h.RequestHeaderDurationMs
+}
+
+// TotalTimeMs returns Tt, or Ta for 1.7+ layout that doesn't have Tt (Ta doesn't include idle time and request header wait)
+func (h HTTPRequest) TotalTimeMs() int {
+	if h.LogVersion == HTTPLogV17 {
+		return h.ActiveDurationMs
+	}
+	return h.TotalDurationMs
+}
@@ -0,0 +1,221 @@
+package haproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compiled layouts used by HTTPRequest.Format
+var formatCache sync.Map
+
+// Format encodes request back into log line using log-format layout (like HTTPLogFormat15), syslog header included.
+// Decoding the line with DecodeHTTPLog (or the compiled layout) gives back the same request,
+// and for lines decoded from haproxy's output the result is byte-identical to the original (minus trailing whitespace)
+func (h HTTPRequest) Format(layout string) (string, error) {
+	f, ok := formatCache.Load(layout)
+	if !ok {
+		compiled, err := CompileLogFormat(layout)
+		if err != nil {
+			return "", err
+		}
+		f, _ = formatCache.LoadOrStore(layout, compiled)
+	}
+	return h.Syslog.String() + f.(*LogFormat).Format(h), nil
+}
+
+// Format encodes request as message part of log line in this format, without syslog header.
+// Variables not present in HTTPRequest are taken from Extra, or logged as "-" if missing
+func (f *LogFormat) Format(r HTTPRequest) string {
+	var b strings.Builder
+	capturesDone := false
+	for i, field := range f.fields {
+		b.WriteString(f.literals[i])
+		if field.optional {
+			if !capturesDone {
+				// all capture blocks are kept together in CapturedSamples
+				b.WriteString(r.CapturedSamples)
+				capturesDone = true
+			}
+			continue
+		}
+		field.format(&b, r)
+	}
+	b.WriteString(f.literals[len(f.fields)])
+	return b.String()
+}
+
+func (l logFormatField) format(b *strings.Builder, r HTTPRequest) {
+	switch l.name {
+	case "ci":
+		l.formatString(b, r.ClientIP)
+	case "cp":
+		l.formatUint(b, uint64(r.ClientPort))
+	case "t", "tr":
+		l.formatString(b, r.Timestamp().In(HaproxyLogTimezone).Format(haproxyTimeFormat))
+	case "T", "trg":
+		l.formatString(b, r.Timestamp().UTC().Format(haproxyTimeFormatTZ))
+	case "Tl", "trl":
+		l.formatString(b, r.Timestamp().In(HaproxyLogTimezone).Format(haproxyTimeFormatTZ))
+	case "Ts":
+		l.formatInt(b, int(r.TS/1000000))
+	case "ms":
+		ms := r.Timestamp().Nanosecond() / int(time.Millisecond)
+		if l.hex {
+			l.formatInt(b, ms)
+		} else {
+			b.WriteString(fmt.Sprintf("%03d", ms))
+		}
+	case "ft":
+		if r.ClientSSL {
+			l.formatString(b, r.FrontendName+"~")
+		} else {
+			l.formatString(b, r.FrontendName)
+		}
+	case "f":
+		l.formatString(b, r.FrontendName)
+	case "b":
+		l.formatString(b, r.BackendName)
+	case "s":
+		l.formatString(b, r.ServerName)
+	case "Tq":
+		l.formatInt(b, r.RequestHeaderDurationMs)
+	case "TR":
+		if r.LogVersion == HTTPLogV17 {
+			l.formatInt(b, r.RequestReceiveDurationMs)
+		} else {
+			l.formatInt(b, r.RequestHeaderDurationMs)
+		}
+	case "Ta":
+		if r.LogVersion == HTTPLogV17 {
+			l.formatInt(b, r.ActiveDurationMs)
+		} else {
+			l.formatInt(b, r.TotalDurationMs)
+		}
+	case "Ti":
+		l.formatInt(b, r.IdleDurationMs)
+	case "Tw":
+		l.formatInt(b, r.QueueDurationMs)
+	case "Tc":
+		l.formatInt(b, r.ServerConnDurationMs)
+	case "Tr":
+		l.formatInt(b, r.ResponseHeaderDurationMs)
+	case "Tt":
+		l.formatInt(b, r.TotalDurationMs)
+	case "ST":
+		l.formatInt(b, int(r.StatusCode))
+	case "B":
+		l.formatUint(b, r.BytesRead)
+	case "CC":
+		l.formatString(b, r.CapturedRequestCookie)
+	case "CS":
+		l.formatString(b, r.CapturedResponseCookie)
+	case "ts":
+		l.formatString(b, string([]rune{r.TerminationReason, r.SessionCloseState}))
+	case "tsc":
+		l.formatString(b, string([]rune{r.TerminationReason, r.SessionCloseState, r.ClientPersistenceState, r.PersistenceCookieState}))
+	case "ac":
+		l.formatUint(b, uint64(r.TotalConn))
+	case "fc":
+		l.formatUint(b, uint64(r.FrontendConn))
+	case "bc":
+		l.formatUint(b, uint64(r.BackendConn))
+	case "sc":
+		l.formatUint(b, uint64(r.ServerConn))
+	case "rc":
+		l.formatUint(b, uint64(r.Retries))
+	case "sq":
+		l.formatUint(b, uint64(r.ServerQueue))
+	case "bq":
+		l.formatUint(b, uint64(r.BackendQueue))
+	case "hr", "hs", "hrl", "hsl":
+		b.WriteString(strings.TrimLeft(r.CapturedSamples, " "))
+	case "r":
+		l.formatRequestLine(b, r)
+	case "HM":
+		l.formatString(b, r.RequestMethod)
+	case "HU", "HP":
+		l.formatString(b, r.RequestPath)
+	case "HV":
+		l.formatString(b, r.HTTPVersion)
+	case "ID":
+		l.formatString(b, r.UniqueID)
+	case "pid":
+		l.formatInt(b, r.PID)
+	default:
+		if v, ok := r.Extra[l.name]; ok {
+			l.formatString(b, v)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+}
+
+func (l logFormatField) formatRequestLine(b *strings.Builder, r HTTPRequest) {
+	if r.RequestMethod == "" && r.RequestPath == "" {
+		b.WriteByte('-')
+		return
+	}
+	if l.quoted {
+		b.WriteByte('"')
+	}
+	switch {
+	case r.BadReq:
+		b.WriteString(r.RequestPath)
+	case r.Truncated:
+		// whatever was after the path is lost, including closing quote
+		b.WriteString(r.RequestMethod + " " + r.RequestPath)
+		return
+	default:
+		b.WriteString(r.RequestMethod + " " + r.RequestPath + " " + r.HTTPVersion)
+	}
+	if l.quoted {
+		b.WriteByte('"')
+	}
+}
+
+// formatString is inverse of value()
+func (l logFormatField) formatString(b *strings.Builder, s string) {
+	if s == "" {
+		b.WriteByte('-')
+		return
+	}
+	if l.quoted {
+		b.WriteByte('"')
+	}
+	if l.escaped {
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\\' || s[i] == '"' || (!l.quoted && isSpace(s[i])) {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(s[i])
+		}
+	} else {
+		b.WriteString(s)
+	}
+	if l.quoted {
+		b.WriteByte('"')
+	}
+}
+
+func (l logFormatField) formatInt(b *strings.Builder, v int) {
+	if l.hex {
+		if v < 0 {
+			b.WriteByte('-')
+			return
+		}
+		b.WriteString(strings.ToUpper(strconv.FormatInt(int64(v), 16)))
+		return
+	}
+	b.WriteString(strconv.Itoa(v))
+}
+
+func (l logFormatField) formatUint(b *strings.Builder, v uint64) {
+	if l.hex {
+		b.WriteString(strings.ToUpper(strconv.FormatUint(v, 16)))
+		return
+	}
+	b.WriteString(strconv.FormatUint(v, 10))
+}
@@ -0,0 +1,64 @@
+package haproxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatRoundTrip(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	lines, err := readLines("t-data/haproxy_log")
+	require.NoError(t, err)
+	spoa, err := readLines("t-data/haproxy_log_spoa")
+	require.NoError(t, err)
+	lines = append(lines, spoa...)
+	lines = append(lines, scanTestLines...)
+	n := 0
+	for _, line := range lines {
+		r, err := DecodeHTTPLog(line)
+		if err != nil {
+			continue
+		}
+		n++
+		out, err := r.Format(HTTPLogFormat15)
+		require.NoError(t, err)
+		assert.Equal(t, strings.TrimRight(line, "\r\n\t "), out)
+		again, err := DecodeHTTPLog(out)
+		require.NoError(t, err)
+		assert.Equal(t, r, again)
+	}
+	assert.Greater(t, n, 30)
+}
+
+func TestFormatLayouts(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	line := `<158>Sep  1 17:50:37 haproxy[1866]: 127.0.0.1:52320 [01/Sep/2022:17:50:37.898] default~ local-3001/<NOSRV> 0/-1/-1/-1/0 503 253 - - SC-- 1/1/0/0/0 0/0 "GET /slow/default HTTP/1.1"`
+	r, err := HTTPLogV17.Decode(line)
+	require.NoError(t, err)
+	out, err := r.Format(HTTPLogFormat)
+	require.NoError(t, err)
+	assert.Equal(t, line, out)
+
+	// custom format, rfc5424 header
+	r.Syslog = SyslogHeader{Format: SyslogRFC5424, Facility: 19, Severity: 6, Timestamp: time.Date(2022, 9, 1, 17, 50, 37, 898000000, time.UTC), Hostname: "lb1", Tag: "haproxy", PID: 1866}
+	r.UniqueID = "ABCD"
+	r.Extra = map[string]string{"[var(txn.user)]": `john "jd" doe`}
+	layout := `%ci:%cp [%tr] %ft %ST %{+X}B %ID %{+Q,+E}[var(txn.user)] %[var(txn.none)] %HM %{+Q}HU %Ts.%ms`
+	out, err = r.Format(layout)
+	require.NoError(t, err)
+	assert.Equal(t, `<158>1 2022-09-01T17:50:37.898000+00:00 lb1 haproxy 1866 - - 127.0.0.1:52320 [01/Sep/2022:17:50:37.898] default~ 503 FD ABCD "john \"jd\" doe" - GET "/slow/default" 1662054637.898`, out)
+	decoded, err := MustCompileLogFormat(layout).Decode(out)
+	require.NoError(t, err)
+	assert.Equal(t, r.Extra["[var(txn.user)]"], decoded.Extra["[var(txn.user)]"])
+	assert.True(t, r.Syslog.Timestamp.Equal(decoded.Syslog.Timestamp))
+	decoded.Syslog.Timestamp = r.Syslog.Timestamp
+	assert.Equal(t, r.Syslog, decoded.Syslog)
+	assert.Equal(t, r.TS, decoded.TS)
+
+	_, err = r.Format(`%{+Q`)
+	assert.Error(t, err)
+}
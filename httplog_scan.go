@@ -0,0 +1,389 @@
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// hand-written equivalent of haproxyRegex, it follows regex semantics so both return the same thing
+
+var errNotMatching = errors.New("input not matching regex")
+
+// DecodeHTTPLogInto decodes httplog line into r, giving the same result as DecodeHTTPLog but without regexps and allocations
+// (unless captures are decoded, see HTTPLogDecoder). String fields of r point into s. r is reset before decoding
+func DecodeHTTPLogInto(s string, r *HTTPRequest) error {
+	return HTTPLogV15.DecodeInto(s, r)
+}
+
+// DecodeInto is DecodeHTTPLogInto for this layout
+func (v HTTPLogVersion) DecodeInto(s string, r *HTTPRequest) error {
+	return HTTPLogDecoder{Version: v}.DecodeInto(s, r)
+}
+
+// DecodeInto is DecodeHTTPLogInto with decoder's settings
+func (d HTTPLogDecoder) DecodeInto(s string, r *HTTPRequest) error {
+	v := d.Version
+	*r = HTTPRequest{}
+	if err := v.check(); err != nil {
+		return err
+	}
+	var err error
+	r.Syslog, s, err = DecodeSyslog(s)
+	if err != nil {
+		return err
+	}
+	r.PID = r.Syslog.PID
+	r.LogVersion = v.layout()
+	return scanHTTPLog(s, r, d.Captures)
+}
+
+// DecodeHTTPLogBytes is DecodeHTTPLogInto for byte slice. It is not allocation-free: b is copied once (string fields point
+// into the copy), so b can be reused after it returns
+func DecodeHTTPLogBytes(b []byte, r *HTTPRequest) error {
+	return DecodeHTTPLogInto(string(b), r)
+}
+
+func scanHTTPLog(s string, r *HTTPRequest, captures CaptureLayout) error {
+	// nothing in the line can contain newline, anything after it has to be whitespace
+	if nl := strings.IndexByte(s, '\n'); nl >= 0 {
+		for i := nl; i < len(s); i++ {
+			if s[i] != '|' && !isSpace(s[i]) {
+				return errNotMatching
+			}
+		}
+		s = s[:nl]
+	}
+	// Groups before the timers are lazy and can contain their separators. Regexp picks the shortest client IP, then date,
+	// frontend, backend and server that let the rest match. Each shortest choice leaves the most room for what follows,
+	// so if it doesn't work no other one would, except for server which is the only one followed by something that can fail
+
+	// ip:port [
+	colon, i := -1, -1
+	for c := indexByteFrom(s, ':', 1); c >= 0; c = indexByteFrom(s, ':', c+1) {
+		i = c + 1
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i > c+1 && strings.HasPrefix(s[i:], " [") {
+			colon = c
+			break
+		}
+	}
+	if colon < 0 {
+		return errNotMatching
+	}
+	r.ClientIP = s[:colon]
+	port := s[colon+1 : i]
+	s = s[i+2:]
+
+	// date]
+	i = indexFrom(s, "] ", 1)
+	if i < 0 {
+		return errNotMatching
+	}
+	date := s[:i]
+	s = s[i+2:]
+
+	// frontend[~]
+	i = indexByteFrom(s, ' ', 1)
+	if i < 0 {
+		return errNotMatching
+	}
+	r.FrontendName = s[:i]
+	if i > 1 && s[i-1] == '~' {
+		r.FrontendName = s[:i-1]
+		r.ClientSSL = true
+	}
+	s = s[i+1:]
+
+	// backend/server, server ends at first space where the rest matches
+	slash := indexByteFrom(s, '/', 1)
+	if slash < 0 {
+		return errNotMatching
+	}
+	// captures end at the last `} "` of the line whatever server is, find it once
+	t := httpLogTail{capturesEnd: -1}
+	if end := strings.LastIndex(s, `} "`); end >= 0 {
+		t.capturesEnd = len(s) - end
+	}
+	sp := indexByteFrom(s, ' ', slash+2)
+	for sp >= 0 && !scanHTTPLogTail(s[sp+1:], &t) {
+		sp = indexByteFrom(s, ' ', sp+1)
+	}
+	if sp < 0 {
+		return errNotMatching
+	}
+	r.BackendName = s[:slash]
+	r.ServerName = s[slash+1 : sp]
+	r.CapturedRequestCookie = t.requestCookie
+	r.CapturedResponseCookie = t.responseCookie
+	r.TerminationReason = rune(t.state[0])
+	r.SessionCloseState = rune(t.state[1])
+	r.ClientPersistenceState = rune(t.state[2])
+	r.PersistenceCookieState = rune(t.state[3])
+	r.CapturedSamples = t.captures
+	r.applyCaptureLayout(captures)
+
+	// same error precedence as DecodeHTTPLog
+	var firstErr error
+	setErr := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	u, err := strconv.ParseUint(port, 10, 16)
+	setErr(err)
+	r.ClientPort = uint16(u)
+	ts, err := decodeTs(date)
+	setErr(err)
+	r.TS = ts.UnixMicro()
+	first, err := strconv.Atoi(t.timers[0])
+	setErr(err)
+	r.QueueDurationMs, err = strconv.Atoi(t.timers[1])
+	setErr(err)
+	r.ServerConnDurationMs, err = strconv.Atoi(t.timers[2])
+	setErr(err)
+	r.ResponseHeaderDurationMs, err = strconv.Atoi(t.timers[3])
+	setErr(err)
+	last, err := strconv.Atoi(t.timers[4])
+	setErr(err)
+	r.setHTTPLogTimers(first, last)
+	st, err := strconv.ParseInt(t.status, 10, 16)
+	setErr(err)
+	r.StatusCode = int16(st)
+	r.BytesRead, err = strconv.ParseUint(t.bytesRead, 10, 64)
+	setErr(err)
+	for n, field := range [...]*uint{&r.TotalConn, &r.FrontendConn, &r.BackendConn, &r.ServerConn, &r.Retries, &r.ServerQueue, &r.BackendQueue} {
+		u, err = strconv.ParseUint(t.counts[n], 10, 32)
+		setErr(err)
+		*field = uint(u)
+	}
+	if err := scanRequestLine(r, t.request); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// httpLogTail are fields after server name, as text
+type httpLogTail struct {
+	timers         [5]string
+	status         string
+	bytesRead      string
+	requestCookie  string
+	responseCookie string
+	state          string
+	counts         [7]string
+	captures       string
+	request        string
+	// position of last `} "` counted from the end of the line, -1 if there is none
+	capturesEnd int
+}
+
+// scanHTTPLogTail splits everything after server name into fields, returns false if it doesn't match
+func scanHTTPLogTail(s string, t *httpLogTail) bool {
+	for n := range t.timers {
+		sep := byte('/')
+		if n == len(t.timers)-1 {
+			sep = ' '
+		}
+		if t.timers[n], s = cutNumber(s, sep); s == "" {
+			return false
+		}
+	}
+	if t.status, s = cutNumber(s, ' '); s == "" {
+		return false
+	}
+	if t.bytesRead, s = cutNumber(s, ' '); s == "" {
+		return false
+	}
+	var ok bool
+	if t.requestCookie, s, ok = cutToken(s); !ok {
+		return false
+	}
+	if t.responseCookie, s, ok = cutToken(s); !ok {
+		return false
+	}
+	// \S{4} is four runes, invalid UTF-8 bytes count as one each like in regexp
+	i := 0
+	for n := 0; n < 4; n++ {
+		if i >= len(s) || isSpace(s[i]) {
+			return false
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	if i >= len(s) || s[i] != ' ' {
+		return false
+	}
+	t.state, s = s[:i], s[i+1:]
+
+	for n := range t.counts {
+		sep := byte('/')
+		if n == 4 {
+			sep = ' '
+		}
+		if n == len(t.counts)-1 {
+			// last one is followed by either captures or request
+			i := 0
+			for i < len(s) && (s[i] == '-' || (s[i] >= '0' && s[i] <= '9')) {
+				i++
+			}
+			if i == 0 || i == len(s) {
+				return false
+			}
+			t.counts[n], s = s[:i], s[i:]
+			break
+		}
+		if t.counts[n], s = cutNumber(s, sep); s == "" {
+			return false
+		}
+	}
+
+	t.captures = ""
+	if strings.HasPrefix(s, ` "`) {
+		t.request = s[1:]
+		return true
+	}
+	if !strings.HasPrefix(s, " {") {
+		return false
+	}
+	// greedy: captures end at last `} "`
+	end := len(s) - t.capturesEnd
+	if t.capturesEnd < 0 || end < 2 {
+		return false
+	}
+	t.captures = s[:end+1]
+	t.request = s[end+2:]
+	return true
+}
+
+// scanRequestLine is decodeRequestLine without regexps
+func scanRequestLine(r *HTTPRequest, s string) error {
+	if s == `"<BADREQ>"` {
+		r.RequestMethod = "ERR"
+		r.RequestPath = "<BADREQ>"
+		r.HTTPVersion = "HTTP/0.0"
+		r.BadReq = true
+		return nil
+	}
+	complete := strings.HasSuffix(s, `"`)
+	// leftmost `"` where the pattern matches. Method is the rest of the word the quote is in, so everything after it
+	// is the same for any quote in that word, only the first one has to be tried and each word is looked at once
+	for i := 0; i < len(s); {
+		if isSpace(s[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && !isSpace(s[i]) {
+			i++
+		}
+		q := strings.IndexByte(s[start:i], '"')
+		if q < 0 || start+q+1 == i || i == len(s) || s[i] != ' ' {
+			continue
+		}
+		method := s[start+q+1 : i]
+		path, rest, ok := cutWord(s[i+1:])
+		if !complete {
+			if path == "" {
+				continue
+			}
+			r.RequestMethod = method
+			r.RequestPath = path
+			r.HTTPVersion = "HTTP/1.1"
+			r.Truncated = true
+			return nil
+		}
+		if !ok {
+			continue
+		}
+		word, _, _ := cutWord(rest)
+		end := strings.LastIndexByte(word, '"')
+		if end < 1 {
+			continue
+		}
+		r.RequestMethod = method
+		r.RequestPath = path
+		r.HTTPVersion = word[:end]
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Not enough matches in subfield [%s]", s))
+}
+
+// cutWord cuts non-empty run of non-space characters that is followed by a space
+func cutWord(s string) (word, rest string, followedBySpace bool) {
+	i := 0
+	for i < len(s) && !isSpace(s[i]) {
+		i++
+	}
+	if i == 0 {
+		return "", s, false
+	}
+	if i < len(s) && s[i] == ' ' {
+		return s[:i], s[i+1:], true
+	}
+	return s[:i], s[i:], false
+}
+
+// cutNumber cuts [-0-9]+ followed by sep, rest is empty if it doesn't match
+func cutNumber(s string, sep byte) (string, string) {
+	i := 0
+	for i < len(s) && (s[i] == '-' || (s[i] >= '0' && s[i] <= '9')) {
+		i++
+	}
+	if i == 0 || i+1 >= len(s) || s[i] != sep {
+		return "", ""
+	}
+	return s[:i], s[i+1:]
+}
+
+// cutToken cuts \S+ followed by space
+func cutToken(s string) (string, string, bool) {
+	i := 0
+	for i < len(s) && !isSpace(s[i]) {
+		i++
+	}
+	if i == 0 || i+1 >= len(s) || s[i] != ' ' {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func indexFrom(s, substr string, from int) int {
+	if from > len(s) {
+		return -1
+	}
+	i := strings.Index(s[from:], substr)
+	if i < 0 {
+		return -1
+	}
+	return i + from
+}
+
+func indexByteFrom(s string, c byte, from int) int {
+	if from > len(s) {
+		return -1
+	}
+	i := strings.IndexByte(s[from:], c)
+	if i < 0 {
+		return -1
+	}
+	return i + from
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// regexp's \s
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
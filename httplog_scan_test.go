@@ -0,0 +1,133 @@
+package haproxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var scanTestLines = []string{
+	`<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1_foobar~ backend_foobar-ssl/app3-backend 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/0 "POST /query/q/Sql HTTP/1.1"`,
+	`<158>Jul 23 13:49:11 haproxy[11446]: 83.7.1.151:52174 [23/Jul/2015:13:49:06.525] front_tst-static front_tst-static/<NOSRV> -1/-1/-1/-1/5000 400 187 - - CR-- 1615/1130/0/0/0 0/0 "<BADREQ>"`,
+	`<158>Sep  1 17:50:37 haproxy[1866]: 127.0.0.1:52320 [01/Sep/2022:17:50:37.898] default local-3001/<NOSRV> 0/-1/-1/-1/0 503 253 - - SC-- 1/1/0/0/0 0/0 {3001.localhost} {|} "GET /slow/default HTTP/1.1"`,
+	`<158>Jul 23 13:49:11 haproxy[12345]: 11174.211.190:10165 [23/Jul/2015:13:49:10.989] front1 backend-static/bl3-varnish 432/0/0/0/432 200 11429 - - ---- 1590/1125/3/2/0 0/0 "GET /gfx/11/11/11/test/111111111111111111111//S%C3%83%C6%92`,
+	"<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1_foobar~ backend_foobar-ssl/app3-backend 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/0 \"POST /query/q/Sql HTTP/1.1\"  \n",
+	`<134>1 2009-02-06T12:12:56+01:00 lb-1 haproxy 14387 - - [::1]:61059 [23/Jul/2015:13:49:11.933] fe be/srv 1/0/1/52/1348 200 1140 - - --VN 1/1/1/1/0 0/0 {a} "GET /a} "b HTTP/1.1"`,
+	`83.3.255.169:61059 [23/Jul/2015:13:49:11.933] fe be/srv 1/0/1/52/1348 200 1140 - - --VN 1/1/1/1/0 0/0 "POST  HTTP/1.1"`,
+	`83.3.255.169:61059 [23/Jul/2015:13:49:11.933] fe be/srv 1/0/1/52/1348 1000000 1140 - - --VN 1/1/1/1/0 0/0 "GET / HTTP/1.1"`,
+	`83.3.255.169:61059 [23/Jul/2015:13:49:11.933] fe be/srv 1/0/1/52/1348 200 1140 - - --VN 1/1/1/1/0 0/0`,
+	`23ej87thfdsg623gtr`,
+}
+
+func scanCorpus(t testing.TB) []string {
+	lines := append([]string{}, scanTestLines...)
+	for _, f := range []string{"t-data/haproxy_log", "t-data/haproxy_log_spoa"} {
+		l, err := readLines(f)
+		require.NoError(t, err)
+		lines = append(lines, l...)
+	}
+	return lines
+}
+
+// checkScanEquivalence checks that scanner accepts the same lines as regex version and decodes them the same
+func checkScanEquivalence(t *testing.T, line string) {
+	expected, expectedErr := DecodeHTTPLog(line)
+	var out HTTPRequest
+	err := DecodeHTTPLogInto(line, &out)
+	require.Equal(t, expectedErr == nil, err == nil, "regex error: %v, scanner error: %v, line: %q", expectedErr, err, line)
+	if err != nil {
+		return
+	}
+	require.Equal(t, expected, out, "%q", line)
+}
+
+func TestDecodeHTTPLogInto(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	for _, line := range scanCorpus(t) {
+		expected, expectedErr := DecodeHTTPLog(line)
+		var out HTTPRequest
+		err := DecodeHTTPLogInto(line, &out)
+		if expectedErr != nil {
+			assert.Error(t, err, line)
+			continue
+		}
+		require.NoError(t, err, line)
+		assert.Equal(t, expected, out, line)
+		out = HTTPRequest{}
+		require.NoError(t, DecodeHTTPLogBytes([]byte(line), &out))
+		assert.Equal(t, expected, out, line)
+	}
+}
+
+func TestDecodeHTTPLogIntoLong(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	prefix := `83.3.255.169:61059 [23/Jul/2015:13:49:11.933] fe be/srv 1/0/1/52/1348 200 1140 - - --VN 1/1/1/1/0 0/0 `
+	for name, line := range map[string]string{
+		"quotes":          prefix + strings.Repeat(`"`, 64<<10),
+		"quoted words":    prefix + strings.Repeat(`"a `, 20<<10) + `"`,
+		"server spaces":   `a:1 [23/Jul/2015:13:49:11.933] f b/` + strings.Repeat(" 1/1/1/1/1 1 1 a b cccc 1/1/1/1/1 1/1 {", 1500),
+		"quotes and time": prefix + strings.Repeat(`""""" GET `, 6<<10) + `"`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			var out HTTPRequest
+			_ = DecodeHTTPLogInto(line, &out)
+			// quadratic scanning takes seconds here
+			assert.Less(t, time.Since(start), 200*time.Millisecond)
+			checkScanEquivalence(t, line)
+		})
+	}
+}
+
+func TestDecodeHTTPLogIntoAllocs(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	var out HTTPRequest
+	line := scanTestLines[2]
+	allocs := testing.AllocsPerRun(100, func() {
+		DecodeHTTPLogInto(line, &out)
+	})
+	assert.Zero(t, allocs)
+	b := []byte(line)
+	allocs = testing.AllocsPerRun(100, func() {
+		DecodeHTTPLogBytes(b, &out)
+	})
+	// copy of b
+	assert.Equal(t, float64(1), allocs)
+}
+
+func FuzzDecodeHTTPLogInto(f *testing.F) {
+	HaproxyLogTimezone = time.UTC
+	for _, line := range scanCorpus(f) {
+		f.Add(line)
+	}
+	f.Fuzz(checkScanEquivalence)
+}
+
+func BenchmarkDecodeHTTPLogRegex(b *testing.B) {
+	line := scanTestLines[2]
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = DecodeHTTPLog(line)
+	}
+}
+
+func BenchmarkDecodeHTTPLogInto(b *testing.B) {
+	line := scanTestLines[2]
+	var out HTTPRequest
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = DecodeHTTPLogInto(line, &out)
+	}
+}
+
+func BenchmarkDecodeHTTPLogBytes(b *testing.B) {
+	line := []byte(scanTestLines[2])
+	var out HTTPRequest
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = DecodeHTTPLogBytes(line, &out)
+	}
+}
@@ -67,6 +67,61 @@ func TestLogParsing(t *testing.T) {
 	})
 }
 
+func TestLogVersion(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	s := `<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1_foobar~ backend_foobar-ssl/app3-backend 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/0 "POST /query/q/Sql HTTP/1.1"`
+	out, err := DecodeHTTPLog(s)
+	require.NoError(t, err)
+	assert.Equal(t, HTTPLogV15, out.LogVersion)
+	assert.Equal(t, 1294, out.RequestHeaderDurationMs)
+	assert.Equal(t, 1348, out.TotalDurationMs)
+	assert.Equal(t, 0, out.RequestReceiveDurationMs)
+	assert.Equal(t, 0, out.ActiveDurationMs)
+	assert.Equal(t, 1294, out.RequestTimeMs())
+	assert.Equal(t, 1348, out.TotalTimeMs())
+
+	out, err = HTTPLogV17.Decode(s)
+	require.NoError(t, err)
+	assert.Equal(t, HTTPLogV17, out.LogVersion)
+	assert.Equal(t, 1294, out.RequestReceiveDurationMs)
+	assert.Equal(t, 1348, out.ActiveDurationMs)
+	assert.Equal(t, 0, out.RequestHeaderDurationMs)
+	assert.Equal(t, 0, out.TotalDurationMs)
+	assert.Equal(t, 1294, out.RequestTimeMs())
+	assert.Equal(t, 1348, out.TotalTimeMs())
+
+	var into HTTPRequest
+	require.NoError(t, HTTPLogV17.DecodeInto(s, &into))
+	assert.Equal(t, out, into)
+	log, err := HTTPLogV17.DecodeLog(s)
+	require.NoError(t, err)
+	assert.Equal(t, out, log)
+
+	_, err = HTTPLogVersion("2.8").Decode(s)
+	assert.Error(t, err)
+	_, err = ParseHTTPLogVersion("2.8")
+	assert.Error(t, err)
+	v, err := ParseHTTPLogVersion("1.7")
+	require.NoError(t, err)
+	assert.Equal(t, HTTPLogV17, v)
+
+	f := MustCompileLogFormat(HTTPLogFormat)
+	out, err = f.Decode(s)
+	require.NoError(t, err)
+	assert.Equal(t, HTTPLogV17, out.LogVersion)
+	assert.Equal(t, 1348, out.ActiveDurationMs)
+	assert.Equal(t, 0, out.TotalDurationMs)
+	assert.Equal(t, 1294, out.RequestTimeMs())
+	assert.Equal(t, 1348, out.TotalTimeMs())
+	out, err = MustCompileLogFormat(`%Th/%Ti/%TR/%Tw/%Tc/%Tr/%Ta/%Tt`).Decode(`1/2/3/4/5/6/7/10`)
+	require.NoError(t, err)
+	assert.Equal(t, 2, out.IdleDurationMs)
+	assert.Equal(t, 3, out.RequestReceiveDurationMs)
+	assert.Equal(t, 7, out.ActiveDurationMs)
+	assert.Equal(t, 10, out.TotalDurationMs)
+	assert.Equal(t, "1", out.Extra["Th"])
+}
+
 func TestBadReq(t *testing.T) {
 	s := `<158>Jul 23 13:49:11 haproxy[11446]: 83.7.1.151:52174 [23/Jul/2015:13:49:06.525] front_tst-static front_tst-static/<NOSRV> -1/-1/-1/-1/5000 400 187 - - CR-- 1615/1130/0/0/0 0/0 "<BADREQ>"`
 	out, err := DecodeHTTPLog(s)
@@ -0,0 +1,137 @@
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// URL parses RequestPath.
+// Origin-form (`/path?q=1`) only has Path and RawQuery set, absolute-form (`https://host/path`, logged for HTTP/2+ and proxied requests)
+// also has Scheme and Host, CONNECT's authority-form (`host:443`) only Host.
+func (h HTTPRequest) URL() (*url.URL, error) {
+	if h.BadReq {
+		return nil, errors.New("bad request has no URL")
+	}
+	switch {
+	case h.RequestPath == "":
+		return nil, errors.New("empty request path")
+	case h.RequestMethod == "CONNECT":
+		return &url.URL{Host: h.RequestPath}, nil
+	case h.RequestPath == "*":
+		return &url.URL{Path: "*"}, nil
+	}
+	u, err := url.ParseRequestURI(h.RequestPath)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Scheme returns scheme of absolute-form request URI, empty for origin-form
+func (h HTTPRequest) Scheme() string {
+	if u, err := h.URL(); err == nil {
+		return u.Scheme
+	}
+	return ""
+}
+
+// Host returns host[:port] from absolute-form or CONNECT request URI, empty for origin-form
+// (use captured Host header for these)
+func (h HTTPRequest) Host() string {
+	if u, err := h.URL(); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// Path returns percent-decoded path without query string, suitable for grouping.
+// Paths that can't be parsed (truncated, invalid escapes) are returned up to `?` as they are
+func (h HTTPRequest) Path() string {
+	if u, err := h.URL(); err == nil {
+		if u.Path == "" && u.Host != "" && h.RequestMethod != "CONNECT" {
+			return "/"
+		}
+		return u.Path
+	}
+	if h.BadReq {
+		return h.RequestPath
+	}
+	p := h.RequestPath
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		p = p[:i]
+	}
+	if d, err := url.PathUnescape(p); err == nil {
+		return d
+	}
+	return p
+}
+
+// Query returns parsed query parameters. Malformed pairs are skipped
+func (h HTTPRequest) Query() url.Values {
+	if h.BadReq {
+		return url.Values{}
+	}
+	p := h.RequestPath
+	i := strings.IndexByte(p, '?')
+	if i < 0 {
+		return url.Values{}
+	}
+	p = p[i+1:]
+	if i := strings.IndexByte(p, '#'); i >= 0 {
+		p = p[:i]
+	}
+	q, _ := url.ParseQuery(p)
+	return q
+}
+
+// Proto returns HTTP version numbers. Accepts both `HTTP/2.0` and `HTTP/2` (`HTTP/3`) spellings, ok is false for <BADREQ>
+// or anything that isn't a HTTP version
+func (h HTTPRequest) Proto() (major, minor int, ok bool) {
+	if h.BadReq {
+		return 0, 0, false
+	}
+	return parseHTTPVersion(h.HTTPVersion)
+}
+
+// NoServer returns true if request wasn't passed to any server (<NOSRV>), e.g. it was denied, redirected or served from cache
+func (h HTTPRequest) NoServer() bool {
+	return h.ServerName == "<NOSRV>"
+}
+
+func parseHTTPVersion(s string) (major, minor int, ok bool) {
+	v := strings.TrimPrefix(s, "HTTP/")
+	if len(v) == len(s) || v == "" {
+		return 0, 0, false
+	}
+	maj, min := v, "0"
+	if i := strings.IndexByte(v, '.'); i >= 0 {
+		maj, min = v[:i], v[i+1:]
+	}
+	if !isDigits(maj) || !isDigits(min) {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(maj)
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(min)
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// ProtoString returns normalized HTTP version, `HTTP/1.1`, `HTTP/2` or `HTTP/3`, so both spellings group together
+func (h HTTPRequest) ProtoString() string {
+	major, minor, ok := h.Proto()
+	if !ok {
+		return h.HTTPVersion
+	}
+	if major >= 2 && minor == 0 {
+		return fmt.Sprintf("HTTP/%d", major)
+	}
+	return fmt.Sprintf("HTTP/%d.%d", major, minor)
+}
@@ -0,0 +1,86 @@
+package haproxy
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestURL(t *testing.T) {
+	h := HTTPRequest{RequestMethod: "GET", RequestPath: "/s%C3%B3k/a%20b?q=1&q=2&x=%2F", HTTPVersion: "HTTP/1.1"}
+	u, err := h.URL()
+	require.NoError(t, err)
+	assert.Equal(t, "", u.Host)
+	assert.Equal(t, "/sók/a b", h.Path())
+	assert.Equal(t, url.Values{"q": {"1", "2"}, "x": {"/"}}, h.Query())
+	assert.Equal(t, "", h.Scheme())
+
+	// HTTP/2 logs absolute-form
+	h = HTTPRequest{RequestMethod: "GET", RequestPath: "https://example.com:8443/api/v1?id=5", HTTPVersion: "HTTP/2.0"}
+	assert.Equal(t, "https", h.Scheme())
+	assert.Equal(t, "example.com:8443", h.Host())
+	assert.Equal(t, "/api/v1", h.Path())
+	assert.Equal(t, "5", h.Query().Get("id"))
+	h.RequestPath = "https://example.com"
+	assert.Equal(t, "/", h.Path())
+
+	h = HTTPRequest{RequestMethod: "CONNECT", RequestPath: "example.com:443", HTTPVersion: "HTTP/1.1"}
+	assert.Equal(t, "example.com:443", h.Host())
+	assert.Equal(t, "", h.Path())
+	h = HTTPRequest{RequestMethod: "OPTIONS", RequestPath: "*", HTTPVersion: "HTTP/1.1"}
+	assert.Equal(t, "*", h.Path())
+
+	// truncated or invalid paths are returned as much as possible
+	h = HTTPRequest{RequestMethod: "GET", RequestPath: "/gfx/S%C3%83%C6%9", Truncated: true}
+	assert.Equal(t, "/gfx/S%C3%83%C6%9", h.Path())
+	h.RequestPath = "/a%20b?x=%zz&y=1"
+	assert.Equal(t, "/a b", h.Path())
+	assert.Equal(t, url.Values{"y": {"1"}}, h.Query())
+
+	h = HTTPRequest{RequestMethod: "ERR", RequestPath: "<BADREQ>", HTTPVersion: "HTTP/0.0", BadReq: true}
+	_, err = h.URL()
+	assert.Error(t, err)
+	assert.Equal(t, "<BADREQ>", h.Path())
+	assert.Empty(t, h.Query())
+}
+
+func TestRequestProto(t *testing.T) {
+	for v, expected := range map[string][3]int{
+		"HTTP/1.0": {1, 0, 1},
+		"HTTP/1.1": {1, 1, 1},
+		"HTTP/2.0": {2, 0, 1},
+		"HTTP/2":   {2, 0, 1},
+		"HTTP/3":   {3, 0, 1},
+		"HTTP/":    {0, 0, 0},
+		"HTTP/x.1": {0, 0, 0},
+		"RTSP/1.0": {0, 0, 0},
+	} {
+		major, minor, ok := HTTPRequest{HTTPVersion: v}.Proto()
+		assert.Equal(t, expected, [3]int{major, minor, map[bool]int{true: 1}[ok]}, v)
+	}
+	assert.Equal(t, "HTTP/2", HTTPRequest{HTTPVersion: "HTTP/2.0"}.ProtoString())
+	assert.Equal(t, "HTTP/3", HTTPRequest{HTTPVersion: "HTTP/3"}.ProtoString())
+	assert.Equal(t, "HTTP/1.1", HTTPRequest{HTTPVersion: "HTTP/1.1"}.ProtoString())
+	_, _, ok := HTTPRequest{HTTPVersion: "HTTP/0.0", BadReq: true}.Proto()
+	assert.False(t, ok)
+
+	assert.True(t, HTTPRequest{ServerName: "<NOSRV>"}.NoServer())
+	assert.False(t, HTTPRequest{ServerName: "app1"}.NoServer())
+}
+
+func TestRequestURLFromLog(t *testing.T) {
+	lines, err := readLines("t-data/haproxy_log")
+	require.NoError(t, err)
+	for _, line := range lines {
+		r, err := DecodeHTTPLog(line)
+		require.NoError(t, err)
+		if r.BadReq {
+			continue
+		}
+		assert.NotEmpty(t, r.Path(), line)
+		_, _, ok := r.Proto()
+		assert.True(t, ok, line)
+	}
+}
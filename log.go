@@ -0,0 +1,51 @@
+package haproxy
+
+import (
+	"errors"
+)
+
+var errNotHaproxyLog = errors.New("input is not a haproxy log line")
+
+// RawLog is haproxy log line that is not a request log, like alerts or server state changes
+type RawLog struct {
+	PID     int          `json:"pid"`
+	Message string       `json:"message"`
+	Syslog  SyslogHeader `json:"syslog"`
+}
+
+// DecodeLog detects type of haproxy log line and decodes it.
+// Returned value is one of HTTPRequest (also from JSON and CBOR encoded lines), TCPRequest, an event (ServerStateEvent, NoServerEvent, ProxyEvent,
+// ConnectionErrorEvent, SPOEEvent) or RawLog (for any other line haproxy sent).
+// Lines that are neither HTTP nor TCP log are only accepted with syslog tag as otherwise there is no way to tell they came from haproxy
+func DecodeLog(s string) (interface{}, error) {
+	return HTTPLogV15.DecodeLog(s)
+}
+
+// DecodeLog is DecodeLog with HTTP log lines decoded in this layout
+func (v HTTPLogVersion) DecodeLog(s string) (interface{}, error) {
+	return HTTPLogDecoder{Version: v}.DecodeLog(s)
+}
+
+// DecodeLog is DecodeLog with HTTP log lines (including JSON and CBOR ones) decoded with decoder's settings
+func (d HTTPLogDecoder) DecodeLog(s string) (interface{}, error) {
+	hdr, msg, err := DecodeSyslog(s)
+	if err != nil {
+		return nil, err
+	}
+	if haproxyRegex.MatchString(msg) {
+		return d.Decode(s)
+	}
+	if haproxyTCPRegex.MatchString(msg) {
+		return DecodeTCPLog(s)
+	}
+	if decode := looksStructured(msg); decode != nil {
+		return decode(s, d.Captures)
+	}
+	if hdr.Tag == "" {
+		return nil, errNotHaproxyLog
+	}
+	if ev, err := decodeEvent(hdr, msg); ev != nil || err != nil {
+		return ev, err
+	}
+	return RawLog{PID: hdr.PID, Message: msg, Syslog: hdr}, nil
+}
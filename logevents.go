@@ -0,0 +1,254 @@
+package haproxy
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// events haproxy logs besides requests. All of them keep the original message so nothing is lost if decoding is incomplete
+
+// ServerStateEvent is server changing state, from health checks or admin actions
+//
+//	Server app/app1 is DOWN, reason: Layer4 connection problem, info: "Connection refused", check duration: 0ms. 0 active and 0 backup servers left. 0 sessions active, 0 requeued, 0 remaining in queue.
+type ServerStateEvent struct {
+	PID         int    `json:"pid"`
+	BackendName string `json:"backend_name"`
+	ServerName  string `json:"server_name"`
+	Backup      bool   `json:"backup"`
+	// UP, DOWN, or whatever else haproxy said server is now (e.g. "DOWN for maintenance")
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+	Info   string `json:"info,omitempty"`
+	// check status code (HTTP status for L7 checks), 0 if not reported
+	Code int `json:"code,omitempty"`
+	// -1 if not reported
+	CheckDurationMs int `json:"check_duration_ms"`
+	// servers left in the backend after the change, -1 if not reported
+	ActiveServers int          `json:"active_servers"`
+	BackupServers int          `json:"backup_servers"`
+	Message       string       `json:"message"`
+	Syslog        SyslogHeader `json:"syslog"`
+}
+
+// NoServerEvent is backend losing its last server
+//
+//	backend app has no server available!
+type NoServerEvent struct {
+	PID         int          `json:"pid"`
+	BackendName string       `json:"backend_name"`
+	Message     string       `json:"message"`
+	Syslog      SyslogHeader `json:"syslog"`
+}
+
+// ProxyEvent is proxy starting or stopping
+//
+//	Proxy app started.
+//	Stopping backend app in 0 ms.
+//	Proxy app stopped (cumulated conns: FE: 0, BE: 12).
+type ProxyEvent struct {
+	PID       int    `json:"pid"`
+	ProxyName string `json:"proxy_name"`
+	// started, stopping or stopped
+	State string `json:"state"`
+	// set for stopping
+	StopInMs int `json:"stop_in_ms,omitempty"`
+	// set for stopped
+	FrontendConns uint64       `json:"frontend_conns,omitempty"`
+	BackendConns  uint64       `json:"backend_conns,omitempty"`
+	Message       string       `json:"message"`
+	Syslog        SyslogHeader `json:"syslog"`
+}
+
+// ConnectionErrorEvent is connection that failed before request could be logged, usually SSL handshake failure
+//
+//	10.0.0.1:42312 [12/Aug/2022:13:18:51.161] https/1: SSL handshake failure
+type ConnectionErrorEvent struct {
+	TS           int64        `json:"ts_us"`
+	PID          int          `json:"pid"`
+	ClientIP     string       `json:"client_ip"`
+	ClientPort   uint16       `json:"client_port"`
+	FrontendName string       `json:"frontend_name"`
+	BindName     string       `json:"bind_name"`
+	Error        string       `json:"error"`
+	Message      string       `json:"message"`
+	Syslog       SyslogHeader `json:"syslog"`
+}
+
+// SPOEEvent is SPOE agent processing event or group of messages (with `option spop-check`/`log` in SPOE config)
+//
+//	SPOE: [agent] <EVENT:on-frontend-http-request> sid=3 st=2 -1/-1/-1/-1/0 0/0 0/0 4/4
+type SPOEEvent struct {
+	PID   int    `json:"pid"`
+	Agent string `json:"agent"`
+	// EVENT or GROUP
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	StreamID uint64 `json:"stream_id"`
+	// 0 is success, see SPOE docs for the rest
+	Status int `json:"status"`
+	// aborted/unknown are -1
+	RequestMs  int `json:"request_ms"`
+	QueueMs    int `json:"queue_ms"`
+	WaitingMs  int `json:"waiting_ms"`
+	ResponseMs int `json:"response_ms"`
+	ProcessMs  int `json:"process_ms"`
+	// agent counters
+	Idles     uint64       `json:"idles"`
+	Applets   uint64       `json:"applets"`
+	Sending   uint64       `json:"sending"`
+	Waiting   uint64       `json:"waiting"`
+	Errors    uint64       `json:"errors"`
+	Processed uint64       `json:"processed"`
+	Message   string       `json:"message"`
+	Syslog    SyslogHeader `json:"syslog"`
+}
+
+var serverStateRegex = regexp.MustCompile(`^(Backup )?Server (\S+?)/(\S+) (?:is (?:going )?|was \S+ and now )(.+?)(?:, reason: |\.|$)`)
+var serverReasonRegex = regexp.MustCompile(`, reason: (.+?)(?:, code: |, info: |, check duration: |\. \d+ active|$)`)
+var serverInfoRegex = regexp.MustCompile(`, info: "(.*?)"(?:, check duration: |\. \d+ active|$)`)
+var serverCodeRegex = regexp.MustCompile(`, code: (\d+)`)
+var serverCheckDurationRegex = regexp.MustCompile(`, check duration: (\d+)ms`)
+var serverCountRegex = regexp.MustCompile(`\. (\d+) active and (\d+) backup servers`)
+var noServerRegex = regexp.MustCompile(`^(?:backend|proxy) (\S+) has no server available!`)
+var proxyStartedRegex = regexp.MustCompile(`^(?:Proxy|Frontend|Backend|Listener) (\S+) started\.`)
+var proxyStoppedRegex = regexp.MustCompile(`^(?:Proxy|Frontend|Backend|Listener) (\S+) stopped \(cumulated conns: FE: (\d+), BE: (\d+)\)\.`)
+var proxyStoppingRegex = regexp.MustCompile(`^Stopping (?:proxy|frontend|backend|listener) (\S+) in (\d+) ms\.`)
+var connErrorRegex = regexp.MustCompile(`^(.+?):(\d+) \[(.+?)\] (\S+?)/(\S+): (.+?)\s*$`)
+var spoeRegex = regexp.MustCompile(`^SPOE: \[(.+?)\] <(\w+):(.+?)> sid=(\d+) st=(-?\d+) (-?\d+)/(-?\d+)/(-?\d+)/(-?\d+)/(-?\d+) (\d+)/(\d+) (\d+)/(\d+) (\d+)/(\d+)\s*$`)
+
+// decodeEvent decodes message part of event line, returns nil if it isn't any known event.
+// Lines that only look like an event (haproxy has more server messages than these, or it is not haproxy at all) are nil too, so they end up as RawLog
+func decodeEvent(hdr SyslogHeader, msg string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(msg, "SPOE: "):
+		return decodeSPOEEvent(hdr, msg)
+	case strings.HasPrefix(msg, "Server ") || strings.HasPrefix(msg, "Backup Server "):
+		return decodeServerStateEvent(hdr, msg)
+	}
+	if m := noServerRegex.FindStringSubmatch(msg); m != nil {
+		return NoServerEvent{PID: hdr.PID, BackendName: m[1], Message: msg, Syslog: hdr}, nil
+	}
+	if m := proxyStartedRegex.FindStringSubmatch(msg); m != nil {
+		return ProxyEvent{PID: hdr.PID, ProxyName: m[1], State: "started", Message: msg, Syslog: hdr}, nil
+	}
+	if m := proxyStoppingRegex.FindStringSubmatch(msg); m != nil {
+		ms, err := strconv.Atoi(m[2])
+		return ProxyEvent{PID: hdr.PID, ProxyName: m[1], State: "stopping", StopInMs: ms, Message: msg, Syslog: hdr}, err
+	}
+	if m := proxyStoppedRegex.FindStringSubmatch(msg); m != nil {
+		ev := ProxyEvent{PID: hdr.PID, ProxyName: m[1], State: "stopped", Message: msg, Syslog: hdr}
+		fe, err := strconv.ParseUint(m[2], 10, 64)
+		if err != nil {
+			return ev, err
+		}
+		be, err := strconv.ParseUint(m[3], 10, 64)
+		ev.FrontendConns, ev.BackendConns = fe, be
+		return ev, err
+	}
+	if m := connErrorRegex.FindStringSubmatch(msg); m != nil {
+		return decodeConnErrorEvent(hdr, msg, m)
+	}
+	return nil, nil
+}
+
+func decodeServerStateEvent(hdr SyslogHeader, msg string) (interface{}, error) {
+	ev := ServerStateEvent{PID: hdr.PID, Message: msg, Syslog: hdr, CheckDurationMs: -1, ActiveServers: -1, BackupServers: -1}
+	m := serverStateRegex.FindStringSubmatch(msg)
+	if m == nil {
+		return nil, nil
+	}
+	ev.Backup = m[1] != ""
+	ev.BackendName = m[2]
+	ev.ServerName = m[3]
+	ev.State = m[4]
+	if m := serverReasonRegex.FindStringSubmatch(msg); m != nil {
+		ev.Reason = m[1]
+	}
+	if m := serverInfoRegex.FindStringSubmatch(msg); m != nil {
+		ev.Info = m[1]
+	}
+	var parse_err []error
+	var err error
+	if m := serverCodeRegex.FindStringSubmatch(msg); m != nil {
+		ev.Code, err = strconv.Atoi(m[1])
+		parse_err = append(parse_err, err)
+	}
+	if m := serverCheckDurationRegex.FindStringSubmatch(msg); m != nil {
+		ev.CheckDurationMs, err = strconv.Atoi(m[1])
+		parse_err = append(parse_err, err)
+	}
+	if m := serverCountRegex.FindStringSubmatch(msg); m != nil {
+		ev.ActiveServers, err = strconv.Atoi(m[1])
+		parse_err = append(parse_err, err)
+		ev.BackupServers, err = strconv.Atoi(m[2])
+		parse_err = append(parse_err, err)
+	}
+	for _, err := range parse_err {
+		if err != nil {
+			return ev, err
+		}
+	}
+	return ev, nil
+}
+
+// decodeConnErrorEvent returns nil if port or date don't parse, pattern is loose enough to match other things
+func decodeConnErrorEvent(hdr SyslogHeader, msg string, m []string) (interface{}, error) {
+	ev := ConnectionErrorEvent{
+		PID:          hdr.PID,
+		ClientIP:     m[1],
+		FrontendName: m[4],
+		BindName:     m[5],
+		Error:        m[6],
+		Message:      msg,
+		Syslog:       hdr,
+	}
+	port, err := strconv.ParseUint(m[2], 10, 16)
+	if err != nil {
+		return nil, nil
+	}
+	ev.ClientPort = uint16(port)
+	ts, err := decodeTs(m[3])
+	if err != nil {
+		return nil, nil
+	}
+	ev.TS = ts.UnixMicro()
+	return ev, nil
+}
+
+func decodeSPOEEvent(hdr SyslogHeader, msg string) (interface{}, error) {
+	ev := SPOEEvent{PID: hdr.PID, Message: msg, Syslog: hdr}
+	m := spoeRegex.FindStringSubmatch(msg)
+	if m == nil {
+		return nil, nil
+	}
+	ev.Agent = m[1]
+	ev.Type = m[2]
+	ev.Name = m[3]
+	var parse_err []error
+	var err error
+	ev.StreamID, err = strconv.ParseUint(m[4], 10, 64)
+	parse_err = append(parse_err, err)
+	ev.Status, err = strconv.Atoi(m[5])
+	parse_err = append(parse_err, err)
+	for i, field := range []*int{&ev.RequestMs, &ev.QueueMs, &ev.WaitingMs, &ev.ResponseMs, &ev.ProcessMs} {
+		*field, err = strconv.Atoi(m[6+i])
+		parse_err = append(parse_err, err)
+	}
+	for i, field := range []*uint64{&ev.Idles, &ev.Applets, &ev.Sending, &ev.Waiting, &ev.Errors, &ev.Processed} {
+		*field, err = strconv.ParseUint(m[11+i], 10, 64)
+		parse_err = append(parse_err, err)
+	}
+	for _, err := range parse_err {
+		if err != nil {
+			return ev, err
+		}
+	}
+	return ev, nil
+}
+
+// Timestamp returns time of the failed connection
+func (e ConnectionErrorEvent) Timestamp() time.Time {
+	return time.UnixMicro(e.TS)
+}
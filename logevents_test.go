@@ -0,0 +1,139 @@
+package haproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeServerStateEvent(t *testing.T) {
+	out, err := DecodeLog(`<157>Aug 12 13:31:41 haproxy[1806]: Server local-3001/local-3001 is UP, reason: Layer7 check passed, code: 200, check duration: 0ms. 1 active and 0 backup servers online. 0 sessions requeued, 0 total in queue.`)
+	require.NoError(t, err)
+	require.IsType(t, ServerStateEvent{}, out)
+	ev := out.(ServerStateEvent)
+	assert.Equal(t, 1806, ev.PID)
+	assert.Equal(t, "local-3001", ev.BackendName)
+	assert.Equal(t, "local-3001", ev.ServerName)
+	assert.Equal(t, "UP", ev.State)
+	assert.Equal(t, "Layer7 check passed", ev.Reason)
+	assert.Equal(t, 200, ev.Code)
+	assert.Equal(t, 0, ev.CheckDurationMs)
+	assert.Equal(t, 1, ev.ActiveServers)
+	assert.Equal(t, 0, ev.BackupServers)
+	assert.False(t, ev.Backup)
+
+	out, err = DecodeLog(`<153>Aug 12 13:35:02 haproxy[1806]: Backup Server app/app-bk is DOWN, reason: Layer4 connection problem, info: "Connection refused", check duration: 12ms. 2 active and 0 backup servers left. 0 sessions active, 0 requeued, 0 remaining in queue.`)
+	require.NoError(t, err)
+	ev = out.(ServerStateEvent)
+	assert.True(t, ev.Backup)
+	assert.Equal(t, "app", ev.BackendName)
+	assert.Equal(t, "app-bk", ev.ServerName)
+	assert.Equal(t, "DOWN", ev.State)
+	assert.Equal(t, "Layer4 connection problem", ev.Reason)
+	assert.Equal(t, "Connection refused", ev.Info)
+	assert.Equal(t, 0, ev.Code)
+	assert.Equal(t, 12, ev.CheckDurationMs)
+	assert.Equal(t, 2, ev.ActiveServers)
+
+	out, err = DecodeLog(`<153>Aug 12 13:35:02 haproxy[1806]: Server app/app1 is going DOWN for maintenance. 1 active and 0 backup servers left. 0 sessions active, 0 requeued, 0 remaining in queue.`)
+	require.NoError(t, err)
+	ev = out.(ServerStateEvent)
+	assert.Equal(t, "DOWN for maintenance", ev.State)
+	assert.Equal(t, -1, ev.CheckDurationMs)
+	assert.Equal(t, 1, ev.ActiveServers)
+}
+
+func TestDecodeProxyEvents(t *testing.T) {
+	out, err := DecodeLog(`<152>Aug 12 13:35:02 haproxy[1806]: backend app has no server available!`)
+	require.NoError(t, err)
+	require.IsType(t, NoServerEvent{}, out)
+	assert.Equal(t, "app", out.(NoServerEvent).BackendName)
+
+	out, err = DecodeLog(`<133>Aug 12 13:35:02 haproxy[1806]: Proxy web started.`)
+	require.NoError(t, err)
+	require.IsType(t, ProxyEvent{}, out)
+	assert.Equal(t, ProxyEvent{PID: 1806, ProxyName: "web", State: "started", Message: "Proxy web started.", Syslog: out.(ProxyEvent).Syslog}, out)
+
+	out, err = DecodeLog(`<132>Aug 12 13:35:02 haproxy[1806]: Stopping backend app in 2000 ms.`)
+	require.NoError(t, err)
+	assert.Equal(t, "stopping", out.(ProxyEvent).State)
+	assert.Equal(t, 2000, out.(ProxyEvent).StopInMs)
+
+	out, err = DecodeLog(`<132>Aug 12 13:35:04 haproxy[1806]: Proxy app stopped (cumulated conns: FE: 0, BE: 1234).`)
+	require.NoError(t, err)
+	assert.Equal(t, "stopped", out.(ProxyEvent).State)
+	assert.Equal(t, "app", out.(ProxyEvent).ProxyName)
+	assert.EqualValues(t, 0, out.(ProxyEvent).FrontendConns)
+	assert.EqualValues(t, 1234, out.(ProxyEvent).BackendConns)
+}
+
+func TestDecodeConnectionErrorEvent(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	for _, msg := range []string{"Connection error during SSL handshake", "SSL handshake failure"} {
+		out, err := DecodeLog(`<150>Aug 12 13:18:51 haproxy[1806]: 10.0.0.1:42312 [12/Aug/2022:13:18:51.161] https/1: ` + msg)
+		require.NoError(t, err)
+		require.IsType(t, ConnectionErrorEvent{}, out)
+		ev := out.(ConnectionErrorEvent)
+		assert.Equal(t, "10.0.0.1", ev.ClientIP)
+		assert.EqualValues(t, 42312, ev.ClientPort)
+		assert.Equal(t, "https", ev.FrontendName)
+		assert.Equal(t, "1", ev.BindName)
+		assert.Equal(t, msg, ev.Error)
+		assert.Equal(t, time.Date(2022, 8, 12, 13, 18, 51, 161000000, time.UTC), ev.Timestamp().UTC())
+	}
+	out, err := DecodeLog(`<150>Aug 12 13:18:51 haproxy[1806]: [::1]:42312 [12/Aug/2022:13:18:51.161] https/127.0.0.1:443: Timeout during SSL handshake`)
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]", out.(ConnectionErrorEvent).ClientIP)
+	assert.Equal(t, "127.0.0.1:443", out.(ConnectionErrorEvent).BindName)
+}
+
+func TestDecodeEventFallback(t *testing.T) {
+	// other server messages haproxy has, and lines that only look like events
+	for _, msg := range []string{
+		"Server app/srv1 administratively READY thanks to valid DNS answer.",
+		"Server app/srv1 ('db.local') is UP/READY (resolves again).",
+		"SPOE: [agent] something else",
+		"host:1 [x] a/b: c",
+	} {
+		out, err := DecodeLog(`<150>Aug 12 13:18:51 haproxy[1806]: ` + msg)
+		require.NoError(t, err, msg)
+		require.IsType(t, RawLog{}, out, msg)
+		assert.Equal(t, msg, out.(RawLog).Message)
+	}
+	// without syslog tag there is no telling it came from haproxy
+	for _, line := range []string{"Server room is hot", "host:1 [x] a/b: c", "Proxy web started."} {
+		_, err := DecodeLog(line)
+		assert.Error(t, err, line)
+		assert.ErrorIs(t, err, errNotHaproxyLog)
+	}
+}
+
+func TestDecodeSPOEEvent(t *testing.T) {
+	lines, err := readLines("t-data/haproxy_log_spoa")
+	require.NoError(t, err)
+	var events []SPOEEvent
+	for _, line := range lines {
+		out, err := DecodeLog(line)
+		require.NoError(t, err, line)
+		if ev, ok := out.(SPOEEvent); ok {
+			events = append(events, ev)
+		}
+	}
+	require.Len(t, events, 6)
+	ev := events[0]
+	assert.Equal(t, "hauth", ev.Agent)
+	assert.Equal(t, "EVENT", ev.Type)
+	assert.Equal(t, "on-frontend-http-request", ev.Name)
+	assert.EqualValues(t, 3, ev.StreamID)
+	assert.Equal(t, 2, ev.Status)
+	assert.Equal(t, []int{-1, -1, -1, -1, 0}, []int{ev.RequestMs, ev.QueueMs, ev.WaitingMs, ev.ResponseMs, ev.ProcessMs})
+	assert.EqualValues(t, 4, ev.Errors)
+	assert.EqualValues(t, 4, ev.Processed)
+	assert.EqualValues(t, 9, events[5].Processed)
+
+	out, err := DecodeLog(`<156>Aug 12 13:18:51 haproxy[1806]: SPOE: [hauth] something new`)
+	require.NoError(t, err)
+	assert.IsType(t, RawLog{}, out)
+}
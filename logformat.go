@@ -0,0 +1,481 @@
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log formats haproxy uses for `option httplog` and `option tcplog`
+// https://cbonte.github.io/haproxy-dconv/2.8/configuration.html#8.2.3
+const (
+	// HAProxy 1.7+ `option httplog`
+	HTTPLogFormat = `%ci:%cp [%tr] %ft %b/%s %TR/%Tw/%Tc/%Tr/%Ta %ST %B %CC %CS %tsc %ac/%fc/%bc/%sc/%rc %sq/%bq %hr %hs %{+Q}r`
+	// HAProxy 1.5/1.6 `option httplog`
+	HTTPLogFormat15 = `%ci:%cp [%t] %ft %b/%s %Tq/%Tw/%Tc/%Tr/%Tt %ST %B %CC %CS %tsc %ac/%fc/%bc/%sc/%rc %sq/%bq %hr %hs %{+Q}r`
+	// `option tcplog`
+	TCPLogFormat = `%ci:%cp [%t] %ft %b/%s %Tw/%Tc/%Tt %B %ts %ac/%fc/%bc/%sc/%rc %sq/%bq`
+)
+
+const haproxyTimeFormatTZ = "02/Jan/2006:15:04:05 -0700"
+
+// LogFormat is compiled haproxy `log-format` definition that can decode lines it produced
+type LogFormat struct {
+	format string
+	re     *regexp.Regexp
+	fields []logFormatField
+	// literal text around fields, literals[i] goes before fields[i], last one after all fields
+	literals []string
+	// timer layout, empty if format has no timers
+	version HTTPLogVersion
+	// if set, %hr/%hs captures are decoded into RequestHeaders and ResponseHeaders, see HTTPLogDecoder
+	Captures CaptureLayout
+}
+
+type logFormatField struct {
+	// variable name without %, sample fetches are kept with brackets like [var(txn.x)]
+	name    string
+	quoted  bool
+	escaped bool
+	hex     bool
+	// skipped along with preceding space when empty
+	optional bool
+}
+
+// numeric log-format variables
+var logFormatNumeric = map[string]bool{
+	"Tq": true, "TR": true, "Tw": true, "Tc": true, "Tr": true, "Ta": true, "Tt": true, "Th": true, "Ti": true, "Td": true, "Tu": true,
+	"ST": true, "B": true, "U": true, "ac": true, "fc": true, "bc": true, "sc": true, "rc": true, "sq": true, "bq": true,
+	"pid": true, "rt": true, "lc": true, "cp": true, "fp": true, "bp": true, "sp": true, "ms": true, "Ts": true,
+}
+
+// date log-format variables
+var logFormatDates = map[string]string{
+	"t":   `\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2}\.\d{3}`,
+	"tr":  `\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2}\.\d{3}`,
+	"T":   `\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}`,
+	"Tl":  `\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}`,
+	"trg": `\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}`,
+	"trl": `\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}`,
+}
+
+// CompileLogFormat compiles haproxy log-format definition, as written in config
+// (surrounding quotes and backslash-escaped spaces are accepted) into decoder.
+// Supported flags are +Q (quoted), +E (escaped) and +X (hexadecimal), either per variable (%{+Q}r)
+// or for all following ones (%{+Q}o)
+func CompileLogFormat(format string) (*LogFormat, error) {
+	f := &LogFormat{format: format}
+	format = strings.TrimSpace(format)
+	if len(format) >= 2 && format[0] == '"' && format[len(format)-1] == '"' {
+		format = format[1 : len(format)-1]
+	}
+	var re strings.Builder
+	re.WriteString(`^\s*`)
+	var literal strings.Builder
+	var global logFormatField
+	flushLiteral := func() {
+		l := literal.String()
+		if len(f.fields) == 0 {
+			// leading separator, usually after %o
+			l = strings.TrimLeft(l, " ")
+		}
+		re.WriteString(regexp.QuoteMeta(l))
+		f.literals = append(f.literals, l)
+		literal.Reset()
+	}
+	for i := 0; i < len(format); i++ {
+		ch := format[i]
+		if ch == '\\' && i+1 < len(format) {
+			i++
+			literal.WriteByte(format[i])
+			continue
+		}
+		if ch != '%' {
+			literal.WriteByte(ch)
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			i++
+			literal.WriteByte('%')
+			continue
+		}
+		// variable
+		field := global
+		i++
+		if i < len(format) && format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, errors.New(fmt.Sprintf("unterminated flags at position %d", i))
+			}
+			for _, flag := range strings.Split(format[i+1:i+end], ",") {
+				field.setFlag(flag)
+			}
+			i += end + 1
+		}
+		if i >= len(format) {
+			return nil, errors.New("missing variable name at the end of format")
+		}
+		if format[i] == '[' {
+			depth := 0
+			start := i
+			for ; i < len(format); i++ {
+				if format[i] == '[' {
+					depth++
+				} else if format[i] == ']' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if depth != 0 {
+				return nil, errors.New(fmt.Sprintf("unterminated sample expression at position %d", start))
+			}
+			field.name = format[start : i+1]
+		} else {
+			start := i
+			for i < len(format) && isLetter(format[i]) {
+				i++
+			}
+			field.name = format[start:i]
+			i--
+		}
+		if field.name == "" {
+			return nil, errors.New(fmt.Sprintf("missing variable name at position %d", i))
+		}
+		// %o only carries flags for following variables
+		if field.name == "o" {
+			global = field
+			global.name = ""
+			continue
+		}
+		optional := field.name == "hr" || field.name == "hs" || field.name == "hrl" || field.name == "hsl"
+		if optional && strings.HasSuffix(literal.String(), " ") {
+			// captures are skipped along with separator if there are none configured
+			l := literal.String()
+			literal.Reset()
+			literal.WriteString(l[:len(l)-1])
+			flushLiteral()
+			re.WriteString(`(?: (` + field.pattern() + `))?`)
+			field.optional = true
+		} else {
+			flushLiteral()
+			re.WriteString(`(` + field.pattern() + `)`)
+		}
+		f.fields = append(f.fields, field)
+		switch field.name {
+		case "Tq", "Tt":
+			f.version = HTTPLogV15
+		case "TR", "Ta":
+			if f.version == "" {
+				f.version = HTTPLogV17
+			}
+		}
+	}
+	flushLiteral()
+	re.WriteString(`\s*$`)
+	var err error
+	f.re, err = regexp.Compile(re.String())
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// MustCompileLogFormat is CompileLogFormat that panics on error
+func MustCompileLogFormat(format string) *LogFormat {
+	f, err := CompileLogFormat(format)
+	if err != nil {
+		panic(fmt.Sprintf("log format %q: %s", format, err))
+	}
+	return f
+}
+
+// String returns format definition as passed to CompileLogFormat
+func (f *LogFormat) String() string {
+	return f.format
+}
+
+// DecodeFields decodes log line into map of variable name => value (unquoted and unescaped).
+// Syslog header, if present, is skipped
+func (f *LogFormat) DecodeFields(s string) (map[string]string, error) {
+	_, msg, err := DecodeSyslog(s)
+	if err != nil {
+		return nil, err
+	}
+	matches := f.re.FindStringSubmatch(msg)
+	if matches == nil {
+		return nil, errors.New("input not matching log format")
+	}
+	out := make(map[string]string, len(f.fields))
+	for i, field := range f.fields {
+		if _, ok := out[field.name]; ok && matches[i+1] == "" {
+			continue
+		}
+		out[field.name] = field.value(matches[i+1])
+	}
+	return out, nil
+}
+
+// Decode decodes log line into HTTPRequest. Variables that have no corresponding field are put in Extra
+func (f *LogFormat) Decode(s string) (HTTPRequest, error) {
+	var r HTTPRequest
+	var err error
+	var msg string
+	r.Syslog, msg, err = DecodeSyslog(s)
+	if err != nil {
+		return r, err
+	}
+	r.PID = r.Syslog.PID
+	r.LogVersion = f.version
+	matches := f.re.FindStringSubmatch(msg)
+	if matches == nil {
+		return r, errors.New("input not matching log format")
+	}
+	var ms = -1
+	var unixTs int64 = -1
+	for i, field := range f.fields {
+		if err := field.apply(&r, matches[i+1], &ms, &unixTs); err != nil {
+			return r, errors.New(fmt.Sprintf("can't decode %%%s [%s]: %s", field.name, matches[i+1], err))
+		}
+	}
+	finishLogFormat(&r, ms, unixTs, f.Captures)
+	return r, nil
+}
+
+// finishLogFormat fills fields that depend on more than one log-format variable
+// format might not have captures at all, so missing ones are not reported as mismatch
+func finishLogFormat(r *HTTPRequest, ms int, unixTs int64, captures CaptureLayout) {
+	if r.CapturedSamples != "" {
+		r.applyCaptureLayout(captures)
+	}
+	if r.TS == 0 && unixTs >= 0 {
+		r.TS = unixTs * 1000000
+		if ms >= 0 {
+			r.TS += int64(ms) * 1000
+		}
+	}
+}
+
+func (l *logFormatField) setFlag(flag string) {
+	switch strings.TrimSpace(flag) {
+	case "+Q":
+		l.quoted = true
+	case "-Q":
+		l.quoted = false
+	case "+E":
+		l.escaped = true
+	case "-E":
+		l.escaped = false
+	case "+X":
+		l.hex = true
+	case "-X":
+		l.hex = false
+	}
+}
+
+func (l logFormatField) pattern() string {
+	// haproxy never quotes numbers
+	if logFormatNumeric[l.name] {
+		if l.hex {
+			return `[0-9A-Fa-f]+|-`
+		}
+		return `[-+]?\d+|-`
+	}
+	if l.quoted {
+		if l.escaped {
+			return `"(?:[^"\\]|\\.)*"|-`
+		}
+		// request line can get truncated at the end of the line
+		return `"[^"]*"?|-`
+	}
+	if d, ok := logFormatDates[l.name]; ok {
+		return d
+	}
+	switch {
+	case l.name == "hr" || l.name == "hs" || l.name == "hrl" || l.name == "hsl":
+		return `\{[^}]*\}`
+	case l.name == "ts":
+		return `\S{2}`
+	case l.name == "tsc":
+		return `\S{4}`
+	case l.name == "r":
+		return `.*?`
+	case l.escaped:
+		return `(?:[^\s\\]|\\.)*`
+	case strings.HasPrefix(l.name, "["):
+		return `.*?`
+	}
+	return `\S*`
+}
+
+// value returns unquoted and unescaped value
+func (l logFormatField) value(s string) string {
+	if l.quoted && len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	if l.escaped && strings.Contains(s, `\`) {
+		var b strings.Builder
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			b.WriteByte(s[i])
+		}
+		s = b.String()
+	}
+	return s
+}
+
+func (l logFormatField) int(s string) (int, error) {
+	s = strings.TrimPrefix(s, "+")
+	if s == "-" {
+		return -1, nil
+	}
+	if l.hex {
+		v, err := strconv.ParseInt(s, 16, 64)
+		return int(v), err
+	}
+	return strconv.Atoi(s)
+}
+
+func (l logFormatField) uint(s string) (uint64, error) {
+	s = strings.TrimPrefix(s, "+")
+	if s == "-" {
+		return 0, nil
+	}
+	if l.hex {
+		return strconv.ParseUint(s, 16, 64)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func (l logFormatField) apply(r *HTTPRequest, raw string, ms *int, unixTs *int64) error {
+	var err error
+	var u uint64
+	v := l.value(raw)
+	switch l.name {
+	case "ci":
+		r.ClientIP = v
+	case "cp":
+		u, err = l.uint(v)
+		r.ClientPort = uint16(u)
+	case "t", "tr":
+		var ts time.Time
+		ts, err = decodeTs(v)
+		r.TS = ts.UnixMicro()
+	case "T", "Tl", "trg", "trl":
+		var ts time.Time
+		ts, err = time.Parse(haproxyTimeFormatTZ, v)
+		r.TS = ts.UnixMicro()
+	case "Ts":
+		var i int
+		i, err = l.int(v)
+		*unixTs = int64(i)
+	case "ms":
+		*ms, err = l.int(v)
+	case "ft":
+		if strings.HasSuffix(v, "~") {
+			r.ClientSSL = true
+			v = v[:len(v)-1]
+		}
+		r.FrontendName = v
+	case "f":
+		r.FrontendName = v
+	case "b":
+		r.BackendName = v
+	case "s":
+		r.ServerName = v
+	case "Tq":
+		r.RequestHeaderDurationMs, err = l.int(v)
+	case "TR":
+		r.RequestReceiveDurationMs, err = l.int(v)
+	case "Ta":
+		r.ActiveDurationMs, err = l.int(v)
+	case "Ti":
+		r.IdleDurationMs, err = l.int(v)
+	case "Tw":
+		r.QueueDurationMs, err = l.int(v)
+	case "Tc":
+		r.ServerConnDurationMs, err = l.int(v)
+	case "Tr":
+		r.ResponseHeaderDurationMs, err = l.int(v)
+	case "Tt":
+		r.TotalDurationMs, err = l.int(v)
+	case "ST":
+		var i int
+		i, err = l.int(v)
+		r.StatusCode = int16(i)
+	case "B":
+		r.BytesRead, err = l.uint(v)
+	case "CC":
+		r.CapturedRequestCookie = v
+	case "CS":
+		r.CapturedResponseCookie = v
+	case "ts", "tsc":
+		runes := []rune(v)
+		fields := []*rune{&r.TerminationReason, &r.SessionCloseState, &r.ClientPersistenceState, &r.PersistenceCookieState}
+		for i := range runes {
+			if i < len(fields) {
+				*fields[i] = runes[i]
+			}
+		}
+	case "ac":
+		u, err = l.uint(v)
+		r.TotalConn = uint(u)
+	case "fc":
+		u, err = l.uint(v)
+		r.FrontendConn = uint(u)
+	case "bc":
+		u, err = l.uint(v)
+		r.BackendConn = uint(u)
+	case "sc":
+		u, err = l.uint(v)
+		r.ServerConn = uint(u)
+	case "rc":
+		u, err = l.uint(v)
+		r.Retries = uint(u)
+	case "sq":
+		u, err = l.uint(v)
+		r.ServerQueue = uint(u)
+	case "bq":
+		u, err = l.uint(v)
+		r.BackendQueue = uint(u)
+	case "hr", "hs", "hrl", "hsl":
+		if v != "" {
+			r.CapturedSamples += " " + v
+		}
+	case "r":
+		if v == "-" {
+			break
+		}
+		if l.quoted {
+			err = decodeRequestLine(r, raw)
+		} else {
+			err = decodeRequestLine(r, `"`+v+`"`)
+		}
+	case "HM":
+		r.RequestMethod = v
+	case "HU", "HP":
+		r.RequestPath = v
+	case "HV":
+		r.HTTPVersion = v
+	case "ID":
+		r.UniqueID = v
+	case "pid":
+		r.PID, err = l.int(v)
+	default:
+		if r.Extra == nil {
+			r.Extra = make(map[string]string)
+		}
+		r.Extra[l.name] = v
+	}
+	return err
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
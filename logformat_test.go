@@ -0,0 +1,104 @@
+package haproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogFormatStandard(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	f, err := CompileLogFormat(HTTPLogFormat15)
+	require.NoError(t, err)
+	lines, err := readLines("t-data/haproxy_log")
+	require.NoError(t, err)
+	for _, line := range append(lines,
+		`<158>Sep  1 17:50:37 haproxy[1866]: 127.0.0.1:52320 [01/Sep/2022:17:50:37.898] default local-3001/<NOSRV> 0/-1/-1/-1/0 503 253 - - SC-- 1/1/0/0/0 0/0 {3001.localhost} {|} "GET /slow/default HTTP/1.1"`,
+	) {
+		expected, err := DecodeHTTPLog(line)
+		require.NoError(t, err)
+		out, err := f.Decode(line)
+		require.NoError(t, err, line)
+		assert.NotZero(t, out.TotalConn, line)
+		assert.Equal(t, expected, out, line)
+	}
+	out, err := f.Decode(`<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1_foobar~ backend_foobar-ssl/app3-backend 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/2 "POST /query/q/Sql HTTP/1.1"`)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1637, out.TotalConn)
+	assert.EqualValues(t, 7, out.FrontendConn)
+	assert.EqualValues(t, 5, out.BackendConn)
+	assert.EqualValues(t, 6, out.ServerConn)
+	assert.EqualValues(t, 0, out.Retries)
+	assert.EqualValues(t, 2, out.BackendQueue)
+	assert.True(t, out.ClientSSL)
+	assert.Equal(t, 'N', out.PersistenceCookieState)
+}
+
+func TestLogFormatCustom(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	f, err := CompileLogFormat(`"%ci:%cp\ [%tr]\ %ft\ %b/%s\ %ST\ %B\ %{+Q}[var(txn.user)]\ %ID\ %HM\ %{+Q}HU\ %HV 100%%"`)
+	require.NoError(t, err)
+	out, err := f.Decode(`<158>Sep  1 17:50:37 haproxy[1866]: 10.0.0.1:5000 [01/Sep/2022:17:50:37.898] web app/app1 302 120 "john doe" 0A0B:1234 GET "/login?x=1" HTTP/2.0 100%`)
+	require.NoError(t, err)
+	assert.Equal(t, 1866, out.PID)
+	assert.Equal(t, "10.0.0.1", out.ClientIP)
+	assert.EqualValues(t, 5000, out.ClientPort)
+	assert.Equal(t, int64(1662054637898000), out.TS)
+	assert.EqualValues(t, 302, out.StatusCode)
+	assert.Equal(t, "0A0B:1234", out.UniqueID)
+	assert.Equal(t, "GET", out.RequestMethod)
+	assert.Equal(t, "/login?x=1", out.RequestPath)
+	assert.Equal(t, "HTTP/2.0", out.HTTPVersion)
+	assert.Equal(t, map[string]string{"[var(txn.user)]": "john doe"}, out.Extra)
+
+	fields, err := f.DecodeFields(`10.0.0.1:5000 [01/Sep/2022:17:50:37.898] web app/app1 302 120 "john doe" - GET "/" HTTP/1.1 100%`)
+	require.NoError(t, err)
+	assert.Equal(t, "web", fields["ft"])
+	assert.Equal(t, "john doe", fields["[var(txn.user)]"])
+	assert.Equal(t, "-", fields["ID"])
+
+	_, err = f.Decode(`10.0.0.1:5000 [01/Sep/2022:17:50:37.898] web app/app1 302 120`)
+	assert.Error(t, err)
+}
+
+func TestLogFormatFlags(t *testing.T) {
+	f, err := CompileLogFormat(`%{+Q,+E}o %ci %[capture.req.hdr(0)] %{-Q}b %{+X}B`)
+	require.NoError(t, err)
+	out, err := f.Decode(`"10.0.0.1" "Mozilla \"quoted\"" app FF`)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", out.ClientIP)
+	assert.Equal(t, `Mozilla "quoted"`, out.Extra["[capture.req.hdr(0)]"])
+	assert.Equal(t, "app", out.BackendName)
+	assert.EqualValues(t, 255, out.BytesRead)
+
+	f, err = CompileLogFormat(TCPLogFormat)
+	require.NoError(t, err)
+	out, err = f.Decode(`<150>Oct 12 10:00:00 haproxy[1]: 10.0.0.1:5000 [12/Oct/2022:10:00:00.000] db db/pg1 0/0/+5000 1500 -- 1/1/0/0/0 0/0`)
+	require.NoError(t, err)
+	assert.Equal(t, 5000, out.TotalDurationMs)
+	assert.Equal(t, '-', out.TerminationReason)
+
+	_, err = CompileLogFormat(`%{+Q`)
+	assert.Error(t, err)
+	_, err = CompileLogFormat(`%[var(x)`)
+	assert.Error(t, err)
+	assert.Panics(t, func() { MustCompileLogFormat(`%`) })
+}
+
+func TestLogFormatOptionalCaptures(t *testing.T) {
+	f := MustCompileLogFormat(HTTPLogFormat)
+	base := `127.0.0.1:52320 [01/Sep/2022:17:50:37.898] default local/srv 0/0/1/2/3 200 253 - - ---- 1/1/0/0/0 0/0 `
+	out, err := f.Decode(base + `"GET / HTTP/1.1"`)
+	require.NoError(t, err)
+	assert.Equal(t, "", out.CapturedSamples)
+	out, err = f.Decode(base + `{a} "GET / HTTP/1.1"`)
+	require.NoError(t, err)
+	assert.Equal(t, " {a}", out.CapturedSamples)
+	out, err = f.Decode(base + `{a} {b|c} "GET / HTTP/1.1"`)
+	require.NoError(t, err)
+	assert.Equal(t, " {a} {b|c}", out.CapturedSamples)
+	assert.Equal(t, 3, out.ActiveDurationMs)
+	assert.Equal(t, 0, out.TotalDurationMs)
+}
@@ -0,0 +1,445 @@
+package haproxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// LogReaderConfig configures LogReader
+type LogReaderConfig struct {
+	// keep reading last file after reaching its end, reopening it when it gets rotated or truncated, like `tail -F`.
+	// Only works for uncompressed files
+	Follow bool
+	// how often followed file is checked for new data, defaults to 250ms
+	PollInterval time.Duration
+	// where to start, usually taken from LogRecord.Checkpoint() of last processed record
+	Start Checkpoint
+	// layout of default httplog lines, HTTPLogV17 for haproxy 1.7 and newer. Defaults to HTTPLogV15
+	HTTPLogVersion HTTPLogVersion
+	// captures from the config, used to fill RequestHeaders and ResponseHeaders. Captures are left alone if empty
+	CaptureLayout CaptureLayout
+	// called for lines that look like haproxy logs but can't be decoded, rec has position of the line.
+	// Such lines are skipped either way and counted in DecodeErrors()
+	OnError func(rec LogRecord, err error)
+}
+
+// Checkpoint is a position in the logs LogReader can resume from
+type Checkpoint struct {
+	// file name, empty for io.Reader. If set, files before it are skipped
+	Source string `json:"source"`
+	// offset in decompressed data
+	Offset int64 `json:"offset"`
+	// number of lines before Offset, so line numbers continue after resuming
+	Line int `json:"line"`
+	// inode of Source, used to find it after it was rotated (renamed). 0 if unknown
+	Inode uint64 `json:"inode,omitempty"`
+}
+
+// LogRecord is decoded log line with its position
+type LogRecord struct {
+	Request HTTPRequest
+	Source  string
+	// 1-based line number in Source
+	Line int
+	// offset of line start and end (including newline) in decompressed Source
+	Offset int64
+	End    int64
+	inode  uint64
+}
+
+// Checkpoint returns position right after this record
+func (r LogRecord) Checkpoint() Checkpoint {
+	return Checkpoint{Source: r.Source, Offset: r.End, Line: r.Line, Inode: r.inode}
+}
+
+// LogReader reads HTTP logs from files or readers, gzip, zstd and xz are detected and decompressed.
+// Lines that are not HTTP logs are skipped
+type LogReader struct {
+	cfg          LogReaderConfig
+	files        []string
+	cur          *logSource
+	skipped      int
+	decodeErrors int
+	closed       chan struct{}
+}
+
+type logSource struct {
+	name    string
+	inode   uint64
+	file    *os.File
+	r       *bufio.Reader
+	closer  io.Closer
+	offset  int64
+	line    int
+	pending string
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// NewLogReader reads logs from r. Follow setting is ignored
+func NewLogReader(r io.Reader, cfg LogReaderConfig) (*LogReader, error) {
+	if err := cfg.HTTPLogVersion.check(); err != nil {
+		return nil, err
+	}
+	lr := newLogReader(cfg)
+	src, err := newLogSource("", r, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := src.skipTo(cfg.Start); err != nil {
+		return nil, err
+	}
+	lr.cur = src
+	return lr, nil
+}
+
+// OpenLogs reads all files matching glob patterns, oldest (by modification time) first,
+// so rotated files like haproxy.log.2.gz, haproxy.log.1, haproxy.log are read in order.
+//
+// Start.Source is looked up by inode first, so reading resumes in haproxy.log.1 if haproxy.log was rotated since
+// the checkpoint. If the file is gone (or was compressed, which makes a new one) reading starts at the beginning
+// of the file now named Start.Source
+func OpenLogs(cfg LogReaderConfig, patterns ...string) (*LogReader, error) {
+	if err := cfg.HTTPLogVersion.check(); err != nil {
+		return nil, err
+	}
+	lr := newLogReader(cfg)
+	seen := make(map[string]bool)
+	type file struct {
+		name  string
+		mtime time.Time
+		inode uint64
+		size  int64
+	}
+	var files []file
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range matches {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			fi, err := os.Stat(name)
+			if err != nil {
+				return nil, err
+			}
+			if fi.IsDir() {
+				continue
+			}
+			files = append(files, file{name: name, mtime: fi.ModTime(), inode: fileInode(fi), size: fi.Size()})
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New(fmt.Sprintf("no files matching %s", strings.Join(patterns, ", ")))
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].mtime.Equal(files[j].mtime) {
+			return files[i].name < files[j].name
+		}
+		return files[i].mtime.Before(files[j].mtime)
+	})
+	for _, f := range files {
+		lr.files = append(lr.files, f.name)
+	}
+	start, resume := -1, false
+	if cfg.Start.Source != "" {
+		for i, f := range files {
+			if cfg.Start.Inode != 0 && f.inode == cfg.Start.Inode && f.size >= cfg.Start.Offset {
+				start, resume = i, true
+				break
+			}
+			if f.name == cfg.Start.Source {
+				start = i
+				// same file if inode is unknown or didn't change, otherwise it was rotated and this one is new
+				resume = cfg.Start.Inode == 0 || f.inode == 0 || f.inode == cfg.Start.Inode
+			}
+		}
+	}
+	if start >= 0 {
+		lr.files = lr.files[start:]
+	}
+	if err := lr.openNext(); err != nil {
+		return nil, err
+	}
+	if resume {
+		if err := lr.cur.skipTo(cfg.Start); err != nil {
+			lr.cur.close()
+			return nil, err
+		}
+	}
+	return lr, nil
+}
+
+func newLogReader(cfg LogReaderConfig) *LogReader {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 250 * time.Millisecond
+	}
+	return &LogReader{cfg: cfg, closed: make(chan struct{})}
+}
+
+// Next returns next HTTP log record. It returns io.EOF after the last one, or after Close when following
+func (l *LogReader) Next() (LogRecord, error) {
+	for {
+		select {
+		case <-l.closed:
+			l.files = nil
+			l.openNext()
+		default:
+		}
+		if l.cur == nil {
+			return LogRecord{}, io.EOF
+		}
+		line, rec, err := l.readLine()
+		if err == io.EOF {
+			if err := l.openNext(); err != nil {
+				return LogRecord{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return LogRecord{}, err
+		}
+		req, err := HTTPLogDecoder{Version: l.cfg.HTTPLogVersion, Captures: l.cfg.CaptureLayout}.DecodeLog(line)
+		if err == errNotHaproxyLog {
+			l.skipped++
+			continue
+		}
+		if err != nil {
+			l.decodeErrors++
+			if l.cfg.OnError != nil {
+				l.cfg.OnError(rec, err)
+			}
+			continue
+		}
+		if r, ok := req.(HTTPRequest); ok {
+			rec.Request = r
+			return rec, nil
+		}
+		l.skipped++
+	}
+}
+
+// Skipped returns number of lines that were not HTTP logs
+func (l *LogReader) Skipped() int {
+	return l.skipped
+}
+
+// DecodeErrors returns number of lines that looked like haproxy logs but failed to decode, they are not in Skipped()
+func (l *LogReader) DecodeErrors() int {
+	return l.decodeErrors
+}
+
+// Close stops reading, Next returns io.EOF afterwards. It can be called from other goroutine to interrupt following
+func (l *LogReader) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return nil
+}
+
+func (l *LogReader) readLine() (string, LogRecord, error) {
+	src := l.cur
+	for {
+		s, err := src.r.ReadString('\n')
+		src.pending += s
+		if err == nil || (err == io.EOF && src.pending != "" && !l.following()) {
+			line, rec := src.take()
+			return line, rec, nil
+		}
+		if err != io.EOF {
+			return "", LogRecord{}, err
+		}
+		if !l.following() {
+			return "", LogRecord{}, io.EOF
+		}
+		if err := l.waitForData(); err != nil {
+			return "", LogRecord{}, err
+		}
+		if l.cur != src && src.pending != "" {
+			// rotated with unterminated last line, it won't be finished in the new file
+			line, rec := src.take()
+			return line, rec, nil
+		}
+		// might have been reopened
+		src = l.cur
+	}
+}
+
+// take returns pending data as a line
+func (s *logSource) take() (string, LogRecord) {
+	rec := LogRecord{Source: s.name, Offset: s.offset, End: s.offset + int64(len(s.pending)), inode: s.inode}
+	line := s.pending
+	s.offset = rec.End
+	s.line++
+	rec.Line = s.line
+	s.pending = ""
+	return strings.TrimRight(line, "\r\n"), rec
+}
+
+// following is true when current source is the last file and should be followed
+func (l *LogReader) following() bool {
+	return l.cfg.Follow && l.cur.file != nil && len(l.files) == 0
+}
+
+// waitForData waits for followed file to grow, get rotated or truncated
+func (l *LogReader) waitForData() error {
+	select {
+	case <-l.closed:
+		return io.EOF
+	case <-time.After(l.cfg.PollInterval):
+	}
+	src := l.cur
+	fi, err := os.Stat(src.name)
+	if err != nil {
+		// rotated away and not recreated yet
+		return nil
+	}
+	cur, err := src.file.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(fi, cur) {
+		// rotated, old file might still have something left
+		if cur.Size() > src.offset+int64(len(src.pending)) {
+			return nil
+		}
+		f, err := os.Open(src.name)
+		if err != nil {
+			return nil
+		}
+		src.close()
+		l.cur = &logSource{name: src.name, inode: fileInode(fi), file: f, r: bufio.NewReader(f), closer: f}
+		return nil
+	}
+	if fi.Size() < src.offset+int64(len(src.pending)) {
+		// truncated
+		if _, err := src.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		src.r.Reset(src.file)
+		src.offset, src.line, src.pending = 0, 0, ""
+	}
+	return nil
+}
+
+func (l *LogReader) openNext() error {
+	if l.cur != nil {
+		l.cur.close()
+		l.cur = nil
+	}
+	if len(l.files) == 0 {
+		return nil
+	}
+	name := l.files[0]
+	l.files = l.files[1:]
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	src, err := newLogSource(name, f, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if fi, err := f.Stat(); err == nil {
+		src.inode = fileInode(fi)
+	}
+	l.cur = src
+	return nil
+}
+
+func newLogSource(name string, r io.Reader, f *os.File) (*logSource, error) {
+	src := &logSource{name: name}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(6)
+	var closers multiCloser
+	if f != nil {
+		closers = append(closers, f)
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, gz)
+		br = bufio.NewReader(gz)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		rc := zr.IOReadCloser()
+		closers = append(closers, rc)
+		br = bufio.NewReader(rc)
+	case bytes.HasPrefix(magic, xzMagic):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(xr)
+	default:
+		// plain file can be followed
+		src.file = f
+	}
+	src.r = br
+	src.closer = closers
+	return src, nil
+}
+
+// skipTo moves to checkpoint, seeking if possible
+func (s *logSource) skipTo(c Checkpoint) error {
+	if c.Offset <= 0 {
+		return nil
+	}
+	if s.file != nil {
+		if _, err := s.file.Seek(c.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		s.r.Reset(s.file)
+	} else if _, err := io.CopyN(io.Discard, s.r, c.Offset); err != nil {
+		return err
+	}
+	s.offset = c.Offset
+	s.line = c.Line
+	return nil
+}
+
+func (s *logSource) close() {
+	if s.closer != nil {
+		s.closer.Close()
+	}
+}
+
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var err error
+	for i := len(m) - 1; i >= 0; i-- {
+		if e := m[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
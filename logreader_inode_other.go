@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package haproxy
+
+import "os"
+
+// no inode in FileInfo, checkpoints fall back to file names
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
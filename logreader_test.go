@@ -0,0 +1,284 @@
+package haproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+func readAll(t *testing.T, r *LogReader) []LogRecord {
+	t.Helper()
+	var out []LogRecord
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return out
+		}
+		require.NoError(t, err)
+		out = append(out, rec)
+	}
+}
+
+func TestLogReaderCompression(t *testing.T) {
+	data, err := os.ReadFile("t-data/haproxy_log_spoa")
+	require.NoError(t, err)
+	var gz, zs, x bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(data)
+	gw.Close()
+	zw, err := zstd.NewWriter(&zs)
+	require.NoError(t, err)
+	zw.Write(data)
+	zw.Close()
+	xw, err := xz.NewWriter(&x)
+	require.NoError(t, err)
+	xw.Write(data)
+	xw.Close()
+
+	for name, in := range map[string][]byte{"plain": data, "gzip": gz.Bytes(), "zstd": zs.Bytes(), "xz": x.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewLogReader(bytes.NewReader(in), LogReaderConfig{})
+			require.NoError(t, err)
+			recs := readAll(t, r)
+			require.Len(t, recs, 6)
+			// SPOE and server state lines
+			assert.Equal(t, 7, r.Skipped())
+			assert.Equal(t, 2, recs[0].Line)
+			assert.Equal(t, "/", recs[0].Request.RequestPath)
+			// offsets point at the line in decompressed data
+			for _, rec := range recs {
+				line := string(data[rec.Offset:rec.End])
+				assert.Contains(t, line, fmt.Sprintf("[%d]", rec.Request.PID))
+				if rec.End < int64(len(data)) {
+					assert.Equal(t, byte('\n'), line[len(line)-1])
+				}
+			}
+		})
+	}
+	_, err = NewLogReader(bytes.NewReader(append([]byte{0x1f, 0x8b}, data...)), LogReaderConfig{})
+	assert.Error(t, err)
+}
+
+func TestLogReaderVersion(t *testing.T) {
+	data, err := os.ReadFile("t-data/haproxy_log_spoa")
+	require.NoError(t, err)
+	r, err := NewLogReader(bytes.NewReader(data), LogReaderConfig{HTTPLogVersion: HTTPLogV17})
+	require.NoError(t, err)
+	recs := readAll(t, r)
+	require.Len(t, recs, 6)
+	for _, rec := range recs {
+		assert.Equal(t, HTTPLogV17, rec.Request.LogVersion)
+		assert.Equal(t, 0, rec.Request.TotalDurationMs)
+	}
+	_, err = NewLogReader(bytes.NewReader(data), LogReaderConfig{HTTPLogVersion: "2.8"})
+	assert.Error(t, err)
+}
+
+func TestLogReaderFiles(t *testing.T) {
+	data, err := os.ReadFile("t-data/haproxy_log")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(data[:len(data)/2])
+	gw.Close()
+	now := time.Now()
+	// logrotate naming, newest file has no suffix
+	files := []struct {
+		name string
+		data []byte
+		age  time.Duration
+	}{
+		{"haproxy.log", data, 0},
+		{"haproxy.log.1", data, time.Hour},
+		{"haproxy.log.2.gz", gz.Bytes(), 2 * time.Hour},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		require.NoError(t, os.WriteFile(path, f.data, 0o600))
+		require.NoError(t, os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)))
+	}
+	r, err := OpenLogs(LogReaderConfig{}, filepath.Join(dir, "haproxy.log*"), filepath.Join(dir, "haproxy.log"))
+	require.NoError(t, err)
+	recs := readAll(t, r)
+	require.NotEmpty(t, recs)
+	assert.Equal(t, filepath.Join(dir, "haproxy.log.2.gz"), recs[0].Source)
+	assert.Equal(t, filepath.Join(dir, "haproxy.log"), recs[len(recs)-1].Source)
+	// last line has no newline
+	assert.Equal(t, 28, recs[len(recs)-1].Line)
+	assert.EqualValues(t, len(data), recs[len(recs)-1].End)
+
+	// resume from the middle of second file
+	var cp Checkpoint
+	total := 0
+	for _, rec := range recs {
+		if rec.Source == filepath.Join(dir, "haproxy.log.1") && rec.Line == 10 {
+			cp = rec.Checkpoint()
+			total = 0
+		}
+		total++
+	}
+	r, err = OpenLogs(LogReaderConfig{Start: cp}, filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	resumed := readAll(t, r)
+	require.Len(t, resumed, total-1)
+	assert.Equal(t, 11, resumed[0].Line)
+	assert.Equal(t, cp.Offset, resumed[0].Offset)
+
+	_, err = OpenLogs(LogReaderConfig{}, filepath.Join(dir, "nope*"))
+	assert.Error(t, err)
+}
+
+func TestLogReaderRotatedCheckpoint(t *testing.T) {
+	data, err := os.ReadFile("t-data/haproxy_log")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "haproxy.log")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	r, err := OpenLogs(LogReaderConfig{}, path)
+	require.NoError(t, err)
+	var cp Checkpoint
+	for i := 0; i < 10; i++ {
+		rec, err := r.Next()
+		require.NoError(t, err)
+		cp = rec.Checkpoint()
+	}
+	r.Close()
+	if cp.Inode == 0 {
+		t.Skip("no inodes on this platform")
+	}
+
+	// rotated after the checkpoint was taken
+	require.NoError(t, os.Rename(path, path+".1"))
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(path+".1", old, old))
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	r, err = OpenLogs(LogReaderConfig{Start: cp}, path+"*")
+	require.NoError(t, err)
+	recs := readAll(t, r)
+	require.NotEmpty(t, recs)
+	assert.Equal(t, path+".1", recs[0].Source)
+	assert.Equal(t, cp.Line+1, recs[0].Line)
+	assert.Equal(t, cp.Offset, recs[0].Offset)
+	assert.Equal(t, path, recs[len(recs)-1].Source)
+
+	// rotated file is gone, new one is read from the start instead of resuming in the middle of it
+	require.NoError(t, os.Remove(path+".1"))
+	r, err = OpenLogs(LogReaderConfig{Start: cp}, path+"*")
+	require.NoError(t, err)
+	recs = readAll(t, r)
+	require.NotEmpty(t, recs)
+	assert.Equal(t, 1, recs[0].Line)
+	assert.EqualValues(t, 0, recs[0].Offset)
+}
+
+func TestLogReaderDecodeErrors(t *testing.T) {
+	lines, err := readLines("t-data/haproxy_log")
+	require.NoError(t, err)
+	// port out of range matches httplog but fails to decode
+	bad := strings.Replace(lines[1], ":58994 [", ":99999 [", 1)
+	require.NotEqual(t, lines[1], bad)
+	in := strings.Join([]string{lines[0], "not a log line", bad, lines[2]}, "\n")
+	var errLines []int
+	r, err := NewLogReader(strings.NewReader(in), LogReaderConfig{OnError: func(rec LogRecord, err error) {
+		assert.Error(t, err)
+		errLines = append(errLines, rec.Line)
+	}})
+	require.NoError(t, err)
+	recs := readAll(t, r)
+	assert.Len(t, recs, 2)
+	assert.Equal(t, 1, r.Skipped())
+	assert.Equal(t, 1, r.DecodeErrors())
+	assert.Equal(t, []int{3}, errLines)
+}
+
+func TestLogReaderFollow(t *testing.T) {
+	lines, err := readLines("t-data/haproxy_log")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "haproxy.log")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	fmt.Fprintln(f, lines[0])
+	r, err := OpenLogs(LogReaderConfig{Follow: true, PollInterval: 5 * time.Millisecond}, path)
+	require.NoError(t, err)
+
+	recs := make(chan LogRecord)
+	done := make(chan error)
+	go func() {
+		for {
+			rec, err := r.Next()
+			if err != nil {
+				done <- err
+				return
+			}
+			recs <- rec
+		}
+	}()
+	next := func() LogRecord {
+		select {
+		case rec := <-recs:
+			return rec
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for record")
+		}
+		return LogRecord{}
+	}
+	assert.Equal(t, 1, next().Line)
+	// partial line is not returned until it is complete
+	fmt.Fprint(f, lines[1][:50])
+	time.Sleep(20 * time.Millisecond)
+	fmt.Fprintln(f, lines[1][50:])
+	rec := next()
+	assert.Equal(t, 2, rec.Line)
+	assert.EqualValues(t, len(lines[1])+1, rec.End-rec.Offset)
+
+	// rotate: rename and create new file, leftovers from old one are read first
+	fmt.Fprintln(f, lines[2])
+	require.NoError(t, os.Rename(path, path+".1"))
+	// last line of rotated file is not terminated, it is returned once new file shows up
+	fmt.Fprint(f, lines[3])
+	f.Close()
+	assert.Equal(t, 3, next().Line)
+	f, err = os.Create(path)
+	require.NoError(t, err)
+	rec = next()
+	assert.Equal(t, 4, rec.Line)
+	assert.Equal(t, path, rec.Source)
+	assert.EqualValues(t, len(lines[3]), rec.End-rec.Offset)
+	fmt.Fprintln(f, lines[4])
+	fmt.Fprintln(f, lines[5])
+	rec = next()
+	assert.Equal(t, 1, rec.Line)
+	assert.EqualValues(t, 0, rec.Offset)
+	assert.Equal(t, 2, next().Line)
+
+	// copytruncate
+	require.NoError(t, f.Truncate(0))
+	f.Seek(0, io.SeekStart)
+	time.Sleep(20 * time.Millisecond)
+	fmt.Fprintln(f, lines[6])
+	f.Close()
+	rec = next()
+	assert.Equal(t, 1, rec.Line)
+	assert.Equal(t, "/", rec.Request.RequestPath[:1])
+
+	r.Close()
+	select {
+	case err := <-done:
+		assert.Equal(t, io.EOF, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't stop following")
+	}
+}
@@ -0,0 +1,403 @@
+package haproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// LogHandler receives everything LogServer decoded. It is called from the reader goroutine so slow handler slows down reading
+// (and makes kernel drop UDP packets) instead of LogServer dropping them
+type LogHandler interface {
+	// HandleLog gets anything DecodeLog returns
+	HandleLog(log interface{})
+	HandleError(err error)
+}
+
+// LogServerConfig configures LogServer
+type LogServerConfig struct {
+	// addresses to listen on: udp://127.0.0.1:50514, tcp://:514, unixgram:///run/haproxy/log.sock.
+	// Address without scheme is UDP
+	Listen []string
+	// size of Requests and Errors channels, defaults to 1024. Messages are dropped when channel is full
+	QueueSize int
+	// socket receive buffer (SO_RCVBUF) for UDP and unixgram, 0 leaves system default
+	ReadBuffer int
+	// longest accepted message, longer ones are truncated. Defaults to 65535
+	MaxMessageSize int
+	// if set, decoded logs go to handler instead of channels
+	Handler LogHandler
+	// layout of default httplog lines, HTTPLogV17 for haproxy 1.7 and newer. Defaults to HTTPLogV15
+	HTTPLogVersion HTTPLogVersion
+	// captures from the config, used to fill RequestHeaders and ResponseHeaders. Captures are left alone if empty
+	CaptureLayout CaptureLayout
+}
+
+// LogError is an error decoding received message
+type LogError struct {
+	Line string
+	Err  error
+}
+
+func (e *LogError) Error() string {
+	return fmt.Sprintf("can't decode [%s]: %s", e.Line, e.Err)
+}
+
+func (e *LogError) Unwrap() error {
+	return e.Err
+}
+
+// LogServerStats are message counters of LogServer
+type LogServerStats struct {
+	Received uint64 `json:"received"`
+	Decoded  uint64 `json:"decoded"`
+	Errors   uint64 `json:"errors"`
+	// non-HTTP logs when using channels
+	Ignored uint64 `json:"ignored"`
+	// channel was full
+	Dropped uint64 `json:"dropped"`
+}
+
+// LogServer receives logs from haproxy and decodes them
+type LogServer struct {
+	cfg      LogServerConfig
+	requests chan HTTPRequest
+	errors   chan error
+	stats    LogServerStats
+
+	lock      sync.Mutex
+	packet    []net.PacketConn
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	unixPaths []string
+	readers   sync.WaitGroup
+	connWG    sync.WaitGroup
+	closed    bool
+	done      chan struct{}
+}
+
+// NewLogServer starts listening on all configured addresses
+func NewLogServer(cfg LogServerConfig) (*LogServer, error) {
+	if len(cfg.Listen) == 0 {
+		return nil, errors.New("no listen address")
+	}
+	if err := cfg.HTTPLogVersion.check(); err != nil {
+		return nil, err
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1024
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = 65535
+	}
+	s := &LogServer{
+		cfg:   cfg,
+		conns: make(map[net.Conn]struct{}),
+		done:  make(chan struct{}),
+	}
+	if cfg.Handler == nil {
+		s.requests = make(chan HTTPRequest, cfg.QueueSize)
+		s.errors = make(chan error, cfg.QueueSize)
+	}
+	for _, addr := range cfg.Listen {
+		if err := s.listen(addr); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	for _, pc := range s.packet {
+		s.readers.Add(1)
+		go s.readPackets(pc)
+	}
+	for _, l := range s.listeners {
+		s.readers.Add(1)
+		go s.accept(l)
+	}
+	go func() {
+		s.readers.Wait()
+		s.connWG.Wait()
+		if s.requests != nil {
+			close(s.requests)
+			close(s.errors)
+		}
+		close(s.done)
+	}()
+	return s, nil
+}
+
+// Requests returns channel with decoded HTTP logs. It is closed after shutdown. Nil if Handler is used
+func (s *LogServer) Requests() <-chan HTTPRequest {
+	return s.requests
+}
+
+// Errors returns channel with *LogError for messages that failed to decode. Nil if Handler is used
+func (s *LogServer) Errors() <-chan error {
+	return s.errors
+}
+
+// Addrs returns addresses server listens on, useful when listening on port 0
+func (s *LogServer) Addrs() []net.Addr {
+	var addrs []net.Addr
+	for _, pc := range s.packet {
+		addrs = append(addrs, pc.LocalAddr())
+	}
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
+}
+
+// Stats returns current counters
+func (s *LogServer) Stats() LogServerStats {
+	return LogServerStats{
+		Received: atomic.LoadUint64(&s.stats.Received),
+		Decoded:  atomic.LoadUint64(&s.stats.Decoded),
+		Errors:   atomic.LoadUint64(&s.stats.Errors),
+		Ignored:  atomic.LoadUint64(&s.stats.Ignored),
+		Dropped:  atomic.LoadUint64(&s.stats.Dropped),
+	}
+}
+
+// Shutdown stops listening and waits for TCP connections to be closed by the sender until ctx is done,
+// then closes the rest and returns ctx error. Channels are closed once everything received was delivered
+func (s *LogServer) Shutdown(ctx context.Context) error {
+	s.stopListening()
+	connsDone := make(chan struct{})
+	go func() {
+		s.connWG.Wait()
+		close(connsDone)
+	}()
+	select {
+	case <-connsDone:
+	case <-ctx.Done():
+		s.closeConns()
+	}
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the server immediately
+func (s *LogServer) Close() error {
+	s.stopListening()
+	s.closeConns()
+	return nil
+}
+
+func (s *LogServer) listen(addr string) error {
+	network := "udp"
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, addr = addr[:i], addr[i+3:]
+	}
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		if s.cfg.ReadBuffer > 0 {
+			if b, ok := pc.(interface{ SetReadBuffer(int) error }); ok {
+				if err := b.SetReadBuffer(s.cfg.ReadBuffer); err != nil {
+					pc.Close()
+					return err
+				}
+			}
+		}
+		if network == "unixgram" {
+			s.unixPaths = append(s.unixPaths, addr)
+		}
+		s.packet = append(s.packet, pc)
+	case "tcp", "tcp4", "tcp6", "unix":
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return err
+		}
+		s.listeners = append(s.listeners, l)
+	default:
+		return errors.New(fmt.Sprintf("unsupported network [%s]", network))
+	}
+	return nil
+}
+
+func (s *LogServer) stopListening() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, pc := range s.packet {
+		pc.Close()
+	}
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for _, path := range s.unixPaths {
+		os.Remove(path)
+	}
+}
+
+func (s *LogServer) closeConns() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *LogServer) readPackets(pc net.PacketConn) {
+	defer s.readers.Done()
+	buf := make([]byte, s.cfg.MaxMessageSize)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		s.handle(string(buf[:n]))
+	}
+}
+
+func (s *LogServer) accept(l net.Listener) {
+	defer s.readers.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.connWG.Add(1)
+		s.lock.Unlock()
+		go s.readStream(conn)
+	}
+}
+
+// readStream reads RFC 6587 framed messages, octet-counted or newline-terminated.
+// Framing is decided by the first frame and kept for the whole connection, like other receivers do,
+// so newline-terminated message that starts with a number isn't taken for a length
+func (s *LogServer) readStream(conn net.Conn) {
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+		s.connWG.Done()
+	}()
+	r := bufio.NewReaderSize(conn, s.cfg.MaxMessageSize+16)
+	octets := octetCounted(r)
+	for {
+		msg, err := s.readFrame(r, octets)
+		if len(msg) > 0 {
+			s.handle(msg)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// octetCounted checks if first frame is "<len> <msg>", len is at most few digits
+func octetCounted(r *bufio.Reader) bool {
+	digits := 0
+	for ; digits < 10; digits++ {
+		b, err := r.Peek(digits + 1)
+		if err != nil {
+			return false
+		}
+		if b[digits] < '0' || b[digits] > '9' {
+			break
+		}
+	}
+	b, err := r.Peek(digits + 1)
+	return digits > 0 && err == nil && b[digits] == ' '
+}
+
+func (s *LogServer) readFrame(r *bufio.Reader, octets bool) (string, error) {
+	if octets {
+		field, err := r.ReadSlice(' ')
+		if err != nil {
+			return "", err
+		}
+		// some senders terminate octet-counted frames with newline anyway
+		n, err := strconv.Atoi(strings.TrimLeft(string(field[:len(field)-1]), "\r\n"))
+		if err != nil || n < 0 {
+			return "", errors.New(fmt.Sprintf("invalid octet count [%.10s]", field))
+		}
+		if n > s.cfg.MaxMessageSize {
+			msg := make([]byte, s.cfg.MaxMessageSize)
+			_, err := io.ReadFull(r, msg)
+			if err == nil {
+				_, err = r.Discard(n - s.cfg.MaxMessageSize)
+			}
+			return string(msg), err
+		}
+		msg := make([]byte, n)
+		_, err = io.ReadFull(r, msg)
+		return string(msg), err
+	}
+	line, err := r.ReadSlice('\n')
+	msg := string(line)
+	if err == bufio.ErrBufferFull {
+		// too long, skip the rest of it
+		for err == bufio.ErrBufferFull {
+			_, err = r.ReadSlice('\n')
+		}
+	}
+	return strings.TrimRight(msg, "\r\n\x00"), err
+}
+
+func (s *LogServer) handle(msg string) {
+	atomic.AddUint64(&s.stats.Received, 1)
+	log, err := HTTPLogDecoder{Version: s.cfg.HTTPLogVersion, Captures: s.cfg.CaptureLayout}.DecodeLog(msg)
+	if err != nil {
+		atomic.AddUint64(&s.stats.Errors, 1)
+		err = &LogError{Line: msg, Err: err}
+		if s.cfg.Handler != nil {
+			s.cfg.Handler.HandleError(err)
+			return
+		}
+		select {
+		case s.errors <- err:
+		default:
+			atomic.AddUint64(&s.stats.Dropped, 1)
+		}
+		return
+	}
+	atomic.AddUint64(&s.stats.Decoded, 1)
+	if s.cfg.Handler != nil {
+		s.cfg.Handler.HandleLog(log)
+		return
+	}
+	req, ok := log.(HTTPRequest)
+	if !ok {
+		atomic.AddUint64(&s.stats.Ignored, 1)
+		return
+	}
+	select {
+	case s.requests <- req:
+	default:
+		atomic.AddUint64(&s.stats.Dropped, 1)
+	}
+}
@@ -0,0 +1,193 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testServerLine = `<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1_foobar~ backend_foobar-ssl/app3-backend 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/0 "POST /query/q/Sql HTTP/1.1"`
+
+func receive(t *testing.T, ch <-chan HTTPRequest) HTTPRequest {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for request")
+	}
+	return HTTPRequest{}
+}
+
+func TestLogServerUDP(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "log.sock")
+	srv, err := NewLogServer(LogServerConfig{
+		Listen:     []string{"127.0.0.1:0", "unixgram://" + sock},
+		ReadBuffer: 1 << 20,
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+	addrs := srv.Addrs()
+	require.Len(t, addrs, 2)
+
+	conn, err := net.Dial("udp", addrs[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(testServerLine + "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/query/q/Sql", receive(t, srv.Requests()).RequestPath)
+
+	uconn, err := net.Dial("unixgram", sock)
+	require.NoError(t, err)
+	defer uconn.Close()
+	_, err = uconn.Write([]byte(testServerLine))
+	require.NoError(t, err)
+	assert.Equal(t, 11446, receive(t, srv.Requests()).PID)
+
+	conn.Write([]byte("garbage"))
+	select {
+	case err := <-srv.Errors():
+		var logErr *LogError
+		require.ErrorAs(t, err, &logErr)
+		assert.Equal(t, "garbage", logErr.Line)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for error")
+	}
+	// non-HTTP logs are only passed to handler
+	conn.Write([]byte("<134>Feb  6 12:12:56 haproxy[1]: Proxy fnt started."))
+	require.Eventually(t, func() bool { return srv.Stats().Ignored == 1 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, LogServerStats{Received: 4, Decoded: 3, Errors: 1, Ignored: 1}, srv.Stats())
+}
+
+func TestLogServerTCP(t *testing.T) {
+	srv, err := NewLogServer(LogServerConfig{Listen: []string{"tcp://127.0.0.1:0"}})
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	require.NoError(t, err)
+	// framing is taken from the first frame, raw format line starting with a number is not an octet count
+	raw := `10.0.0.1:5000 [23/Jul/2015:13:49:11.933] fe be/srv 1/0/1/52/1348 200 1140 - - ---- 1/1/1/1/0 0/0 "GET /raw HTTP/1.1"`
+	numbered := `10 ` + raw
+	fmt.Fprintf(conn, "%s\n", raw)
+	fmt.Fprintf(conn, "%s\n", numbered)
+	fmt.Fprintf(conn, "%s\r\n", testServerLine)
+	assert.Equal(t, "/raw", receive(t, srv.Requests()).RequestPath)
+	req := receive(t, srv.Requests())
+	assert.Equal(t, "10 10.0.0.1", req.ClientIP)
+	assert.Equal(t, "/query/q/Sql", receive(t, srv.Requests()).RequestPath)
+
+	octetConn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	require.NoError(t, err)
+	fmt.Fprintf(octetConn, "%d %s", len(testServerLine), testServerLine)
+	fmt.Fprintf(octetConn, "%d %s\n", len(raw), raw)
+	fmt.Fprintf(octetConn, "%d %s", len(numbered), numbered)
+	assert.Equal(t, "/query/q/Sql", receive(t, srv.Requests()).RequestPath)
+	assert.Equal(t, "/raw", receive(t, srv.Requests()).RequestPath)
+	assert.Equal(t, "10 10.0.0.1", receive(t, srv.Requests()).ClientIP)
+	// broken count ends the connection
+	fmt.Fprintf(octetConn, "%s\n", testServerLine)
+	octetConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = octetConn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	octetConn.Close()
+
+	// shutdown waits for sender to disconnect
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdownDone := make(chan error)
+	go func() { shutdownDone <- srv.Shutdown(ctx) }()
+	fmt.Fprintf(conn, "%s", testServerLine)
+	conn.Close()
+	require.NoError(t, <-shutdownDone)
+	assert.Equal(t, "/query/q/Sql", receive(t, srv.Requests()).RequestPath)
+	_, ok := <-srv.Requests()
+	assert.False(t, ok, "channel should be closed")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	srv, err = NewLogServer(LogServerConfig{Listen: []string{"tcp://127.0.0.1:0"}})
+	require.NoError(t, err)
+	conn, err = net.Dial("tcp", srv.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\n", testServerLine)
+	receive(t, srv.Requests())
+	// connection still open, shutdown should force it
+	assert.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+	select {
+	case _, ok := <-srv.Requests():
+		assert.False(t, ok, "channel should be closed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after forced shutdown")
+	}
+}
+
+func TestLogServerDrops(t *testing.T) {
+	srv, err := NewLogServer(LogServerConfig{Listen: []string{"tcp://127.0.0.1:0"}, QueueSize: 1, MaxMessageSize: 512})
+	require.NoError(t, err)
+	defer srv.Close()
+	conn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(conn, "%s\n", testServerLine)
+	}
+	// too long gets truncated
+	octetConn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	require.NoError(t, err)
+	defer octetConn.Close()
+	fmt.Fprintf(octetConn, "700 %0700d", 0)
+	require.Eventually(t, func() bool { return srv.Stats().Received == 4 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, LogServerStats{Received: 4, Decoded: 3, Errors: 1, Dropped: 2}, srv.Stats())
+	assert.Len(t, srv.Requests(), 1)
+	assert.Len(t, srv.Errors(), 1)
+}
+
+type testLogHandler struct {
+	sync.Mutex
+	logs []interface{}
+	errs []error
+}
+
+func (h *testLogHandler) HandleLog(log interface{}) {
+	h.Lock()
+	defer h.Unlock()
+	h.logs = append(h.logs, log)
+}
+
+func (h *testLogHandler) HandleError(err error) {
+	h.Lock()
+	defer h.Unlock()
+	h.errs = append(h.errs, err)
+}
+
+func TestLogServerHandler(t *testing.T) {
+	h := &testLogHandler{}
+	srv, err := NewLogServer(LogServerConfig{Listen: []string{"udp://127.0.0.1:0"}, Handler: h})
+	require.NoError(t, err)
+	assert.Nil(t, srv.Requests())
+	conn, err := net.Dial("udp", srv.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte(testServerLine))
+	conn.Write([]byte("<134>Feb  6 12:12:56 haproxy[1]: Proxy fnt started."))
+	conn.Write([]byte("garbage"))
+	require.Eventually(t, func() bool { return srv.Stats().Received == 3 }, 5*time.Second, time.Millisecond)
+	require.NoError(t, srv.Shutdown(context.Background()))
+	require.Len(t, h.logs, 2)
+	assert.IsType(t, HTTPRequest{}, h.logs[0])
+	assert.IsType(t, ProxyEvent{}, h.logs[1])
+	assert.Len(t, h.errs, 1)
+
+	_, err = NewLogServer(LogServerConfig{Listen: []string{"sctp://127.0.0.1:0"}})
+	assert.Error(t, err)
+	_, err = NewLogServer(LogServerConfig{})
+	assert.Error(t, err)
+}
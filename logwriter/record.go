@@ -0,0 +1,136 @@
+// Package logwriter serializes streams of decoded requests into CSV, newline-delimited JSON and Parquet for offline analysis
+package logwriter
+
+import (
+	"strconv"
+
+	haproxy "github.com/efigence/go-haproxy"
+)
+
+// Record is flat, fixed schema version of haproxy.HTTPRequest used by CSV and Parquet writers.
+// Maps (decoded headers, Extra) don't fit fixed schema, raw CapturedSamples are kept instead.
+// Field order is column order and should only ever be appended to
+type Record struct {
+	TS                       int64  `json:"ts_us" parquet:"name=ts_us, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Hostname                 string `json:"hostname" parquet:"name=hostname, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PID                      int32  `json:"pid" parquet:"name=pid, type=INT32"`
+	ClientIP                 string `json:"client_ip" parquet:"name=client_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ClientPort               int32  `json:"client_port" parquet:"name=client_port, type=INT32"`
+	ClientSSL                bool   `json:"client_ssl" parquet:"name=client_ssl, type=BOOLEAN"`
+	FrontendName             string `json:"frontend_name" parquet:"name=frontend_name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	BackendName              string `json:"backend_name" parquet:"name=backend_name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ServerName               string `json:"server_name" parquet:"name=server_name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	StatusCode               int32  `json:"status_code" parquet:"name=status_code, type=INT32"`
+	BytesRead                int64  `json:"bytes_read" parquet:"name=bytes_read, type=INT64"`
+	RequestHeaderDurationMs  int32  `json:"request_header_duration_ms" parquet:"name=request_header_duration_ms, type=INT32"`
+	QueueDurationMs          int32  `json:"queue_duration_ms" parquet:"name=queue_duration_ms, type=INT32"`
+	ServerConnDurationMs     int32  `json:"server_conn_duration_ms" parquet:"name=server_conn_duration_ms, type=INT32"`
+	ResponseHeaderDurationMs int32  `json:"response_header_duration_ms" parquet:"name=response_header_duration_ms, type=INT32"`
+	TotalDurationMs          int32  `json:"total_duration_ms" parquet:"name=total_duration_ms, type=INT32"`
+	RequestReceiveDurationMs int32  `json:"request_receive_duration_ms" parquet:"name=request_receive_duration_ms, type=INT32"`
+	ActiveDurationMs         int32  `json:"active_duration_ms" parquet:"name=active_duration_ms, type=INT32"`
+	IdleDurationMs           int32  `json:"idle_duration_ms" parquet:"name=idle_duration_ms, type=INT32"`
+	LogVersion               string `json:"log_version" parquet:"name=log_version, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	RequestMethod            string `json:"http_method" parquet:"name=http_method, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	RequestPath              string `json:"http_path" parquet:"name=http_path, type=BYTE_ARRAY, convertedtype=UTF8"`
+	HTTPVersion              string `json:"http_version" parquet:"name=http_version, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	TerminationReason        string `json:"termination_reason" parquet:"name=termination_reason, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SessionCloseState        string `json:"session_close_state" parquet:"name=session_close_state, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ClientPersistenceState   string `json:"client_persistence_state" parquet:"name=client_persistence_state, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PersistenceCookieState   string `json:"persistence_cookie" parquet:"name=persistence_cookie, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	TotalConn                int64  `json:"total_conn" parquet:"name=total_conn, type=INT64"`
+	FrontendConn             int64  `json:"frontend_conn" parquet:"name=frontend_conn, type=INT64"`
+	BackendConn              int64  `json:"backend_conn" parquet:"name=backend_conn, type=INT64"`
+	ServerConn               int64  `json:"server_conn" parquet:"name=server_conn, type=INT64"`
+	Retries                  int64  `json:"retries" parquet:"name=retries, type=INT64"`
+	ServerQueue              int64  `json:"server_queue" parquet:"name=server_queue, type=INT64"`
+	BackendQueue             int64  `json:"backend_queue" parquet:"name=backend_queue, type=INT64"`
+	CapturedRequestCookie    string `json:"captured_request_cookie" parquet:"name=captured_request_cookie, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CapturedResponseCookie   string `json:"captured_response_cookie" parquet:"name=captured_response_cookie, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CapturedSamples          string `json:"captured_samples" parquet:"name=captured_samples, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UniqueID                 string `json:"unique_id" parquet:"name=unique_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BadReq                   bool   `json:"bad_request" parquet:"name=bad_request, type=BOOLEAN"`
+	Truncated                bool   `json:"truncated" parquet:"name=truncated, type=BOOLEAN"`
+}
+
+// Columns are CSV header, same as Record's json names
+var Columns = []string{
+	"ts_us", "hostname", "pid", "client_ip", "client_port", "client_ssl",
+	"frontend_name", "backend_name", "server_name", "status_code", "bytes_read",
+	"request_header_duration_ms", "queue_duration_ms", "server_conn_duration_ms", "response_header_duration_ms", "total_duration_ms",
+	"request_receive_duration_ms", "active_duration_ms", "idle_duration_ms", "log_version",
+	"http_method", "http_path", "http_version",
+	"termination_reason", "session_close_state", "client_persistence_state", "persistence_cookie",
+	"total_conn", "frontend_conn", "backend_conn", "server_conn", "retries", "server_queue", "backend_queue",
+	"captured_request_cookie", "captured_response_cookie", "captured_samples", "unique_id", "bad_request", "truncated",
+}
+
+// NewRecord flattens request
+func NewRecord(r *haproxy.HTTPRequest) Record {
+	return Record{
+		TS:                       r.TS,
+		Hostname:                 r.Syslog.Hostname,
+		PID:                      int32(r.PID),
+		ClientIP:                 r.ClientIP,
+		ClientPort:               int32(r.ClientPort),
+		ClientSSL:                r.ClientSSL,
+		FrontendName:             r.FrontendName,
+		BackendName:              r.BackendName,
+		ServerName:               r.ServerName,
+		StatusCode:               int32(r.StatusCode),
+		BytesRead:                int64(r.BytesRead),
+		RequestHeaderDurationMs:  int32(r.RequestHeaderDurationMs),
+		QueueDurationMs:          int32(r.QueueDurationMs),
+		ServerConnDurationMs:     int32(r.ServerConnDurationMs),
+		ResponseHeaderDurationMs: int32(r.ResponseHeaderDurationMs),
+		TotalDurationMs:          int32(r.TotalDurationMs),
+		RequestReceiveDurationMs: int32(r.RequestReceiveDurationMs),
+		ActiveDurationMs:         int32(r.ActiveDurationMs),
+		IdleDurationMs:           int32(r.IdleDurationMs),
+		LogVersion:               string(r.LogVersion),
+		RequestMethod:            r.RequestMethod,
+		RequestPath:              r.RequestPath,
+		HTTPVersion:              r.HTTPVersion,
+		TerminationReason:        runeString(r.TerminationReason),
+		SessionCloseState:        runeString(r.SessionCloseState),
+		ClientPersistenceState:   runeString(r.ClientPersistenceState),
+		PersistenceCookieState:   runeString(r.PersistenceCookieState),
+		TotalConn:                int64(r.TotalConn),
+		FrontendConn:             int64(r.FrontendConn),
+		BackendConn:              int64(r.BackendConn),
+		ServerConn:               int64(r.ServerConn),
+		Retries:                  int64(r.Retries),
+		ServerQueue:              int64(r.ServerQueue),
+		BackendQueue:             int64(r.BackendQueue),
+		CapturedRequestCookie:    r.CapturedRequestCookie,
+		CapturedResponseCookie:   r.CapturedResponseCookie,
+		CapturedSamples:          r.CapturedSamples,
+		UniqueID:                 r.UniqueID,
+		BadReq:                   r.BadReq,
+		Truncated:                r.Truncated,
+	}
+}
+
+// appendCSV appends values in Columns order
+func (r *Record) appendCSV(row []string) []string {
+	i32 := func(v int32) string { return strconv.FormatInt(int64(v), 10) }
+	i64 := func(v int64) string { return strconv.FormatInt(v, 10) }
+	return append(row,
+		i64(r.TS), r.Hostname, i32(r.PID), r.ClientIP, i32(r.ClientPort), strconv.FormatBool(r.ClientSSL),
+		r.FrontendName, r.BackendName, r.ServerName, i32(r.StatusCode), i64(r.BytesRead),
+		i32(r.RequestHeaderDurationMs), i32(r.QueueDurationMs), i32(r.ServerConnDurationMs), i32(r.ResponseHeaderDurationMs), i32(r.TotalDurationMs),
+		i32(r.RequestReceiveDurationMs), i32(r.ActiveDurationMs), i32(r.IdleDurationMs), r.LogVersion,
+		r.RequestMethod, r.RequestPath, r.HTTPVersion,
+		r.TerminationReason, r.SessionCloseState, r.ClientPersistenceState, r.PersistenceCookieState,
+		i64(r.TotalConn), i64(r.FrontendConn), i64(r.BackendConn), i64(r.ServerConn), i64(r.Retries), i64(r.ServerQueue), i64(r.BackendQueue),
+		r.CapturedRequestCookie, r.CapturedResponseCookie, r.CapturedSamples, r.UniqueID, strconv.FormatBool(r.BadReq), strconv.FormatBool(r.Truncated),
+	)
+}
+
+// runeString renders state character, unset (0) one as empty string
+func runeString(r rune) string {
+	if r == 0 {
+		return ""
+	}
+	return string(r)
+}
@@ -0,0 +1,143 @@
+package logwriter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Writer writes stream of requests in some format
+type Writer interface {
+	Write(r *haproxy.HTTPRequest) error
+	// Close flushes buffered data and writes footer if format has one. Underlying io.Writer is not closed
+	Close() error
+}
+
+// supported formats
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// New creates writer of given format
+func New(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSV(w), nil
+	case FormatNDJSON, "jsonl":
+		return NewNDJSON(w), nil
+	case FormatParquet:
+		return NewParquet(w)
+	}
+	return nil, errors.New(fmt.Sprintf("unknown format [%s], use csv, ndjson or parquet", format))
+}
+
+// CSVWriter writes header and one row per request, columns are Columns
+type CSVWriter struct {
+	w      *csv.Writer
+	row    []string
+	header bool
+}
+
+// NewCSV creates CSV writer
+func NewCSV(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w), row: make([]string, 0, len(Columns))}
+}
+
+func (c *CSVWriter) Write(r *haproxy.HTTPRequest) error {
+	if !c.header {
+		if err := c.w.Write(Columns); err != nil {
+			return err
+		}
+		c.header = true
+	}
+	rec := NewRecord(r)
+	c.row = rec.appendCSV(c.row[:0])
+	return c.w.Write(c.row)
+}
+
+// Close writes header if there were no rows and flushes
+func (c *CSVWriter) Close() error {
+	if !c.header {
+		c.w.Write(Columns)
+		c.header = true
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonRequest overrides rune fields of HTTPRequest so they are written as characters instead of numbers
+type ndjsonRequest struct {
+	*haproxy.HTTPRequest
+	TerminationReason      string `json:"termination_reason"`
+	SessionCloseState      string `json:"session_close_state"`
+	ClientPersistenceState string `json:"client_persistence_state"`
+	PersistenceCookieState string `json:"persistence_cookie"`
+}
+
+// NDJSONWriter writes one JSON object per line. Unlike Record it keeps everything, including syslog header, decoded headers and Extra.
+// Fields have the same names as in HTTPRequest JSON, only rune fields are strings (`"termination_reason":"s"`)
+type NDJSONWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewNDJSON creates newline-delimited JSON writer
+func NewNDJSON(w io.Writer) *NDJSONWriter {
+	b := bufio.NewWriter(w)
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	return &NDJSONWriter{w: b, enc: enc}
+}
+
+func (n *NDJSONWriter) Write(r *haproxy.HTTPRequest) error {
+	return n.enc.Encode(ndjsonRequest{
+		HTTPRequest:            r,
+		TerminationReason:      runeString(r.TerminationReason),
+		SessionCloseState:      runeString(r.SessionCloseState),
+		ClientPersistenceState: runeString(r.ClientPersistenceState),
+		PersistenceCookieState: runeString(r.PersistenceCookieState),
+	})
+}
+
+// Flush writes buffered lines, for streaming output
+func (n *NDJSONWriter) Flush() error {
+	return n.w.Flush()
+}
+
+// Close flushes buffered lines
+func (n *NDJSONWriter) Close() error {
+	return n.w.Flush()
+}
+
+// ParquetWriter writes Records into Parquet file, snappy compressed
+type ParquetWriter struct {
+	pw *writer.ParquetWriter
+}
+
+// NewParquet creates Parquet writer. Rows are buffered into row groups, file is only valid after Close
+func NewParquet(w io.Writer) (*ParquetWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(Record), 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &ParquetWriter{pw: pw}, nil
+}
+
+func (p *ParquetWriter) Write(r *haproxy.HTTPRequest) error {
+	return p.pw.Write(NewRecord(r))
+}
+
+// Close writes remaining rows and the footer
+func (p *ParquetWriter) Close() error {
+	return p.pw.WriteStop()
+}
@@ -0,0 +1,127 @@
+package logwriter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+var testLines = []string{
+	`<158>Jul 23 13:49:13 lb1 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1~ app/app3 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/0 {a,b|c} "POST /query/q/Sql HTTP/1.1"`,
+	`<158>Jul 23 13:49:13 lb1 haproxy[11446]: 83.3.255.169:61060 [23/Jul/2015:13:49:12.001] front1~ app/<NOSRV> 10/-1/-1/-1/5010 503 212 - - sQ-- 1637/7/5/6/0 0/0 "GET / HTTP/1.1"`,
+}
+
+func testRequests(t *testing.T) []haproxy.HTTPRequest {
+	tz := haproxy.HaproxyLogTimezone
+	t.Cleanup(func() { haproxy.HaproxyLogTimezone = tz })
+	haproxy.HaproxyLogTimezone = time.UTC
+	var out []haproxy.HTTPRequest
+	for _, line := range testLines {
+		r, err := haproxy.DecodeHTTPLog(line)
+		require.NoError(t, err)
+		out = append(out, r)
+	}
+	return out
+}
+
+func TestColumnsMatchRecord(t *testing.T) {
+	typ := reflect.TypeOf(Record{})
+	require.Equal(t, typ.NumField(), len(Columns))
+	for i := 0; i < typ.NumField(); i++ {
+		assert.Equal(t, Columns[i], typ.Field(i).Tag.Get("json"))
+		assert.True(t, strings.HasPrefix(typ.Field(i).Tag.Get("parquet"), "name="+Columns[i]+","), Columns[i])
+	}
+	var row []string
+	var rec Record
+	assert.Len(t, rec.appendCSV(row), len(Columns))
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New(FormatCSV, &buf)
+	require.NoError(t, err)
+	for _, r := range testRequests(t) {
+		r := r
+		require.NoError(t, w.Write(&r))
+	}
+	require.NoError(t, w.Close())
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, Columns, rows[0])
+	row := map[string]string{}
+	for i, c := range Columns {
+		row[c] = rows[2][i]
+	}
+	assert.Equal(t, "1437659352001000", row["ts_us"])
+	assert.Equal(t, "lb1", row["hostname"])
+	assert.Equal(t, "<NOSRV>", row["server_name"])
+	assert.Equal(t, "-1", row["queue_duration_ms"])
+	assert.Equal(t, "s", row["termination_reason"])
+	assert.Equal(t, "Q", row["session_close_state"])
+	assert.Equal(t, "-", row["persistence_cookie"])
+	assert.Equal(t, "true", row["client_ssl"])
+
+	// header even without rows
+	buf.Reset()
+	require.NoError(t, NewCSV(&buf).Close())
+	assert.Equal(t, strings.Join(Columns, ",")+"\n", buf.String())
+}
+
+func TestNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New(FormatNDJSON, &buf)
+	require.NoError(t, err)
+	for _, r := range testRequests(t) {
+		r := r
+		require.NoError(t, w.Write(&r))
+	}
+	require.NoError(t, w.Close())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &m))
+	assert.Equal(t, "s", m["termination_reason"])
+	assert.Equal(t, "Q", m["session_close_state"])
+	assert.Equal(t, "-", m["client_persistence_state"])
+	assert.Equal(t, "<NOSRV>", m["server_name"])
+	assert.Equal(t, "lb1", m["syslog"].(map[string]interface{})["hostname"])
+	assert.Contains(t, lines[1], `"server_name":"<NOSRV>"`)
+}
+
+func TestParquet(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New(FormatParquet, &buf)
+	require.NoError(t, err)
+	reqs := testRequests(t)
+	for i := range reqs {
+		require.NoError(t, w.Write(&reqs[i]))
+	}
+	require.NoError(t, w.Close())
+
+	f, err := buffer.NewBufferFile(buf.Bytes())
+	require.NoError(t, err)
+	pr, err := reader.NewParquetReader(f, new(Record), 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+	require.EqualValues(t, 2, pr.GetNumRows())
+	out := make([]Record, 2)
+	require.NoError(t, pr.Read(&out))
+	assert.Equal(t, NewRecord(&reqs[0]), out[0])
+	assert.Equal(t, NewRecord(&reqs[1]), out[1])
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := New("xml", &bytes.Buffer{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,233 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	haproxy "github.com/efigence/go-haproxy"
+)
+
+const scopeName = "github.com/efigence/go-haproxy/otlp"
+
+// Sink receives encoded OTLP/JSON export requests
+type Sink interface {
+	Export(ctx context.Context, payload []byte) error
+}
+
+type writerSink struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// WriterSink writes each export request as single line, which is the format of collector's file exporter and otlpjsonfile receiver
+func WriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Export(ctx context.Context, payload []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.w.Write(append(payload, '\n'))
+	return err
+}
+
+type httpSink struct {
+	endpoint string
+	client   *http.Client
+}
+
+// HTTPSink POSTs export requests to OTLP/HTTP endpoint, like http://127.0.0.1:4318/v1/traces of local collector.
+// nil client uses one with 10s timeout
+func HTTPSink(endpoint string, client *http.Client) Sink {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &httpSink{endpoint: endpoint, client: client}
+}
+
+func (s *httpSink) Export(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 != 2 {
+		return errors.New(fmt.Sprintf("OTLP export to %s failed: %s %s", s.endpoint, resp.Status, body))
+	}
+	return nil
+}
+
+// Exporter batches spans made from requests and exports them to Sink.
+// Full batches are exported by RunFlusher in background, so Add never waits for the sink.
+// It implements haproxy.LogHandler, non-HTTP logs are ignored. It is safe for concurrent use
+type Exporter struct {
+	cfg      Config
+	sink     Sink
+	resource Resource
+	full     chan []Span
+
+	lock    sync.Mutex
+	spans   []Span
+	err     error
+	dropped uint64
+}
+
+// New creates Exporter
+func New(cfg Config, sink Sink) *Exporter {
+	cfg.setDefaults()
+	e := &Exporter{cfg: cfg, sink: sink, full: make(chan []Span, cfg.QueueSize)}
+	e.resource.Attributes = append(e.resource.Attributes, String("service.name", cfg.ServiceName))
+	keys := make([]string, 0, len(cfg.ResourceAttributes))
+	for k := range cfg.ResourceAttributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.resource.Attributes = append(e.resource.Attributes, String(k, cfg.ResourceAttributes[k]))
+	}
+	return e
+}
+
+// Add queues span of the request. Full batch is handed over to RunFlusher, if its queue is full too
+// the batch is dropped and error returned
+func (e *Exporter) Add(r *haproxy.HTTPRequest) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, NewSpan(e.cfg, r))
+	if len(e.spans) < e.cfg.BatchSize {
+		return nil
+	}
+	batch := e.spans
+	e.spans = nil
+	select {
+	case e.full <- batch:
+		return nil
+	default:
+		e.dropped += uint64(len(batch))
+		return errors.New(fmt.Sprintf("export queue full, dropped %d spans", len(batch)))
+	}
+}
+
+// Flush exports queued batches and spans. Spans of failed export are dropped, there is no retry
+func (e *Exporter) Flush(ctx context.Context) error {
+	var err error
+	for drained := false; !drained; {
+		select {
+		case batch := <-e.full:
+			if exportErr := e.export(ctx, batch); exportErr != nil && err == nil {
+				err = exportErr
+			}
+		default:
+			drained = true
+		}
+	}
+	e.lock.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.lock.Unlock()
+	if exportErr := e.export(ctx, spans); exportErr != nil && err == nil {
+		err = exportErr
+	}
+	return err
+}
+
+func (e *Exporter) export(ctx context.Context, spans []Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(e.exportRequest(spans))
+	if err == nil {
+		err = e.sink.Export(ctx, payload)
+	}
+	if err != nil {
+		e.lock.Lock()
+		e.dropped += uint64(len(spans))
+		e.lock.Unlock()
+	}
+	return err
+}
+
+func (e *Exporter) exportRequest(spans []Span) ExportRequest {
+	return ExportRequest{ResourceSpans: []ResourceSpans{{
+		Resource:   e.resource,
+		ScopeSpans: []ScopeSpans{{Scope: Scope{Name: scopeName}, Spans: spans}},
+	}}}
+}
+
+// Dropped returns number of spans lost in failed exports or because export queue was full
+func (e *Exporter) Dropped() uint64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.dropped
+}
+
+// RunFlusher exports full batches as they come and flushes every interval until context is cancelled, then flushes the rest.
+// Errors are passed to onError if it is not nil. Without it running full batches are only exported by Flush
+func (e *Exporter) RunFlusher(ctx context.Context, interval time.Duration, onError func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// parent context is already done, give the last export a moment of its own
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := e.Flush(flushCtx); err != nil && onError != nil {
+				onError(err)
+			}
+			return
+		case batch := <-e.full:
+			if err := e.export(ctx, batch); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-t.C:
+			if err := e.Flush(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// HandleLog adds HTTP requests, everything else is ignored. Errors (dropped batches) are kept until next Err() call
+func (e *Exporter) HandleLog(log interface{}) {
+	var err error
+	switch l := log.(type) {
+	case haproxy.HTTPRequest:
+		err = e.Add(&l)
+	case *haproxy.HTTPRequest:
+		err = e.Add(l)
+	}
+	if err != nil {
+		e.lock.Lock()
+		e.err = err
+		e.lock.Unlock()
+	}
+}
+
+// HandleError ignores decode errors, there is nothing to trace
+func (e *Exporter) HandleError(err error) {}
+
+// Err returns and clears last export error from HandleLog
+func (e *Exporter) Err() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	err := e.err
+	e.err = nil
+	return err
+}
+
+var _ haproxy.LogHandler = &Exporter{}
@@ -0,0 +1,127 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporterWriter(t *testing.T) {
+	var buf bytes.Buffer
+	e := New(Config{BatchSize: 2, ResourceAttributes: map[string]string{"host.name": "lb1"}}, WriterSink(&buf))
+	r := decode(t, testLine)
+	require.NoError(t, e.Add(r))
+	assert.Zero(t, buf.Len())
+	e.HandleLog(*r)
+	require.NoError(t, e.Err())
+	// full batch waits for flusher instead of being exported by HandleLog
+	assert.Zero(t, buf.Len())
+	require.NoError(t, e.Flush(context.Background()))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+
+	var req ExportRequest
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &req))
+	require.Len(t, req.ResourceSpans, 1)
+	rs := req.ResourceSpans[0]
+	assert.Equal(t, []KeyValue{String("service.name", "haproxy"), String("host.name", "lb1")}, rs.Resource.Attributes)
+	assert.Equal(t, scopeName, rs.ScopeSpans[0].Scope.Name)
+	assert.Len(t, rs.ScopeSpans[0].Spans, 2)
+	assert.Contains(t, lines[0], `"intValue":"200"`)
+
+	// nothing queued, nothing written
+	require.NoError(t, e.Flush(context.Background()))
+	assert.Len(t, strings.Split(strings.TrimSpace(buf.String()), "\n"), 1)
+}
+
+func TestExporterHTTP(t *testing.T) {
+	var got []byte
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/v1/traces", req.URL.Path)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		got, _ = io.ReadAll(req.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	e := New(Config{}, HTTPSink(srv.URL+"/v1/traces", nil))
+	require.NoError(t, e.Add(decode(t, testLine)))
+	require.NoError(t, e.Flush(context.Background()))
+	assert.Contains(t, string(got), `"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`)
+
+	status = http.StatusBadRequest
+	require.NoError(t, e.Add(decode(t, testLine)))
+	assert.Error(t, e.Flush(context.Background()))
+	assert.EqualValues(t, 1, e.Dropped())
+}
+
+func TestExporterIgnoresOtherLogs(t *testing.T) {
+	var buf bytes.Buffer
+	e := New(Config{BatchSize: 1}, WriterSink(&buf))
+	e.HandleLog("not a request")
+	e.HandleError(io.EOF)
+	assert.NoError(t, e.Err())
+	assert.Zero(t, buf.Len())
+}
+
+// blockingSink waits for release before each export
+type blockingSink struct {
+	release chan struct{}
+	got     chan int
+}
+
+func (s *blockingSink) Export(ctx context.Context, payload []byte) error {
+	<-s.release
+	var req ExportRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return err
+	}
+	s.got <- len(req.ResourceSpans[0].ScopeSpans[0].Spans)
+	return nil
+}
+
+func TestExporterQueue(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{}), got: make(chan int, 10)}
+	e := New(Config{BatchSize: 2, QueueSize: 1}, sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.RunFlusher(ctx, time.Hour, nil)
+		close(done)
+	}()
+	r := decode(t, testLine)
+	// first batch is taken by the flusher, which is stuck in the sink
+	require.NoError(t, e.Add(r))
+	require.NoError(t, e.Add(r))
+	require.Eventually(t, func() bool { return len(e.full) == 0 }, 5*time.Second, time.Millisecond)
+	// second one waits in the queue, third is dropped without blocking
+	for i := 0; i < 3; i++ {
+		require.NoError(t, e.Add(r))
+	}
+	assert.Error(t, e.Add(r))
+	assert.EqualValues(t, 2, e.Dropped())
+	e.HandleLog(r)
+	assert.NoError(t, e.Err())
+	e.HandleLog(r)
+	assert.Error(t, e.Err())
+
+	close(sink.release)
+	assert.Equal(t, 2, <-sink.got)
+	assert.Equal(t, 2, <-sink.got)
+	require.NoError(t, e.Add(r))
+	cancel()
+	<-done
+	// unfinished batch is flushed on exit
+	assert.Equal(t, 1, <-sink.got)
+	assert.EqualValues(t, 4, e.Dropped())
+}
@@ -0,0 +1,285 @@
+// Package otlp turns decoded haproxy requests into OpenTelemetry spans and writes them as OTLP/JSON,
+// so HAProxy hops show up in traces next to the services behind it
+package otlp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	haproxy "github.com/efigence/go-haproxy"
+)
+
+// span kinds and status codes from the OTLP spec
+const (
+	SpanKindServer  = 2
+	StatusCodeUnset = 0
+	StatusCodeOK    = 1
+	StatusCodeError = 2
+)
+
+// ExportRequest is body of OTLP/JSON trace export (ExportTraceServiceRequest), as sent to collector's /v1/traces
+type ExportRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+// ResourceSpans are spans of single resource (haproxy instance)
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+// Resource describes what produced the spans
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// ScopeSpans are spans made by single instrumentation scope
+type ScopeSpans struct {
+	Scope Scope  `json:"scope"`
+	Spans []Span `json:"spans"`
+}
+
+// Scope is the instrumentation scope, this package
+type Scope struct {
+	Name string `json:"name"`
+}
+
+// Span is OTLP span. IDs are hex, timestamps are decimal strings as OTLP/JSON encodes 64-bit ints
+type Span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes,omitempty"`
+	Events            []Event    `json:"events,omitempty"`
+	Status            Status     `json:"status"`
+}
+
+// Event is point in time within span, used for request phases
+type Event struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []KeyValue `json:"attributes,omitempty"`
+}
+
+// Status is span status, code is one of StatusCode*
+type Status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// KeyValue is an attribute, use String and Int to make them
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue has exactly one of the fields set
+type AnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+// String makes string attribute
+func String(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: &value}}
+}
+
+// Int makes int attribute
+func Int(key string, value int64) KeyValue {
+	v := strconv.FormatInt(value, 10)
+	return KeyValue{Key: key, Value: AnyValue{IntValue: &v}}
+}
+
+// Config configures how spans are made
+type Config struct {
+	// service.name of the resource, "haproxy" by default
+	ServiceName string
+	// extra resource attributes, like host.name or deployment.environment
+	ResourceAttributes map[string]string
+	// captured request header (see haproxy.HTTPLogDecoder) with W3C traceparent of upstream trace, "traceparent" by default.
+	// If it is missing UniqueID is tried, it can be either traceparent or bare 32-char trace ID
+	TraceHeader string
+	// spans per export, 512 by default
+	BatchSize int
+	// full batches waiting for Exporter.RunFlusher, 4 by default. When it can't keep up, new batches are dropped
+	QueueSize int
+}
+
+func (c *Config) setDefaults() {
+	if c.ServiceName == "" {
+		c.ServiceName = "haproxy"
+	}
+	if c.TraceHeader == "" {
+		c.TraceHeader = "traceparent"
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 512
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 4
+	}
+}
+
+// NewSpan makes span out of request. Start is request's Timestamp() and duration TotalTimeMs().
+// Queue, connect and response phases are added as events, skipping ones that didn't happen.
+//
+// Trace and parent IDs come from the upstream traceparent if there is one.
+// Otherwise IDs are derived from the request, so processing same logs twice gives the same spans
+func NewSpan(cfg Config, r *haproxy.HTTPRequest) Span {
+	cfg.setDefaults()
+	traceID, parentID := traceContext(cfg, r)
+	seed := strings.Join([]string{
+		strconv.FormatInt(r.TS, 10), r.ClientIP, strconv.Itoa(int(r.ClientPort)), r.FrontendName, r.UniqueID,
+	}, "\x00")
+	if traceID == "" {
+		traceID = hashID("trace\x00"+seed, 16)
+	}
+	start := r.TS * 1000
+	end := start
+	if total := r.TotalTimeMs(); total > 0 {
+		end += int64(total) * 1000000
+	}
+	s := Span{
+		TraceID:           traceID,
+		SpanID:            hashID(traceID+"\x00"+seed, 8),
+		ParentSpanID:      parentID,
+		Name:              spanName(r),
+		Kind:              SpanKindServer,
+		StartTimeUnixNano: strconv.FormatInt(start, 10),
+		EndTimeUnixNano:   strconv.FormatInt(end, 10),
+		Attributes:        spanAttributes(r),
+		Events:            spanEvents(r, start),
+	}
+	ts := r.TerminationState()
+	switch {
+	case r.StatusCode >= 500:
+		s.Status = Status{Code: StatusCodeError, Message: "HTTP " + strconv.Itoa(int(r.StatusCode))}
+	case ts.Class() == haproxy.TerminationServerSide || ts.Class() == haproxy.TerminationProxySide:
+		s.Status = Status{Code: StatusCodeError, Message: ts.Description()}
+	}
+	return s
+}
+
+func spanName(r *haproxy.HTTPRequest) string {
+	if r.BadReq || r.RequestMethod == "" {
+		return "haproxy " + r.FrontendName
+	}
+	return r.RequestMethod + " " + r.BackendName
+}
+
+func spanAttributes(r *haproxy.HTTPRequest) []KeyValue {
+	attrs := []KeyValue{
+		String("client.address", r.ClientIP),
+		Int("client.port", int64(r.ClientPort)),
+		Int("http.response.status_code", int64(r.StatusCode)),
+		String("haproxy.frontend", r.FrontendName),
+		String("haproxy.backend", r.BackendName),
+		String("haproxy.server", r.ServerName),
+		String("haproxy.termination_state", string(r.TerminationState())),
+		String("haproxy.termination_class", string(r.TerminationState().Class())),
+		Int("haproxy.bytes_read", int64(r.BytesRead)),
+		Int("haproxy.retries", int64(r.Retries)),
+	}
+	if !r.BadReq && r.RequestMethod != "" {
+		attrs = append(attrs, String("http.request.method", r.RequestMethod), String("url.path", r.Path()))
+		if scheme := r.Scheme(); scheme != "" {
+			attrs = append(attrs, String("url.scheme", scheme))
+		}
+		if v := r.ProtoString(); strings.HasPrefix(v, "HTTP/") {
+			attrs = append(attrs, String("network.protocol.name", "http"), String("network.protocol.version", v[5:]))
+		}
+	}
+	if r.UniqueID != "" {
+		attrs = append(attrs, String("haproxy.unique_id", r.UniqueID))
+	}
+	return attrs
+}
+
+// spanEvents marks ends of request phases. Timers are consecutive, -1 means the phase (and all after it) didn't happen
+func spanEvents(r *haproxy.HTTPRequest, start int64) []Event {
+	var events []Event
+	at := start
+	for _, p := range []struct {
+		name string
+		ms   int
+	}{
+		{"request", r.RequestTimeMs()},
+		{"queue", r.QueueDurationMs},
+		{"connect", r.ServerConnDurationMs},
+		{"response", r.ResponseHeaderDurationMs},
+	} {
+		if p.ms < 0 {
+			break
+		}
+		at += int64(p.ms) * 1000000
+		events = append(events, Event{
+			TimeUnixNano: strconv.FormatInt(at, 10),
+			Name:         "haproxy." + p.name,
+			Attributes:   []KeyValue{Int("duration_ms", int64(p.ms))},
+		})
+	}
+	return events
+}
+
+// traceContext finds upstream trace ID and parent span ID
+func traceContext(cfg Config, r *haproxy.HTTPRequest) (traceID, parentID string) {
+	for k, v := range r.RequestHeaders {
+		if strings.EqualFold(k, cfg.TraceHeader) {
+			if traceID, parentID, ok := ParseTraceparent(v); ok {
+				return traceID, parentID
+			}
+		}
+	}
+	if traceID, parentID, ok := ParseTraceparent(r.UniqueID); ok {
+		return traceID, parentID
+	}
+	if id := strings.ToLower(r.UniqueID); isID(id, 32) {
+		return id, ""
+	}
+	return "", ""
+}
+
+// ParseTraceparent parses W3C traceparent header (00-<trace id>-<parent id>-<flags>)
+func ParseTraceparent(s string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return "", "", false
+	}
+	// future versions can append fields, version 00 can't
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", false
+	}
+	if !isID(parts[1], 32) || !isID(parts[2], 16) {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// isID checks for lowercase hex ID of given length that is not all zeros
+func isID(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	zero := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+		if c != '0' {
+			zero = false
+		}
+	}
+	return !zero
+}
+
+func hashID(s string, length int) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:length])
+}
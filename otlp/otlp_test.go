@@ -0,0 +1,96 @@
+package otlp
+
+import (
+	"testing"
+	"time"
+
+	haproxy "github.com/efigence/go-haproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLine = `<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1~ app/app3 10/2/3/50/100 200 1140 - - ---- 1637/7/5/6/0 0/0 {00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01} "GET /q?x=1 HTTP/1.1"`
+
+func decode(t *testing.T, line string) *haproxy.HTTPRequest {
+	t.Helper()
+	haproxy.HaproxyLogTimezone = time.UTC
+	dec := haproxy.HTTPLogDecoder{Captures: haproxy.CaptureLayout{Request: []string{"traceparent"}}}
+	r, err := dec.Decode(line)
+	require.NoError(t, err)
+	return &r
+}
+
+func attr(s Span, key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			if a.Value.StringValue != nil {
+				return *a.Value.StringValue
+			}
+			return *a.Value.IntValue
+		}
+	}
+	return ""
+}
+
+func TestNewSpan(t *testing.T) {
+	r := decode(t, testLine)
+	s := NewSpan(Config{}, r)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", s.ParentSpanID)
+	assert.Len(t, s.SpanID, 16)
+	assert.Equal(t, "GET app", s.Name)
+	assert.Equal(t, SpanKindServer, s.Kind)
+	assert.Equal(t, "1437659351933000000", s.StartTimeUnixNano)
+	assert.Equal(t, "1437659352033000000", s.EndTimeUnixNano)
+	assert.Equal(t, "200", attr(s, "http.response.status_code"))
+	assert.Equal(t, "app3", attr(s, "haproxy.server"))
+	assert.Equal(t, "----", attr(s, "haproxy.termination_state"))
+	assert.Equal(t, "/q", attr(s, "url.path"))
+	assert.Equal(t, "1.1", attr(s, "network.protocol.version"))
+	assert.Equal(t, Status{}, s.Status)
+	require.Len(t, s.Events, 4)
+	assert.Equal(t, "haproxy.request", s.Events[0].Name)
+	assert.Equal(t, "1437659351943000000", s.Events[0].TimeUnixNano)
+	assert.Equal(t, "haproxy.response", s.Events[3].Name)
+	assert.Equal(t, "1437659351998000000", s.Events[3].TimeUnixNano)
+
+	// same request gives same span
+	assert.Equal(t, s, NewSpan(Config{}, r))
+}
+
+func TestNewSpanWithoutTraceparent(t *testing.T) {
+	r := decode(t, `<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1~ app/<NOSRV> 10/-1/-1/-1/5010 503 212 - - sQ-- 1637/7/5/6/0 0/0 {} "GET / HTTP/1.1"`)
+	s := NewSpan(Config{}, r)
+	assert.Len(t, s.TraceID, 32)
+	assert.Empty(t, s.ParentSpanID)
+	assert.Equal(t, StatusCodeError, s.Status.Code)
+	require.Len(t, s.Events, 1)
+	assert.Equal(t, "haproxy.request", s.Events[0].Name)
+
+	r.UniqueID = "0AF7651916CD43DD8448EB211C80319C"
+	s = NewSpan(Config{}, r)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", s.TraceID)
+	assert.Empty(t, s.ParentSpanID)
+}
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, parentID, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, "00f067aa0ba902b7", parentID)
+	_, _, ok = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+	assert.True(t, ok)
+	for _, bad := range []string{
+		"",
+		"-",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		_, _, ok := ParseTraceparent(bad)
+		assert.False(t, ok, bad)
+	}
+}
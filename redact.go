@@ -0,0 +1,266 @@
+package haproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// RedactedValue replaces scrubbed values when there is no hash key to make pseudonyms with
+const RedactedValue = "redacted"
+
+// RedactConfig says what Redactor removes. Zero value doesn't change anything
+type RedactConfig struct {
+	// keep only that many leading bits of client address, e.g. 24 for IPv4 and 48 for IPv6. 0 keeps whole address
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+	// if set, client address (after masking) and scrubbed values are replaced with keyed hash,
+	// so same input gives same pseudonym across runs and files as long as the key is the same
+	HashKey []byte
+	// query parameters which values are scrubbed from RequestPath, and from captures and Extra values
+	// that look like URL (e.g. captured Referer)
+	QueryParams []string
+	// names of captured cookies which values are scrubbed, "*" scrubs all of them. Besides `capture cookie` ones
+	// this covers captures and Extra values that look like Cookie or Set-Cookie header
+	Cookies []string
+	// drop CapturedSamples, decoded RequestHeaders/ResponseHeaders and CaptureError
+	DropCaptures bool
+}
+
+// Redactor removes PII from decoded requests. It is safe for concurrent use
+type Redactor struct {
+	cfg         RedactConfig
+	queryParams map[string]bool
+	cookies     map[string]bool
+	v4mask      net.IPMask
+	v6mask      net.IPMask
+}
+
+// NewRedactor creates Redactor from config
+func NewRedactor(cfg RedactConfig) *Redactor {
+	r := &Redactor{
+		cfg:         cfg,
+		queryParams: make(map[string]bool),
+		cookies:     make(map[string]bool),
+	}
+	for _, p := range cfg.QueryParams {
+		r.queryParams[p] = true
+	}
+	for _, c := range cfg.Cookies {
+		r.cookies[c] = true
+	}
+	if cfg.IPv4PrefixLen > 0 {
+		r.v4mask = net.CIDRMask(cfg.IPv4PrefixLen, 32)
+	}
+	if cfg.IPv6PrefixLen > 0 {
+		r.v6mask = net.CIDRMask(cfg.IPv6PrefixLen, 128)
+	}
+	return r
+}
+
+// Redact returns copy of the request with configured fields scrubbed, maps are copied rather than modified.
+// Captures and Extra values are scrubbed by what they look like, as there is no telling which header they came from.
+// Parameter and cookie names are matched as logged, so ones haproxy #XX-encoded in CapturedSamples are missed
+func (r *Redactor) Redact(h HTTPRequest) HTTPRequest {
+	h.ClientIP = r.redactIP(h.ClientIP)
+	if len(r.queryParams) > 0 && !h.BadReq {
+		h.RequestPath = r.redactQuery(h.RequestPath)
+	}
+	if len(r.cookies) > 0 {
+		h.CapturedRequestCookie = r.redactCookie(h.CapturedRequestCookie)
+		h.CapturedResponseCookie = r.redactCookie(h.CapturedResponseCookie)
+	}
+	if len(r.queryParams) > 0 || len(r.cookies) > 0 {
+		// CaptureError quotes captures, so it is scrubbed the same way
+		h.CapturedSamples = r.redactCaptures(h.CapturedSamples)
+		h.CaptureError = r.redactCaptures(h.CaptureError)
+		h.RequestHeaders = r.redactMap(h.RequestHeaders)
+		h.ResponseHeaders = r.redactMap(h.ResponseHeaders)
+		h.Extra = r.redactMap(h.Extra)
+	}
+	if r.cfg.DropCaptures {
+		h.CapturedSamples = ""
+		h.RequestHeaders = nil
+		h.ResponseHeaders = nil
+		h.CaptureError = ""
+	}
+	return h
+}
+
+// Pseudonym returns keyed hash of s, or RedactedValue if there is no key
+func (r *Redactor) Pseudonym(s string) string {
+	if len(r.cfg.HashKey) == 0 {
+		return RedactedValue
+	}
+	mac := hmac.New(sha256.New, r.cfg.HashKey)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func (r *Redactor) redactIP(s string) string {
+	if r.v4mask == nil && r.v6mask == nil && len(r.cfg.HashKey) == 0 {
+		return s
+	}
+	// haproxy logs IPv6 without brackets, but be lenient
+	ip := net.ParseIP(strings.Trim(s, "[]"))
+	switch {
+	case ip == nil:
+		// unix socket or something we can't mask
+		if s == "unix" {
+			return s
+		}
+		return r.Pseudonym(s)
+	case ip.To4() != nil:
+		if r.v4mask != nil {
+			s = ip.To4().Mask(r.v4mask).String()
+		}
+	case r.v6mask != nil:
+		s = ip.Mask(r.v6mask).String()
+	}
+	if len(r.cfg.HashKey) > 0 {
+		return r.Pseudonym(s)
+	}
+	return s
+}
+
+// redactQuery replaces values of configured params, leaving the rest of the path as it was
+func (r *Redactor) redactQuery(path string) string {
+	q := strings.IndexByte(path, '?')
+	if q < 0 {
+		return path
+	}
+	query := path[q+1:]
+	fragment := ""
+	if i := strings.IndexByte(query, '#'); i >= 0 {
+		query, fragment = query[:i], query[i:]
+	}
+	pairs := strings.Split(query, "&")
+	changed := false
+	for i, pair := range pairs {
+		eq := strings.IndexByte(pair, '=')
+		if eq < 0 {
+			continue
+		}
+		key, err := url.QueryUnescape(pair[:eq])
+		if err != nil {
+			key = pair[:eq]
+		}
+		if !r.queryParams[key] {
+			continue
+		}
+		value, err := url.QueryUnescape(pair[eq+1:])
+		if err != nil {
+			value = pair[eq+1:]
+		}
+		pairs[i] = pair[:eq+1] + url.QueryEscape(r.Pseudonym(value))
+		changed = true
+	}
+	if !changed {
+		return path
+	}
+	return path[:q+1] + strings.Join(pairs, "&") + fragment
+}
+
+// redactValue scrubs query params and cookies from captured header or log-format variable
+func (r *Redactor) redactValue(s string) string {
+	if len(r.queryParams) > 0 {
+		s = r.redactQuery(s)
+	}
+	if len(r.cookies) > 0 {
+		s = r.redactCookies(s)
+	}
+	return s
+}
+
+func (r *Redactor) redactMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = r.redactValue(v)
+	}
+	return out
+}
+
+// redactCaptures scrubs every field of raw ` {a|b} {c}` captures, escapes are kept as they were
+func (r *Redactor) redactCaptures(s string) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	var b strings.Builder
+	// start of current field, -1 outside of block
+	start := -1
+	for i := 0; i < len(s); i++ {
+		switch {
+		case start < 0:
+			b.WriteByte(s[i])
+			if s[i] == '{' {
+				start = i + 1
+			}
+		case s[i] == '\\':
+			i++
+		case s[i] == '|' || s[i] == '}':
+			b.WriteString(r.redactValue(s[start:i]))
+			b.WriteByte(s[i])
+			start = i + 1
+			if s[i] == '}' {
+				start = -1
+			}
+		}
+	}
+	if start >= 0 && start < len(s) {
+		// unterminated block
+		b.WriteString(r.redactValue(s[start:]))
+	}
+	return b.String()
+}
+
+// Set-Cookie attributes, value that has any of them is a single cookie
+var setCookieAttrs = map[string]bool{
+	"path": true, "domain": true, "expires": true, "max-age": true, "samesite": true, "secure": true, "httponly": true, "partitioned": true,
+}
+
+// redactCookies scrubs configured cookies from value that looks like Cookie (`a=1; b=2`) or Set-Cookie header,
+// anything else is returned as it was
+func (r *Redactor) redactCookies(s string) string {
+	parts := strings.Split(s, ";")
+	for i, part := range parts {
+		name, _, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if i > 0 && setCookieAttrs[strings.ToLower(name)] {
+			return r.redactCookie(s)
+		}
+		if !ok || name == "" || strings.ContainsAny(name, " \t\"(),/:<>?@[\\]{}") {
+			return s
+		}
+	}
+	for i, part := range parts {
+		parts[i] = r.redactCookie(part)
+	}
+	return strings.Join(parts, ";")
+}
+
+// redactCookie replaces value of captured `name=value[; attrs]`, attributes are kept
+func (r *Redactor) redactCookie(s string) string {
+	if s == "" || s == "-" {
+		return s
+	}
+	eq := strings.IndexByte(s, '=')
+	if eq < 0 {
+		return s
+	}
+	name := strings.TrimSpace(s[:eq])
+	if !r.cookies["*"] && !r.cookies[name] {
+		return s
+	}
+	end := strings.IndexByte(s[eq:], ';')
+	if end < 0 {
+		return s[:eq+1] + r.Pseudonym(s[eq+1:])
+	}
+	end += eq
+	return s[:eq+1] + r.Pseudonym(s[eq+1:end]) + s[end:]
+}
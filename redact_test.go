@@ -0,0 +1,95 @@
+package haproxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactIP(t *testing.T) {
+	r := NewRedactor(RedactConfig{IPv4PrefixLen: 24, IPv6PrefixLen: 48})
+	assert.Equal(t, "83.3.255.0", r.Redact(HTTPRequest{ClientIP: "83.3.255.169"}).ClientIP)
+	assert.Equal(t, "2001:db8:1::", r.Redact(HTTPRequest{ClientIP: "2001:db8:1:2:3::4"}).ClientIP)
+	assert.Equal(t, "unix", r.Redact(HTTPRequest{ClientIP: "unix"}).ClientIP)
+	assert.Equal(t, RedactedValue, r.Redact(HTTPRequest{ClientIP: "11174.211.190"}).ClientIP)
+
+	// untouched without config
+	assert.Equal(t, "83.3.255.169", NewRedactor(RedactConfig{}).Redact(HTTPRequest{ClientIP: "83.3.255.169"}).ClientIP)
+
+	hashed := NewRedactor(RedactConfig{HashKey: []byte("secret")})
+	a := hashed.Redact(HTTPRequest{ClientIP: "83.3.255.169"}).ClientIP
+	assert.Len(t, a, 16)
+	assert.Equal(t, a, hashed.Redact(HTTPRequest{ClientIP: "83.3.255.169"}).ClientIP)
+	assert.NotEqual(t, a, hashed.Redact(HTTPRequest{ClientIP: "83.3.255.170"}).ClientIP)
+	assert.NotEqual(t, a, NewRedactor(RedactConfig{HashKey: []byte("other")}).Redact(HTTPRequest{ClientIP: "83.3.255.169"}).ClientIP)
+
+	// masked then hashed, same subnet gets same pseudonym
+	subnet := NewRedactor(RedactConfig{HashKey: []byte("secret"), IPv4PrefixLen: 24})
+	assert.Equal(t, subnet.Redact(HTTPRequest{ClientIP: "83.3.255.169"}).ClientIP, subnet.Redact(HTTPRequest{ClientIP: "83.3.255.1"}).ClientIP)
+}
+
+func TestRedactQueryAndCookies(t *testing.T) {
+	r := NewRedactor(RedactConfig{QueryParams: []string{"token", "e mail"}, Cookies: []string{"SESSID"}, DropCaptures: true})
+	h := r.Redact(HTTPRequest{
+		RequestPath:            "/login?token=abc&x=1&e+mail=a%40b.c&token=&y#frag",
+		CapturedRequestCookie:  "SESSID=abc123",
+		CapturedResponseCookie: "SESSID=def; path=/",
+		CapturedSamples:        " {example.com}",
+		RequestHeaders:         map[string]string{"Host": "example.com"},
+	})
+	assert.Equal(t, "/login?token=redacted&x=1&e+mail=redacted&token=redacted&y#frag", h.RequestPath)
+	assert.Equal(t, "SESSID=redacted", h.CapturedRequestCookie)
+	assert.Equal(t, "SESSID=redacted; path=/", h.CapturedResponseCookie)
+	assert.Empty(t, h.CapturedSamples)
+	assert.Nil(t, h.RequestHeaders)
+
+	// other cookies and paths without query are kept
+	h = r.Redact(HTTPRequest{RequestPath: "/a/b", CapturedRequestCookie: "lang=pl", CapturedResponseCookie: "-"})
+	assert.Equal(t, "/a/b", h.RequestPath)
+	assert.Equal(t, "lang=pl", h.CapturedRequestCookie)
+	assert.Equal(t, "-", h.CapturedResponseCookie)
+
+	hashed := NewRedactor(RedactConfig{HashKey: []byte("secret"), QueryParams: []string{"uid"}, Cookies: []string{"*"}})
+	h = hashed.Redact(HTTPRequest{RequestPath: "/?uid=42", CapturedRequestCookie: "lang=pl"})
+	assert.Equal(t, "/?uid="+hashed.Pseudonym("42"), h.RequestPath)
+	assert.Equal(t, "lang="+hashed.Pseudonym("pl"), h.CapturedRequestCookie)
+}
+
+func TestRedactCapturesAndExtra(t *testing.T) {
+	r := NewRedactor(RedactConfig{QueryParams: []string{"token"}, Cookies: []string{"SESSID"}})
+	in := HTTPRequest{
+		CapturedSamples: ` {example.com|https://example.com/a?token=abc\|x&b=1|lang=pl; SESSID=abc} {SESSID=def; Path=/; HttpOnly|/next?token=x}`,
+		CaptureError:    `expected 1 capture blocks, got 2 in [ {example.com|lang=pl; SESSID=abc} {/next?token=x}]`,
+		RequestHeaders: map[string]string{
+			"Host":    "example.com",
+			"Referer": "https://example.com/a?token=abc|x&b=1",
+			"Cookie":  "lang=pl; SESSID=abc",
+		},
+		ResponseHeaders: map[string]string{"Set-Cookie": "SESSID=def; Path=/; HttpOnly", "Location": "/next?token=x"},
+		Extra:           map[string]string{"[req.hdr(cookie)]": "SESSID=abc", "[var(txn.url)]": "/?token=abc", "tenant": "acme"},
+	}
+	h := r.Redact(in)
+	assert.Equal(t, ` {example.com|https://example.com/a?token=redacted&b=1|lang=pl; SESSID=redacted} {SESSID=redacted; Path=/; HttpOnly|/next?token=redacted}`, h.CapturedSamples)
+	assert.Equal(t, `expected 1 capture blocks, got 2 in [ {example.com|lang=pl; SESSID=redacted} {/next?token=redacted}]`, h.CaptureError)
+	assert.Equal(t, map[string]string{
+		"Host":    "example.com",
+		"Referer": "https://example.com/a?token=redacted&b=1",
+		"Cookie":  "lang=pl; SESSID=redacted",
+	}, h.RequestHeaders)
+	assert.Equal(t, map[string]string{"Set-Cookie": "SESSID=redacted; Path=/; HttpOnly", "Location": "/next?token=redacted"}, h.ResponseHeaders)
+	assert.Equal(t, map[string]string{"[req.hdr(cookie)]": "SESSID=redacted", "[var(txn.url)]": "/?token=redacted", "tenant": "acme"}, h.Extra)
+	// maps of the original are left alone
+	assert.Equal(t, "lang=pl; SESSID=abc", in.RequestHeaders["Cookie"])
+	assert.Equal(t, "SESSID=abc", in.Extra["[req.hdr(cookie)]"])
+
+	// things that only look a bit like cookies are kept, even with all cookies scrubbed
+	all := NewRedactor(RedactConfig{Cookies: []string{"*"}})
+	for _, v := range []string{"Mozilla/5.0 (X11; Linux x86_64)", "/a?b=c", "a=1;", "x y=1"} {
+		assert.Equal(t, v, all.Redact(HTTPRequest{Extra: map[string]string{"v": v}}).Extra["v"], v)
+	}
+	assert.Equal(t, "a=redacted; b=redacted", all.Redact(HTTPRequest{Extra: map[string]string{"v": "a=1; b=2"}}).Extra["v"])
+
+	h = NewRedactor(RedactConfig{DropCaptures: true}).Redact(in)
+	assert.Empty(t, h.CaptureError)
+	assert.Equal(t, in.Extra, h.Extra)
+}
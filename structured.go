@@ -0,0 +1,253 @@
+package haproxy
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// decoders for logs encoded with `%{+json}o` / `%{+cbor}o` (haproxy 2.7+) or JSON built by hand in log-format.
+// Keys are either log-format variable names (`ci`, `TR`, `[var(txn.x)]`), or names of HTTPRequest JSON fields (`client_ip`)
+
+// structuredFieldNames maps HTTPRequest JSON field names to log-format variables with the same meaning
+var structuredFieldNames = map[string]string{
+	"client_ip":                   "ci",
+	"client_port":                 "cp",
+	"frontend_name":               "f",
+	"backend_name":                "b",
+	"server_name":                 "s",
+	"status_code":                 "ST",
+	"bytes_read":                  "B",
+	"captured_request_cookie":     "CC",
+	"captured_response_cookie":    "CS",
+	"request_header_duration_ms":  "Tq",
+	"queue_duration_ms":           "Tw",
+	"server_conn_duration_ms":     "Tc",
+	"response_header_duration_ms": "Tr",
+	"total_duration_ms":           "Tt",
+	"request_receive_duration_ms": "TR",
+	"active_duration_ms":          "Ta",
+	"idle_duration_ms":            "Ti",
+	"http_method":                 "HM",
+	"http_path":                   "HU",
+	"http_version":                "HV",
+	"total_conn":                  "ac",
+	"frontend_conn":               "fc",
+	"backend_conn":                "bc",
+	"server_conn":                 "sc",
+	"retries":                     "rc",
+	"server_queue":                "sq",
+	"backend_queue":               "bq",
+	"unique_id":                   "ID",
+	"pid":                         "pid",
+}
+
+// structuredRanks orders keys that set the same field, higher rank is applied later and wins.
+// Composite and less precise variables go first, the rest have rank 0
+var structuredRanks = map[string]int{
+	"r":     -2,
+	"HP":    -1,
+	"f":     -1,
+	"ts":    -1,
+	"hrl":   -1,
+	"hsl":   -1,
+	"T":     -1,
+	"Tl":    -1,
+	"trg":   -1,
+	"trl":   -1,
+	"ts_us": 1,
+}
+
+// structuredKeyRank is rank of the key, JSON field names go right before log-format variable with the same meaning
+func structuredKeyRank(key string) int {
+	if name, ok := structuredFieldNames[key]; ok {
+		return 2*structuredRanks[name] - 1
+	}
+	return 2 * structuredRanks[key]
+}
+
+var cborDecMode, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}{})}.DecMode()
+
+// DecodeJSONLog decodes log line with JSON object as a message into HTTPRequest.
+// Keys without corresponding field are put in Extra, non-string values there are kept JSON-encoded
+func DecodeJSONLog(s string) (HTTPRequest, error) {
+	return decodeJSONLog(s, CaptureLayout{})
+}
+
+func decodeJSONLog(s string, captures CaptureLayout) (HTTPRequest, error) {
+	var r HTTPRequest
+	var err error
+	var msg string
+	r.Syslog, msg, err = DecodeSyslog(s)
+	if err != nil {
+		return r, err
+	}
+	r.PID = r.Syslog.PID
+	dec := json.NewDecoder(strings.NewReader(msg))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return r, errors.New(fmt.Sprintf("can't decode JSON log: %s", err))
+	}
+	return r, decodeStructured(&r, fields, captures)
+}
+
+// DecodeCBORLog decodes log line with CBOR map as a message into HTTPRequest. Message can be either hex-encoded (haproxy's default)
+// or raw binary (+bin). Keys without corresponding field are put in Extra, non-string values there are JSON-encoded.
+// Binary is fragile, trailing bytes that look like whitespace are trimmed along with the line ending, so use hex if possible
+func DecodeCBORLog(s string) (HTTPRequest, error) {
+	return decodeCBORLog(s, CaptureLayout{})
+}
+
+func decodeCBORLog(s string, captures CaptureLayout) (HTTPRequest, error) {
+	var r HTTPRequest
+	var err error
+	var msg string
+	r.Syslog, msg, err = DecodeSyslog(s)
+	if err != nil {
+		return r, err
+	}
+	r.PID = r.Syslog.PID
+	data := []byte(msg)
+	if isHexString(msg) {
+		data, err = hex.DecodeString(strings.TrimSpace(msg))
+		if err != nil {
+			return r, err
+		}
+	}
+	var fields map[string]interface{}
+	if err := cborDecMode.Unmarshal(data, &fields); err != nil {
+		return r, errors.New(fmt.Sprintf("can't decode CBOR log: %s", err))
+	}
+	return r, decodeStructured(&r, fields, captures)
+}
+
+func decodeStructured(r *HTTPRequest, fields map[string]interface{}, layout CaptureLayout) error {
+	ms := -1
+	var unixTs int64 = -1
+	var captures [2]string
+	// fixed order, so result doesn't depend on map iteration when both `r` and `HU` (or `ci` and `client_ip`) are there
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := structuredKeyRank(keys[i]), structuredKeyRank(keys[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		value := fields[key]
+		if value == nil {
+			continue
+		}
+		v, err := structuredString(value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("can't decode %s: %s", key, err))
+		}
+		name := key
+		if n, ok := structuredFieldNames[key]; ok {
+			name = n
+		}
+		switch name {
+		case "ts_us":
+			r.TS, err = strconv.ParseInt(v, 10, 64)
+		case "client_ssl":
+			r.ClientSSL = v == "true" || v == "1"
+		case "Tq", "Tt":
+			r.LogVersion = HTTPLogV15
+		case "TR", "Ta":
+			if r.LogVersion == "" {
+				r.LogVersion = HTTPLogV17
+			}
+		}
+		if err != nil {
+			return errors.New(fmt.Sprintf("can't decode %s [%s]: %s", key, v, err))
+		}
+		if name == "ts_us" || name == "client_ssl" {
+			continue
+		}
+		if name == "hr" || name == "hrl" {
+			// request captures have to go first whatever the key order is
+			captures[0] = v
+			continue
+		}
+		if name == "hs" || name == "hsl" {
+			captures[1] = v
+			continue
+		}
+		if err := (logFormatField{name: name}).apply(r, v, &ms, &unixTs); err != nil {
+			return errors.New(fmt.Sprintf("can't decode %s [%s]: %s", key, v, err))
+		}
+	}
+	for _, c := range captures {
+		if c != "" {
+			r.CapturedSamples += " " + c
+		}
+	}
+	finishLogFormat(r, ms, unixTs, layout)
+	return nil
+}
+
+// structuredString converts decoded JSON/CBOR value into same string haproxy would log in text format
+func structuredString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []byte:
+		return string(v), nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func isHexString(s string) bool {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 || len(s)%2 != 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isHex(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// looksStructured returns decoder for message that looks like JSON object or CBOR map
+func looksStructured(msg string) func(string, CaptureLayout) (HTTPRequest, error) {
+	msg = strings.TrimSpace(msg)
+	if strings.HasPrefix(msg, "{") && strings.HasSuffix(msg, "}") && json.Valid([]byte(msg)) {
+		return decodeJSONLog
+	}
+	if isHexString(msg) {
+		// major type 5 (map)
+		if b, err := hex.DecodeString(msg[:2]); err == nil && b[0] >= 0xa0 && b[0] <= 0xbf {
+			return decodeCBORLog
+		}
+	}
+	// binary, that can't be a start of UTF-8 text
+	if msg != "" && msg[0] >= 0xa0 && msg[0] <= 0xbf {
+		return decodeCBORLog
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package haproxy
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const structuredTestLine = `<158>Sep  1 17:50:37 haproxy[1866]: 127.0.0.1:52320 [01/Sep/2022:17:50:37.898] default~ local-3001/<NOSRV> 0/-1/-1/-1/0 503 253 - - SC-- 1/1/0/0/0 0/0 {3001.localhost} {|} "GET /slow/default HTTP/1.1"`
+
+// same as structuredTestLine, as %{+json}o would log it
+var structuredTestFields = map[string]interface{}{
+	"ci": "127.0.0.1", "cp": 52320, "t": "01/Sep/2022:17:50:37.898", "ft": "default~", "b": "local-3001", "s": "<NOSRV>",
+	"Tq": 0, "Tw": -1, "Tc": -1, "Tr": -1, "Tt": 0, "ST": 503, "B": 253, "CC": "-", "CS": "-", "tsc": "SC--",
+	"ac": 1, "fc": 1, "bc": 0, "sc": 0, "rc": 0, "sq": 0, "bq": 0, "hs": "{|}", "hr": "{3001.localhost}",
+	"r": "GET /slow/default HTTP/1.1",
+}
+
+func TestDecodeJSONLog(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	expected, err := DecodeHTTPLog(structuredTestLine)
+	require.NoError(t, err)
+	out, err := DecodeJSONLog(`<158>Sep  1 17:50:37 haproxy[1866]: {"ci":"127.0.0.1","cp":52320,"t":"01/Sep/2022:17:50:37.898","ft":"default~","b":"local-3001","s":"<NOSRV>",` +
+		`"Tq":0,"Tw":-1,"Tc":-1,"Tr":-1,"Tt":0,"ST":503,"B":253,"CC":"-","CS":"-","tsc":"SC--","ac":1,"fc":1,"bc":0,"sc":0,"rc":0,"sq":0,"bq":0,` +
+		`"hs":"{|}","hr":"{3001.localhost}","r":"GET /slow/default HTTP/1.1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, expected, out)
+
+	// field names of HTTPRequest and unknown fields
+	decoded, err := DecodeLog(`<158>Sep  1 17:50:37 haproxy[1866]: {"client_ip":"10.0.0.1","ts_us":1662054637898000,"status_code":200,"http_method":"GET",` +
+		`"http_path":"/x","active_duration_ms":12,"team":"web","geo":{"country":"PL"},"missing":null}`)
+	require.NoError(t, err)
+	require.IsType(t, HTTPRequest{}, decoded)
+	r := decoded.(HTTPRequest)
+	assert.Equal(t, 1866, r.PID)
+	assert.Equal(t, "10.0.0.1", r.ClientIP)
+	assert.Equal(t, int64(1662054637898000), r.TS)
+	assert.EqualValues(t, 200, r.StatusCode)
+	assert.Equal(t, "/x", r.RequestPath)
+	assert.Equal(t, 12, r.ActiveDurationMs)
+	assert.Equal(t, 0, r.TotalDurationMs)
+	assert.Equal(t, 12, r.TotalTimeMs())
+	assert.Equal(t, HTTPLogV17, r.LogVersion)
+	assert.Equal(t, map[string]string{"team": "web", "geo": `{"country":"PL"}`}, r.Extra)
+
+	_, err = DecodeJSONLog(`{"ci":"10.0.0.1"`)
+	assert.Error(t, err)
+	_, err = DecodeJSONLog(`{"ST":"abc"}`)
+	assert.Error(t, err)
+}
+
+func TestDecodeStructuredAliases(t *testing.T) {
+	// several keys for the same field, more specific one wins whatever the map order is
+	line := `<158>Sep  1 17:50:37 haproxy[1866]: {"r":"GET /a?x=1 HTTP/1.1","HU":"/b?y=2","HP":"/b","client_ip":"10.0.0.2","ci":"10.0.0.1",` +
+		`"f":"plain","ft":"default~","ts":"SC","tsc":"sD--","hrl":"{list}","hr":"{host}","http_method":"POST"}`
+	for i := 0; i < 20; i++ {
+		r, err := DecodeJSONLog(line)
+		require.NoError(t, err)
+		assert.Equal(t, "POST", r.RequestMethod)
+		assert.Equal(t, "/b?y=2", r.RequestPath)
+		assert.Equal(t, "HTTP/1.1", r.HTTPVersion)
+		assert.Equal(t, "10.0.0.1", r.ClientIP)
+		assert.Equal(t, "default", r.FrontendName)
+		assert.True(t, r.ClientSSL)
+		assert.Equal(t, "sD--", string(r.TerminationState()))
+		assert.Equal(t, " {host}", r.CapturedSamples)
+	}
+}
+
+func TestDecodeCBORLog(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	expected, err := DecodeHTTPLog(structuredTestLine)
+	require.NoError(t, err)
+	// sorted keys so binary doesn't end with -1 (0x20) that gets trimmed as trailing whitespace
+	em, err := cbor.CanonicalEncOptions().EncMode()
+	require.NoError(t, err)
+	data, err := em.Marshal(structuredTestFields)
+	require.NoError(t, err)
+
+	// hex-encoded by default
+	out, err := DecodeLog(`<158>Sep  1 17:50:37 haproxy[1866]: ` + hex.EncodeToString(data))
+	require.NoError(t, err)
+	assert.Equal(t, expected, out)
+	// +bin
+	out, err = DecodeLog(`<158>Sep  1 17:50:37 haproxy[1866]: ` + string(data))
+	require.NoError(t, err)
+	assert.Equal(t, expected, out)
+
+	data, err = cbor.Marshal(map[string]interface{}{"ci": "10.0.0.1", "[var(txn.tags)]": []string{"a", "b"}, "sz": uint64(5)})
+	require.NoError(t, err)
+	r, err := DecodeCBORLog(hex.EncodeToString(data))
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", r.ClientIP)
+	assert.Equal(t, map[string]string{"[var(txn.tags)]": `["a","b"]`, "sz": "5"}, r.Extra)
+
+	_, err = DecodeCBORLog(`a1`)
+	assert.Error(t, err)
+}
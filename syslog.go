@@ -0,0 +1,333 @@
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyslogFormat is the header format haproxy used when sending the line (`log ... format <fmt>`)
+type SyslogFormat string
+
+const (
+	// <PRI>Mmm dd hh:mm:ss [hostname] tag[pid]: message, also "local" format without PRI
+	SyslogRFC3164 SyslogFormat = "rfc3164"
+	// <PRI>1 timestamp hostname tag pid msgid [sd] message
+	SyslogRFC5424 SyslogFormat = "rfc5424"
+	// <PRI>Mmm dd hh:mm:ss message
+	SyslogTimed SyslogFormat = "timed"
+	// <PRI>2006-01-02T15:04:05.000000+00:00 message
+	SyslogISO SyslogFormat = "iso"
+	// <PRI>message
+	SyslogShort SyslogFormat = "short"
+	// just the message
+	SyslogRaw SyslogFormat = "raw"
+)
+
+const syslog3164TimeFormat = "Jan _2 15:04:05"
+
+// what haproxy sends in iso and rfc5424 formats
+const syslogISOTimeFormat = "2006-01-02T15:04:05.000000-07:00"
+
+// SyslogHeader is the syslog envelope of log line.
+// Fields not present in given format are left empty, Facility and Severity are -1 if there was no priority
+type SyslogHeader struct {
+	Format    SyslogFormat `json:"format"`
+	Facility  int          `json:"facility"`
+	Severity  int          `json:"severity"`
+	Timestamp time.Time    `json:"timestamp"`
+	Hostname  string       `json:"hostname,omitempty"`
+	// app name, "haproxy" unless changed via log-tag
+	Tag   string `json:"tag,omitempty"`
+	PID   int    `json:"pid,omitempty"`
+	MsgID string `json:"msg_id,omitempty"`
+	// raw structured data block(s) of rfc5424, brackets included
+	StructuredData string `json:"structured_data,omitempty"`
+}
+
+// DecodeSyslog splits log line into syslog header and message.
+// Line without recognizable header is returned as SyslogRaw with whole line as message
+func DecodeSyslog(s string) (hdr SyslogHeader, msg string, err error) {
+	s = strings.TrimRight(s, "\r\n\t ")
+	hdr.Facility, hdr.Severity = -1, -1
+	hdr.Format = SyslogRaw
+	rest := s
+	if strings.HasPrefix(s, "<") {
+		end := strings.IndexByte(s, '>')
+		if end < 2 || end > 4 {
+			return hdr, s, errors.New(fmt.Sprintf("invalid syslog priority in [%.20s]", s))
+		}
+		pri, err := strconv.Atoi(s[1:end])
+		if err != nil || pri > 191 {
+			return hdr, s, errors.New(fmt.Sprintf("invalid syslog priority in [%.20s]", s))
+		}
+		hdr.Facility, hdr.Severity = pri/8, pri%8
+		hdr.Format = SyslogShort
+		rest = s[end+1:]
+	}
+	if strings.HasPrefix(rest, "1 ") {
+		msg, err = decode5424(&hdr, rest[2:])
+		return hdr, msg, err
+	}
+	if len(rest) >= 16 && rest[15] == ' ' {
+		if ts, err := time.ParseInLocation(syslog3164TimeFormat, rest[:15], HaproxyLogTimezone); err == nil {
+			hdr.Timestamp = addYear(ts)
+			hdr.Format = SyslogTimed
+			msg = decode3164Tag(&hdr, rest[16:])
+			return hdr, msg, nil
+		}
+	}
+	if sp := strings.IndexByte(rest, ' '); sp > 18 && rest[4] == '-' && rest[10] == 'T' {
+		if ts, err := time.Parse(time.RFC3339Nano, rest[:sp]); err == nil {
+			hdr.Timestamp = ts
+			hdr.Format = SyslogISO
+			// rsyslog's RSYSLOG_FileFormat and friends put hostname and tag after the timestamp
+			msg, _ = decodeHostTag(&hdr, rest[sp+1:])
+			return hdr, msg, nil
+		}
+	}
+	return hdr, rest, nil
+}
+
+// decode3164Tag parses optional hostname and tag after the timestamp
+func decode3164Tag(hdr *SyslogHeader, s string) string {
+	msg, ok := decodeHostTag(hdr, s)
+	if ok {
+		hdr.Format = SyslogRFC3164
+	}
+	// timed format if there was no tag
+	return msg
+}
+
+// decodeHostTag parses optional `hostname tag[pid]:`, returns whole s if there is no tag
+func decodeHostTag(hdr *SyslogHeader, s string) (string, bool) {
+	first, rest := cutSpace(s)
+	if decodeTag(hdr, first) {
+		return rest, true
+	}
+	second, rest2 := cutSpace(rest)
+	if decodeTag(hdr, second) {
+		hdr.Hostname = first
+		return rest2, true
+	}
+	return s, false
+}
+
+// decodeTag parses `tag[pid]:` or `tag:`
+func decodeTag(hdr *SyslogHeader, s string) bool {
+	if len(s) < 2 || s[len(s)-1] != ':' {
+		return false
+	}
+	s = s[:len(s)-1]
+	tag := s
+	pid := 0
+	if open := strings.IndexByte(s, '['); open >= 0 {
+		if s[len(s)-1] != ']' {
+			return false
+		}
+		var err error
+		pid, err = strconv.Atoi(s[open+1 : len(s)-1])
+		if err != nil {
+			return false
+		}
+		tag = s[:open]
+	}
+	if tag == "" || strings.ContainsAny(tag, ":]") {
+		return false
+	}
+	hdr.Tag = tag
+	hdr.PID = pid
+	return true
+}
+
+func decode5424(hdr *SyslogHeader, s string) (string, error) {
+	hdr.Format = SyslogRFC5424
+	var fields [5]string
+	rest := s
+	for i := range fields {
+		fields[i], rest = cutSpace(rest)
+		if fields[i] == "" {
+			return s, errors.New(fmt.Sprintf("truncated rfc5424 header in [%.40s]", s))
+		}
+	}
+	if fields[0] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return s, err
+		}
+		hdr.Timestamp = ts
+	}
+	hdr.Hostname = nilValue(fields[1])
+	hdr.Tag = nilValue(fields[2])
+	if fields[3] != "-" {
+		// procid can be anything, but haproxy always sends pid
+		hdr.PID, _ = strconv.Atoi(fields[3])
+	}
+	hdr.MsgID = nilValue(fields[4])
+	if strings.HasPrefix(rest, "-") {
+		rest = strings.TrimPrefix(rest[1:], " ")
+	} else if strings.HasPrefix(rest, "[") {
+		end := structuredDataEnd(rest)
+		if end < 0 {
+			return s, errors.New(fmt.Sprintf("unterminated structured data in [%.40s]", rest))
+		}
+		hdr.StructuredData = rest[:end]
+		rest = strings.TrimPrefix(rest[end:], " ")
+	} else {
+		return s, errors.New(fmt.Sprintf("invalid structured data in [%.40s]", rest))
+	}
+	return strings.TrimPrefix(rest, "\xef\xbb\xbf"), nil
+}
+
+// structuredDataEnd returns index after the last of consecutive [...] blocks, taking escapes into account
+func structuredDataEnd(s string) int {
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		inQuote := false
+		closed := false
+		for i++; i < len(s); i++ {
+			switch {
+			case s[i] == '\\' && inQuote:
+				i++
+			case s[i] == '"':
+				inQuote = !inQuote
+			case s[i] == ']' && !inQuote:
+				closed = true
+			}
+			if closed {
+				i++
+				break
+			}
+		}
+		if !closed {
+			return -1
+		}
+	}
+	return i
+}
+
+// String returns header as haproxy would send it, including space separating it from the message
+func (h SyslogHeader) String() string {
+	var b strings.Builder
+	if h.Facility >= 0 && h.Severity >= 0 {
+		b.WriteString("<" + strconv.Itoa(h.Facility*8+h.Severity) + ">")
+	}
+	switch h.Format {
+	case SyslogRFC3164:
+		b.WriteString(h.Timestamp.In(HaproxyLogTimezone).Format(syslog3164TimeFormat) + " ")
+		h.writeHostTag(&b)
+	case SyslogTimed:
+		b.WriteString(h.Timestamp.In(HaproxyLogTimezone).Format(syslog3164TimeFormat) + " ")
+	case SyslogISO:
+		b.WriteString(h.Timestamp.Format(syslogISOTimeFormat) + " ")
+		if h.Tag != "" {
+			h.writeHostTag(&b)
+		}
+	case SyslogRFC5424:
+		b.WriteString("1 ")
+		if h.Timestamp.IsZero() {
+			b.WriteString("- ")
+		} else {
+			b.WriteString(h.Timestamp.Format(syslogISOTimeFormat) + " ")
+		}
+		pid := "-"
+		if h.PID != 0 {
+			pid = strconv.Itoa(h.PID)
+		}
+		sd := h.StructuredData
+		if sd == "" {
+			sd = "-"
+		}
+		for _, f := range []string{h.Hostname, h.Tag, pid, h.MsgID, sd} {
+			if f == "" {
+				f = "-"
+			}
+			b.WriteString(f + " ")
+		}
+	}
+	return b.String()
+}
+
+func (h SyslogHeader) writeHostTag(b *strings.Builder) {
+	if h.Hostname != "" {
+		b.WriteString(h.Hostname + " ")
+	}
+	b.WriteString(h.Tag)
+	if h.PID != 0 {
+		b.WriteString("[" + strconv.Itoa(h.PID) + "]")
+	}
+	b.WriteString(": ")
+}
+
+// StructuredDataParams parses rfc5424 structured data into SD-ID => param => value
+func (h SyslogHeader) StructuredDataParams() map[string]map[string]string {
+	if h.StructuredData == "" {
+		return nil
+	}
+	out := make(map[string]map[string]string)
+	s := h.StructuredData
+	for len(s) > 0 && s[0] == '[' {
+		s = s[1:]
+		id, rest := cutSpace(s)
+		if i := strings.IndexByte(id, ']'); i >= 0 {
+			// element without params
+			out[id[:i]] = map[string]string{}
+			s = s[i+1:]
+			continue
+		}
+		params := make(map[string]string)
+		out[id] = params
+		s = rest
+		for len(s) > 0 && s[0] != ']' {
+			eq := strings.Index(s, `="`)
+			if eq < 0 {
+				return out
+			}
+			name := strings.TrimSpace(s[:eq])
+			var v strings.Builder
+			i := eq + 2
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				v.WriteByte(s[i])
+			}
+			params[name] = v.String()
+			if i < len(s) {
+				i++
+			}
+			s = strings.TrimLeft(s[i:], " ")
+		}
+		if len(s) > 0 {
+			s = s[1:]
+		}
+	}
+	return out
+}
+
+// addYear fills in year missing from rfc3164 timestamp, assuming the log is not from the future
+func addYear(ts time.Time) time.Time {
+	now := time.Now().In(ts.Location())
+	ts = ts.AddDate(now.Year(), 0, 0)
+	if ts.After(now.Add(24 * time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts
+}
+
+func cutSpace(s string) (string, string) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,157 @@
+package haproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSyslogMsg = `10.0.1.2:33313 [06/Feb/2009:12:12:51.443] fnt bck/srv1 0/0/5007 212 -- 0/0/0/0/3 0/0`
+
+func TestDecodeSyslog(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	year := time.Now().Year()
+	if time.Now().Month() < time.February {
+		year--
+	}
+	t.Run("rfc3164", func(t *testing.T) {
+		hdr, msg, err := DecodeSyslog("<134>Feb  6 12:12:56 haproxy[14387]: " + testSyslogMsg + "\n")
+		require.NoError(t, err)
+		assert.Equal(t, testSyslogMsg, msg)
+		assert.Equal(t, SyslogRFC3164, hdr.Format)
+		assert.Equal(t, 16, hdr.Facility)
+		assert.Equal(t, 6, hdr.Severity)
+		assert.Equal(t, "haproxy", hdr.Tag)
+		assert.Equal(t, 14387, hdr.PID)
+		assert.Equal(t, "", hdr.Hostname)
+		assert.Equal(t, time.Date(year, time.February, 6, 12, 12, 56, 0, time.UTC), hdr.Timestamp)
+	})
+	t.Run("rfc3164 with hostname", func(t *testing.T) {
+		hdr, msg, err := DecodeSyslog("<134>Feb 16 12:12:56 lb-1.example.com hap-edge[1]: " + testSyslogMsg)
+		require.NoError(t, err)
+		assert.Equal(t, testSyslogMsg, msg)
+		assert.Equal(t, SyslogRFC3164, hdr.Format)
+		assert.Equal(t, "lb-1.example.com", hdr.Hostname)
+		assert.Equal(t, "hap-edge", hdr.Tag)
+		assert.Equal(t, 1, hdr.PID)
+	})
+	t.Run("local without priority", func(t *testing.T) {
+		hdr, msg, err := DecodeSyslog("Feb  6 12:12:56 haproxy: Proxy fnt started.")
+		require.NoError(t, err)
+		assert.Equal(t, "Proxy fnt started.", msg)
+		assert.Equal(t, SyslogRFC3164, hdr.Format)
+		assert.Equal(t, -1, hdr.Facility)
+		assert.Equal(t, "haproxy", hdr.Tag)
+		assert.Equal(t, 0, hdr.PID)
+	})
+	t.Run("rfc5424", func(t *testing.T) {
+		hdr, msg, err := DecodeSyslog(`<134>1 2009-02-06T12:12:56.123456+01:00 lb-1 haproxy 14387 - [meta@32473 dc="waw\"1\]" rack="a3"][origin ip="10.0.0.1"] ` + testSyslogMsg)
+		require.NoError(t, err)
+		assert.Equal(t, testSyslogMsg, msg)
+		assert.Equal(t, SyslogRFC5424, hdr.Format)
+		assert.Equal(t, "lb-1", hdr.Hostname)
+		assert.Equal(t, "haproxy", hdr.Tag)
+		assert.Equal(t, 14387, hdr.PID)
+		assert.Equal(t, "", hdr.MsgID)
+		assert.Equal(t, int64(1233918776123456), hdr.Timestamp.UnixMicro())
+		assert.Equal(t, map[string]map[string]string{
+			"meta@32473": {"dc": `waw"1]`, "rack": "a3"},
+			"origin":     {"ip": "10.0.0.1"},
+		}, hdr.StructuredDataParams())
+
+		hdr, msg, err = DecodeSyslog(`<134>1 2009-02-06T12:12:56+01:00 lb-1 haproxy 14387 - - ` + testSyslogMsg)
+		require.NoError(t, err)
+		assert.Equal(t, testSyslogMsg, msg)
+		assert.Empty(t, hdr.StructuredData)
+		assert.Nil(t, hdr.StructuredDataParams())
+
+		_, _, err = DecodeSyslog(`<134>1 2009-02-06T12:12:56+01:00 lb-1 haproxy`)
+		assert.Error(t, err)
+		_, _, err = DecodeSyslog(`<134>1 2009-02-06T12:12:56+01:00 lb-1 haproxy 1 - [x a="b ` + testSyslogMsg)
+		assert.Error(t, err)
+	})
+	t.Run("timed and iso", func(t *testing.T) {
+		hdr, msg, err := DecodeSyslog("<134>Feb  6 12:12:56 " + testSyslogMsg)
+		require.NoError(t, err)
+		assert.Equal(t, testSyslogMsg, msg)
+		assert.Equal(t, SyslogTimed, hdr.Format)
+		assert.Equal(t, "", hdr.Tag)
+
+		hdr, msg, err = DecodeSyslog("<134>2009-02-06T12:12:56.123456+01:00 " + testSyslogMsg)
+		require.NoError(t, err)
+		assert.Equal(t, testSyslogMsg, msg)
+		assert.Equal(t, SyslogISO, hdr.Format)
+		assert.Equal(t, int64(1233918776123456), hdr.Timestamp.UnixMicro())
+
+		// rsyslog RSYSLOG_FileFormat
+		hdr, msg, err = DecodeSyslog("2022-08-12T13:18:51.123456+02:00 lb1 haproxy[1806]: " + testSyslogMsg)
+		require.NoError(t, err)
+		assert.Equal(t, testSyslogMsg, msg)
+		assert.Equal(t, SyslogISO, hdr.Format)
+		assert.Equal(t, "lb1", hdr.Hostname)
+		assert.Equal(t, "haproxy", hdr.Tag)
+		assert.Equal(t, 1806, hdr.PID)
+	})
+	t.Run("short and raw", func(t *testing.T) {
+		hdr, msg, err := DecodeSyslog("<134>" + testSyslogMsg)
+		require.NoError(t, err)
+		assert.Equal(t, testSyslogMsg, msg)
+		assert.Equal(t, SyslogShort, hdr.Format)
+		assert.Equal(t, 6, hdr.Severity)
+
+		hdr, msg, err = DecodeSyslog(testSyslogMsg)
+		require.NoError(t, err)
+		assert.Equal(t, testSyslogMsg, msg)
+		assert.Equal(t, SyslogRaw, hdr.Format)
+		assert.Equal(t, -1, hdr.Severity)
+
+		_, _, err = DecodeSyslog("<1234>" + testSyslogMsg)
+		assert.Error(t, err)
+	})
+}
+
+func TestDecodeWithSyslog(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	out, err := DecodeTCPLog(`<134>1 2009-02-06T12:12:56+01:00 lb-1 haproxy 14387 - - ` + testSyslogMsg)
+	require.NoError(t, err)
+	assert.Equal(t, 14387, out.PID)
+	assert.Equal(t, "lb-1", out.Syslog.Hostname)
+	assert.Equal(t, "srv1", out.ServerName)
+
+	req, err := DecodeHTTPLog(`<158>83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1_foobar~ backend_foobar-ssl/app3-backend 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/0 "POST /query/q/Sql HTTP/1.1"`)
+	require.NoError(t, err)
+	assert.Equal(t, SyslogShort, req.Syslog.Format)
+	assert.Equal(t, 19, req.Syslog.Facility)
+	assert.Equal(t, "/query/q/Sql", req.RequestPath)
+
+	req, err = DecodeHTTPLog(`2022-08-12T13:18:51.123456+02:00 lb1 haproxy[1806]: 127.0.0.1:34636 [12/Aug/2022:13:18:51.123] front1 app/app1 0/0/1/2/3 200 100 - - ---- 1/1/1/1/0 0/0 "GET / HTTP/1.1"`)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", req.ClientIP)
+	assert.Equal(t, uint16(34636), req.ClientPort)
+	assert.Equal(t, 1806, req.PID)
+	assert.Equal(t, "lb1", req.Syslog.Hostname)
+
+	_, err = DecodeLog("<134>Feb  6 12:12:56 some text")
+	assert.Error(t, err)
+}
+
+func TestSyslogHeaderString(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	for _, line := range []string{
+		"<134>Feb  6 12:12:56 haproxy[14387]: ",
+		"<134>Feb 16 12:12:56 lb-1.example.com hap-edge[1]: ",
+		"Feb 16 12:12:56 haproxy: ",
+		"<134>Feb 16 12:12:56 ",
+		"<134>2009-02-06T12:12:56.123456+01:00 ",
+		"2009-02-06T12:12:56.123456+01:00 lb-1 haproxy[1806]: ",
+		"<134>1 2009-02-06T12:12:56.000000+01:00 lb-1 haproxy 14387 - [meta sequenceId=\"1\"] ",
+		"<134>1 - - haproxy - - - ",
+		"<134>",
+	} {
+		hdr, _, err := DecodeSyslog(line + testSyslogMsg)
+		require.NoError(t, err)
+		assert.Equal(t, line, hdr.String())
+	}
+}
@@ -0,0 +1,139 @@
+package haproxy
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Haproxy tcplog line regexp
+var haproxyTCPRegex = regexp.MustCompile(
+	`^(.+?):(\d+) \[(.+?)\] (.+?)(|[\~]) (.+?)\/(.+?) ([\-\d]+)\/([\-\d]+)\/(\+?[\-\d]+) (\+?\d+) (\S{2}) (\d+)\/(\d+)\/(\d+)\/(\d+)\/(\+?\d+) (\d+)\/(\d+)([\n|\s]*?)$`)
+
+// HAProxy tcp log format
+// https://cbonte.github.io/haproxy-dconv/2.0/configuration.html#8.2.2
+type TCPRequest struct {
+	TS           int64  `json:"ts_us"`
+	PID          int    `json:"pid"`
+	ClientIP     string `json:"client_ip"`
+	ClientPort   uint16 `json:"client_port"`
+	ClientSSL    bool   `json:"client_ssl"`
+	FrontendName string `json:"frontend_name"`
+	BackendName  string `json:"backend_name"`
+	ServerName   string `json:"server_name"`
+	BytesRead    uint64 `json:"bytes_read"`
+
+	// timings
+	// aborted connections are marked via -1 by haproxy
+	QueueDurationMs      int `json:"queue_duration_ms"`       // Tw
+	ServerConnDurationMs int `json:"server_conn_duration_ms"` // Tc
+	TotalDurationMs      int `json:"total_duration_ms"`       // Tt
+
+	// Connection state
+	TerminationReason rune `json:"termination_reason"`
+	SessionCloseState rune `json:"session_close_state"`
+
+	// conn count stats (per-pid
+	TotalConn    uint `json:"total_conn"`
+	FrontendConn uint `json:"frontend_conn"`
+	BackendConn  uint `json:"backend_conn"`
+	ServerConn   uint `json:"server_conn"`
+	Retries      uint `json:"retries"`
+	ServerQueue  uint `json:"server_queue"`
+	BackendQueue uint `json:"backend_queue"`
+
+	// flags
+	// connection was redispatched to other server after retries (+ before retries count)
+	Redispatched bool `json:"redispatched"`
+	// logged before connection ended (option logasap, + before total time and bytes)
+	LogASAP bool `json:"log_asap"`
+
+	// syslog envelope, PID is copied from it
+	Syslog SyslogHeader `json:"syslog"`
+}
+
+// Decode haproxy `option tcplog` line
+func DecodeTCPLog(s string) (TCPRequest, error) {
+	var r TCPRequest
+	var err error
+	var parse_err []error
+	r.Syslog, s, err = DecodeSyslog(s)
+	if err != nil {
+		return r, err
+	}
+	r.PID = r.Syslog.PID
+	matches := haproxyTCPRegex.FindStringSubmatch(s)
+	if len(matches) < 20 {
+		return r, errors.New("input not matching regex")
+	}
+	r.ClientIP = matches[1]
+
+	ui16_cp, err := strconv.ParseUint(matches[2], 10, 16)
+	parse_err = append(parse_err, err)
+	r.ClientPort = uint16(ui16_cp)
+
+	ts, err := decodeTs(matches[3])
+	parse_err = append(parse_err, err)
+	r.TS = ts.UnixMicro()
+
+	r.FrontendName = matches[4]
+	if matches[5] == `~` {
+		r.ClientSSL = true
+	}
+	r.BackendName = matches[6]
+	r.ServerName = matches[7]
+
+	r.QueueDurationMs, err = strconv.Atoi(matches[8])
+	parse_err = append(parse_err, err)
+	r.ServerConnDurationMs, err = strconv.Atoi(matches[9])
+	parse_err = append(parse_err, err)
+	if strings.HasPrefix(matches[10], "+") {
+		r.LogASAP = true
+	}
+	r.TotalDurationMs, err = strconv.Atoi(strings.TrimPrefix(matches[10], "+"))
+	parse_err = append(parse_err, err)
+	r.BytesRead, err = strconv.ParseUint(strings.TrimPrefix(matches[11], "+"), 10, 64)
+	parse_err = append(parse_err, err)
+
+	r.TerminationReason = rune(matches[12][0])
+	r.SessionCloseState = rune(matches[12][1])
+
+	var u uint64
+	u, err = strconv.ParseUint(matches[13], 10, 32)
+	parse_err = append(parse_err, err)
+	r.TotalConn = uint(u)
+	u, err = strconv.ParseUint(matches[14], 10, 32)
+	parse_err = append(parse_err, err)
+	r.FrontendConn = uint(u)
+	u, err = strconv.ParseUint(matches[15], 10, 32)
+	parse_err = append(parse_err, err)
+	r.BackendConn = uint(u)
+	u, err = strconv.ParseUint(matches[16], 10, 32)
+	parse_err = append(parse_err, err)
+	r.ServerConn = uint(u)
+	if strings.HasPrefix(matches[17], "+") {
+		r.Redispatched = true
+	}
+	u, err = strconv.ParseUint(strings.TrimPrefix(matches[17], "+"), 10, 32)
+	parse_err = append(parse_err, err)
+	r.Retries = uint(u)
+	u, err = strconv.ParseUint(matches[18], 10, 32)
+	parse_err = append(parse_err, err)
+	r.ServerQueue = uint(u)
+	u, err = strconv.ParseUint(matches[19], 10, 32)
+	parse_err = append(parse_err, err)
+	r.BackendQueue = uint(u)
+
+	for _, element := range parse_err {
+		if element != nil {
+			return r, element
+		}
+	}
+	return r, nil
+}
+
+func (t TCPRequest) Timestamp() time.Time {
+	return time.UnixMicro(t.TS)
+}
@@ -0,0 +1,89 @@
+package haproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPLogParsing(t *testing.T) {
+	HaproxyLogTimezone = time.UTC
+	s := `<158>Feb  6 12:12:56 haproxy[14387]: 10.0.1.2:33313 [06/Feb/2009:12:12:51.443] fnt~ bck/srv1 0/0/5007 212 -- 0/0/0/0/3 0/0`
+	out, err := DecodeTCPLog(s)
+	require.NoError(t, err)
+	a := assert.New(t)
+	a.EqualValues(1233922371443000, out.TS)
+	a.Equal(time.UnixMicro(1233922371443000), out.Timestamp())
+	a.Equal(14387, out.PID)
+	a.Equal("10.0.1.2", out.ClientIP)
+	a.EqualValues(33313, out.ClientPort)
+	a.True(out.ClientSSL)
+	a.Equal("fnt", out.FrontendName)
+	a.Equal("bck", out.BackendName)
+	a.Equal("srv1", out.ServerName)
+	a.Equal(0, out.QueueDurationMs)
+	a.Equal(0, out.ServerConnDurationMs)
+	a.Equal(5007, out.TotalDurationMs)
+	a.EqualValues(212, out.BytesRead)
+	a.EqualValues(TerminationNone, out.TerminationReason)
+	a.EqualValues(SessionCloseNone, out.SessionCloseState)
+	a.EqualValues(3, out.Retries)
+	a.False(out.Redispatched)
+	a.False(out.LogASAP)
+
+	t.Run("aborted, logasap, redispatched", func(t *testing.T) {
+		out, err := DecodeTCPLog(`<158>Feb  6 12:12:56 haproxy[14387]: 10.0.1.2:33313 [06/Feb/2009:12:12:51.443] fnt bck/<NOSRV> -1/-1/+5007 +212 SC 10/5/4/3/+3 1/2`)
+		require.NoError(t, err)
+		assert.Equal(t, -1, out.QueueDurationMs)
+		assert.Equal(t, 5007, out.TotalDurationMs)
+		assert.EqualValues(t, 212, out.BytesRead)
+		assert.True(t, out.LogASAP)
+		assert.True(t, out.Redispatched)
+		assert.EqualValues(t, 3, out.Retries)
+		assert.EqualValues(t, 10, out.TotalConn)
+		assert.EqualValues(t, 3, out.ServerConn)
+		assert.EqualValues(t, 2, out.BackendQueue)
+		assert.EqualValues(t, TerminationServerAbort, out.TerminationReason)
+		assert.EqualValues(t, SessionCloseConnection, out.SessionCloseState)
+	})
+	t.Run("http line", func(t *testing.T) {
+		_, err := DecodeTCPLog(`<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1_foobar~ backend_foobar-ssl/app3-backend 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/0 "POST /query/q/Sql HTTP/1.1"`)
+		assert.Error(t, err)
+	})
+}
+
+func TestDecodeLog(t *testing.T) {
+	out, err := DecodeLog(`<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1_foobar~ backend_foobar-ssl/app3-backend 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/0 "POST /query/q/Sql HTTP/1.1"`)
+	require.NoError(t, err)
+	require.IsType(t, HTTPRequest{}, out)
+	assert.Equal(t, "/query/q/Sql", out.(HTTPRequest).RequestPath)
+
+	out, err = DecodeLog(`<158>Feb  6 12:12:56 haproxy[14387]: 10.0.1.2:33313 [06/Feb/2009:12:12:51.443] fnt bck/srv1 0/0/5007 212 -- 0/0/0/0/3 0/0`)
+	require.NoError(t, err)
+	require.IsType(t, TCPRequest{}, out)
+	assert.Equal(t, "srv1", out.(TCPRequest).ServerName)
+
+	out, err = DecodeLog(`<156>Aug 12 13:18:51 haproxy[1806]: SPOE: [hauth] <EVENT:on-frontend-http-request> sid=3 st=2 -1/-1/-1/-1/0 0/0 0/0 4/4`)
+	require.NoError(t, err)
+	require.IsType(t, SPOEEvent{}, out)
+	assert.Equal(t, 1806, out.(SPOEEvent).PID)
+	assert.Equal(t, "SPOE: [hauth] <EVENT:on-frontend-http-request> sid=3 st=2 -1/-1/-1/-1/0 0/0 0/0 4/4", out.(SPOEEvent).Message)
+	assert.Equal(t, "haproxy", out.(SPOEEvent).Syslog.Tag)
+
+	out, err = DecodeLog(`<133>Aug 12 13:31:41 haproxy[1806]: Health check for server local-3001/local-3001 succeeded`)
+	require.NoError(t, err)
+	require.IsType(t, RawLog{}, out)
+	assert.Equal(t, 1806, out.(RawLog).PID)
+
+	_, err = DecodeLog("23ej87thfdsg623gtr")
+	assert.Error(t, err)
+
+	lines, err := readLines("t-data/haproxy_log_spoa")
+	require.NoError(t, err)
+	for _, line := range lines {
+		_, err := DecodeLog(line)
+		assert.NoError(t, err, line)
+	}
+}
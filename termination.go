@@ -0,0 +1,164 @@
+package haproxy
+
+// TerminationState is session state at disconnection as logged by haproxy (%tsc in httplog, %ts in tcplog),
+// like "sD--" or "CR--". See const.go for meaning of the characters
+type TerminationState string
+
+// TerminationClass says which side a session ended because of
+type TerminationClass string
+
+const (
+	// completed normally, or handled by haproxy itself (stats, redirects)
+	TerminationOK TerminationClass = "ok"
+	// client aborted or timed out
+	TerminationClientSide TerminationClass = "client"
+	// server aborted, timed out or went down
+	TerminationServerSide TerminationClass = "server"
+	// haproxy denied, ran out of resources, hit internal error or connection was killed
+	TerminationProxySide TerminationClass = "proxy"
+	TerminationUnknown   TerminationClass = "unknown"
+)
+
+var terminationReasons = map[rune]string{
+	TerminationClientAbort:     "session aborted by the client",
+	TerminationServerAbort:     "session aborted or refused by the server",
+	TerminationDeny:            "session aborted by the proxy (deny, tarpit, connection limit or security check)",
+	TerminationLocal:           "session processed locally by the proxy, not passed to a server",
+	TerminationExhausted:       "proxy resource exhausted (memory, sockets, source ports)",
+	TerminationInternalError:   "proxy internal error",
+	TerminationServerGoingDown: "session killed because the server went down",
+	TerminationActiveUp:        "session on backup server killed because an active server came up",
+	TerminationAdmin:           "session killed by admin",
+	TerminationClientWait:      "client-side timeout",
+	TerminationServerWait:      "server-side timeout",
+	TerminationNone:            "normal completion",
+}
+
+var sessionCloseStates = map[rune]string{
+	SessionCloseRequest:    "waiting for complete request from the client",
+	SessionCloseQueue:      "waiting in the queue for a connection slot",
+	SessionCloseConnection: "waiting for connection to the server",
+	SessionCloseHeaders:    "waiting for response headers from the server",
+	SessionCloseData:       "in the data phase",
+	SessionCloseLast:       "transmitting last data to the client after server finished",
+	SessionCloseTarpit:     "request was tarpitted",
+	SessionCloseNone:       "normal completion after end of data transfer",
+}
+
+var clientCookieStates = map[rune]string{
+	ClientCookieNone:    "no cookie from the client",
+	ClientCookieInvalid: "invalid cookie from the client",
+	ClientCookieDown:    "cookie pointed to a server that was down",
+	ClientCookieValid:   "valid cookie from the client",
+	ClientCookieExpired: "cookie expired (idle for too long)",
+	ClientCookieOld:     "cookie too old",
+	ClientCookieUnused:  "persistence cookie not used",
+}
+
+var serverCookieStates = map[rune]string{
+	ServerCookieNone:      "no cookie from the server, none inserted",
+	ServerCookieInserted:  "cookie inserted by the proxy",
+	ServerCookieUpdated:   "cookie updated by the proxy",
+	ServerCookiePassed:    "cookie from the server passed as is",
+	ServerCookieRewritten: "cookie from the server rewritten by the proxy",
+	ServerCookieDeleted:   "cookie from the server deleted by the proxy",
+	ServerCookieUnused:    "persistence cookie not used",
+}
+
+func (t TerminationState) char(i int) rune {
+	if i >= len(t) {
+		return '-'
+	}
+	return rune(t[i])
+}
+
+// Reason is the first character, what caused the session to end
+func (t TerminationState) Reason() rune { return t.char(0) }
+
+// Phase is the second character, session state when it ended
+func (t TerminationState) Phase() rune { return t.char(1) }
+
+// ClientCookie is the third character, state of persistence cookie sent by the client (httplog only)
+func (t TerminationState) ClientCookie() rune { return t.char(2) }
+
+// ServerCookie is the fourth character, state of persistence cookie sent by the server (httplog only)
+func (t TerminationState) ServerCookie() rune { return t.char(3) }
+
+// ReasonDescription describes Reason, empty for unknown characters
+func (t TerminationState) ReasonDescription() string { return terminationReasons[t.Reason()] }
+
+// PhaseDescription describes Phase
+func (t TerminationState) PhaseDescription() string { return sessionCloseStates[t.Phase()] }
+
+// ClientCookieDescription describes ClientCookie
+func (t TerminationState) ClientCookieDescription() string {
+	return clientCookieStates[t.ClientCookie()]
+}
+
+// ServerCookieDescription describes ServerCookie
+func (t TerminationState) ServerCookieDescription() string {
+	return serverCookieStates[t.ServerCookie()]
+}
+
+// Description describes reason and phase, e.g. "server-side timeout, in the data phase" for sD--
+func (t TerminationState) Description() string {
+	reason, phase := t.ReasonDescription(), t.PhaseDescription()
+	if reason == "" || phase == "" {
+		return "unknown termination state " + string(t)
+	}
+	if t.Reason() == TerminationNone && t.Phase() == SessionCloseNone {
+		return reason
+	}
+	return reason + ", " + phase
+}
+
+// Class classifies which side ended the session
+func (t TerminationState) Class() TerminationClass {
+	switch t.Reason() {
+	case TerminationNone, TerminationLocal:
+		return TerminationOK
+	case TerminationClientAbort, TerminationClientWait:
+		return TerminationClientSide
+	case TerminationServerAbort, TerminationServerWait, TerminationServerGoingDown:
+		return TerminationServerSide
+	case TerminationDeny, TerminationExhausted, TerminationInternalError, TerminationActiveUp, TerminationAdmin:
+		return TerminationProxySide
+	}
+	return TerminationUnknown
+}
+
+// IsOK returns true if session ended normally or was handled by haproxy
+func (t TerminationState) IsOK() bool { return t.Class() == TerminationOK }
+
+// IsClientAbort returns true if client closed the connection (C)
+func (t TerminationState) IsClientAbort() bool { return t.Reason() == TerminationClientAbort }
+
+// IsClientTimeout returns true if client-side timeout expired (c)
+func (t TerminationState) IsClientTimeout() bool { return t.Reason() == TerminationClientWait }
+
+// IsServerAbort returns true if server closed or refused the connection (S)
+func (t TerminationState) IsServerAbort() bool { return t.Reason() == TerminationServerAbort }
+
+// IsServerTimeout returns true if server-side timeout expired (s)
+func (t TerminationState) IsServerTimeout() bool { return t.Reason() == TerminationServerWait }
+
+// IsTimeout returns true for either client or server-side timeout
+func (t TerminationState) IsTimeout() bool { return t.IsClientTimeout() || t.IsServerTimeout() }
+
+// IsDenied returns true if proxy aborted the session (P), e.g. because of deny rule
+func (t TerminationState) IsDenied() bool { return t.Reason() == TerminationDeny }
+
+// IsQueueTimeout returns true if session ended while waiting in the queue (sQ, cQ)
+func (t TerminationState) IsQueueTimeout() bool {
+	return t.IsTimeout() && t.Phase() == SessionCloseQueue
+}
+
+// TerminationState returns termination state of the request
+func (h HTTPRequest) TerminationState() TerminationState {
+	return TerminationState([]rune{h.TerminationReason, h.SessionCloseState, h.ClientPersistenceState, h.PersistenceCookieState})
+}
+
+// TerminationState returns termination state of the connection, cookie states are not logged for tcp
+func (t TCPRequest) TerminationState() TerminationState {
+	return TerminationState([]rune{t.TerminationReason, t.SessionCloseState})
+}
@@ -0,0 +1,54 @@
+package haproxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminationState(t *testing.T) {
+	for state, class := range map[TerminationState]TerminationClass{
+		"----": TerminationOK,
+		"LR--": TerminationOK,
+		"CR--": TerminationClientSide,
+		"cD--": TerminationClientSide,
+		"sD--": TerminationServerSide,
+		"SC--": TerminationServerSide,
+		"PR--": TerminationProxySide,
+		"KD--": TerminationProxySide,
+		"xx--": TerminationUnknown,
+		"":     TerminationOK,
+	} {
+		assert.Equal(t, class, state.Class(), string(state))
+	}
+	s := TerminationState("sD--")
+	assert.True(t, s.IsServerTimeout())
+	assert.True(t, s.IsTimeout())
+	assert.False(t, s.IsClientAbort())
+	assert.False(t, s.IsQueueTimeout())
+	assert.Equal(t, "server-side timeout, in the data phase", s.Description())
+	assert.True(t, TerminationState("CR--").IsClientAbort())
+	assert.True(t, TerminationState("sQ--").IsQueueTimeout())
+	assert.True(t, TerminationState("PR--").IsDenied())
+	assert.True(t, TerminationState("----").IsOK())
+	assert.Equal(t, "normal completion", TerminationState("----").Description())
+	assert.Contains(t, TerminationState("??--").Description(), "unknown")
+
+	s = TerminationState("--VN")
+	assert.Equal(t, ClientCookieValid, s.ClientCookie())
+	assert.Equal(t, ServerCookieNone, s.ServerCookie())
+	assert.Equal(t, "valid cookie from the client", s.ClientCookieDescription())
+	assert.Equal(t, "no cookie from the server, none inserted", s.ServerCookieDescription())
+	assert.Equal(t, "persistence cookie not used", TerminationState("--").ClientCookieDescription())
+}
+
+func TestRequestTerminationState(t *testing.T) {
+	r, err := DecodeHTTPLog(`<158>Jul 23 13:49:13 haproxy[11446]: 83.3.255.169:61059 [23/Jul/2015:13:49:11.933] front1_foobar~ backend_foobar-ssl/app3-backend 1294/0/1/52/1348 200 1140 - - --VN 1637/7/5/6/0 0/0 "POST /query/q/Sql HTTP/1.1"`)
+	require.NoError(t, err)
+	assert.Equal(t, TerminationState("--VN"), r.TerminationState())
+	tcp, err := DecodeTCPLog(`<158>Feb  6 12:12:56 haproxy[14387]: 10.0.1.2:33313 [06/Feb/2009:12:12:51.443] fnt bck/srv1 0/0/5007 212 sD 0/0/0/0/3 0/0`)
+	require.NoError(t, err)
+	assert.Equal(t, TerminationState("sD"), tcp.TerminationState())
+	assert.Equal(t, TerminationServerSide, tcp.TerminationState().Class())
+}